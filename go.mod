@@ -1,3 +1,17 @@
 module DHT-2022
 
-go 1.13
+go 1.25.0
+
+require (
+	github.com/fatih/color v1.19.0
+	github.com/sirupsen/logrus v1.10.2
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/stretchr/objx v0.5.3 // indirect
+	github.com/stretchr/testify v1.12.1 // indirect
+	go.yaml.in/yaml/v3 v3.0.5 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+)
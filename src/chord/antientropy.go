@@ -0,0 +1,141 @@
+package chord
+
+import (
+	"dht"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"merkle"
+	"strconv"
+	"time"
+)
+
+//how often the owner compares its pairs with each replica holder
+const antiEntropyPeriod = 2 * time.Second
+
+//MerkleArg asks for hashes of the Merkle tree over the backups in (From, To].
+//The round trips of one comparison share Round and Sender, and are answered from the same tree.
+type MerkleArg struct {
+	Round uint64
+	//address of the node comparing, so the rounds of two nodes never share a tree
+	Sender  string
+	From    *big.Int
+	To      *big.Int
+	Depth   int
+	Level   int
+	Indexes []int
+}
+
+//SyncBackupArg makes the backups in (From, To] which fall into Leaves equal to Items.
+type SyncBackupArg struct {
+	From   *big.Int
+	To     *big.Int
+	Depth  int
+	Leaves []int
+	Items  map[string]DataItem
+}
+
+func item_digest(item DataItem) []byte {
+	expire := ""
+	if !item.Expire.IsZero() {
+		expire = strconv.FormatInt(item.Expire.UnixNano(), 10)
+	}
+	return merkle.Digest(item.Value, strconv.FormatUint(item.Version, 10), expire)
+}
+
+func items_tree(items map[string]DataItem, depth int) *merkle.Tree {
+	digests := make(map[string][]byte, len(items))
+	for key, item := range items {
+		digests[key] = item_digest(item)
+	}
+	return merkle.Build(depth, digests)
+}
+
+//compare the pairs in (predecessor, this] with the backups of every replica holder
+func (this *ChordNode) anti_entropy() {
+	this.rwLock.RLock()
+	pred := this.predecessor
+	this.rwLock.RUnlock()
+	if pred == "" || pred == this.get_address() {
+		return
+	}
+	for _, addr := range this.replica_list() {
+		tmp_err := this.sync_replica(addr, NodeID(pred))
+		if tmp_err != nil {
+			log.Warningln("In function anti_entropy can not sync backups in", addr, tmp_err)
+		}
+	}
+}
+
+//send the pairs of the leaves where the backups of addr differ from dataSet
+func (this *ChordNode) sync_replica(addr string, from *big.Int) error {
+	this.dataLock.RLock()
+	items := this.dataSet.RangeByHash(from, this.get_id(), true)
+	this.dataLock.RUnlock()
+	//ForceQuit stops the loops before reset empties dataSet: if the node still runs after
+	//the copy, the copy holds its pairs, otherwise an empty copy would wipe the replicas
+	this.rwLock.RLock()
+	running := this.conRoutineFlag
+	this.rwLock.RUnlock()
+	if !running {
+		return nil
+	}
+	tree := items_tree(items, merkle.DefaultDepth)
+	round := merkle.NewRound()
+	leaves, tmp_err := tree.Diff(func(level int, indexes []int) ([][]byte, error) {
+		var res [][]byte
+		arg := MerkleArg{Round: round, Sender: this.get_address(), From: from, To: this.get_id(), Depth: tree.Depth(), Level: level, Indexes: indexes}
+		tmp_err := this.call(addr, "WrapNode.MerkleNodes", arg, &res)
+		return res, tmp_err
+	})
+	if tmp_err != nil {
+		return dht.FromRemote(tmp_err)
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+	arg := SyncBackupArg{From: from, To: this.get_id(), Depth: tree.Depth(), Leaves: leaves, Items: make(map[string]DataItem)}
+	for key, item := range items {
+		if merkle.Contains(leaves, key, tree.Depth()) {
+			arg.Items[key] = item
+		}
+	}
+	log.Infoln("In function sync_replica", addr, "differs in", len(leaves), "leaves of", this.get_address())
+	var o string
+	tmp_err = this.call(addr, "WrapNode.SyncBackup", arg, &o)
+	if tmp_err != nil {
+		return fmt.Errorf("can not sync %d leaves: %w", len(leaves), dht.FromRemote(tmp_err))
+	}
+	return nil
+}
+
+func (this *ChordNode) merkle_nodes(arg MerkleArg, res *[][]byte) error {
+	id := fmt.Sprint(arg.Sender, ":", arg.Round, ":", arg.From.Text(16), ":", arg.To.Text(16), ":", arg.Depth)
+	tree := this.merkleTrees.Get(id)
+	if tree == nil {
+		this.backupLock.RLock()
+		items := this.backupSet.RangeByHash(arg.From, arg.To, true)
+		this.backupLock.RUnlock()
+		tree = items_tree(items, arg.Depth)
+		this.merkleTrees.Put(id, tree)
+	}
+	*res = tree.Nodes(arg.Level, arg.Indexes)
+	return nil
+}
+
+func (this *ChordNode) sync_backup(arg SyncBackupArg) error {
+	this.backupLock.Lock()
+	defer this.backupLock.Unlock()
+	for key, item := range this.backupSet.RangeByHash(arg.From, arg.To, true) {
+		if !merkle.Contains(arg.Leaves, key, arg.Depth) {
+			continue
+		}
+		if _, ok := arg.Items[key]; !ok {
+			this.backupSet.Delete(key)
+		} else if item.Version > arg.Items[key].Version {
+			//mirrored after the owner built its tree
+			delete(arg.Items, key)
+		}
+	}
+	return storagePutAll(this.backupSet, arg.Items)
+}
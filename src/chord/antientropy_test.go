@@ -0,0 +1,120 @@
+package chord_test
+
+import (
+	"bytes"
+	"chord"
+	"dht"
+	"fmt"
+	"merkle"
+	"sim"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAntiEntropyRepair(t *testing.T) {
+	const size = 3
+	s := sim.New(1)
+	conf := memory_config()
+	conf.AdvertiseAddress = "entropy"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, 23000+i))
+	}
+	seed := addrs[0]
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	//addrs[1] keeps the backups of the pairs of addrs[0]
+	owner, holder := addrs[0], addrs[1]
+	var keys []string
+	for i := 0; len(keys) < 10; i++ {
+		if key := fmt.Sprint("key", i); in_range(chord.ConsistentHash(key), chord.NodeID(addrs[2]), chord.NodeID(owner)) {
+			keys = append(keys, key)
+		}
+	}
+	lost := keys[0]
+	stale := "stale"
+	for i := 0; !in_range(chord.ConsistentHash(stale), chord.NodeID(addrs[2]), chord.NodeID(owner)); i++ {
+		stale = fmt.Sprint("stale", i)
+	}
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	var joinErr, putErr, callErr error
+	var firstRoot, otherRoot, againRoot [][]byte
+	var damaged, repaired chord.StoredKeys
+	s.Run(func() {
+		var ring []*chord.ChordNode
+		for i := 0; i < size; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(23000+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(seed); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		s.Sleep(30 * time.Second)
+		defer func() {
+			for _, node := range ring {
+				node.ForceQuit()
+			}
+		}()
+		for _, key := range keys {
+			if tmp_err := ring[2].Put(key, "value"); tmp_err != nil && putErr == nil {
+				putErr = tmp_err
+			}
+		}
+		//the clock stands still until the Sleep below, so no maintenance runs meanwhile
+		arg := chord.MerkleArg{Round: 7, Sender: owner, From: chord.NodeID(addrs[2]), To: chord.NodeID(owner),
+			Depth: merkle.DefaultDepth, Level: 0, Indexes: []int{0}}
+		call := func(aimFunc string, input interface{}, res interface{}) {
+			if tmp_err := chord.RemoteCall(holder, aimFunc, input, res); tmp_err != nil && callErr == nil {
+				callErr = fmt.Errorf("%s: %w", aimFunc, tmp_err)
+			}
+		}
+		var o string
+		call("WrapNode.MerkleNodes", arg, &firstRoot)
+		//a backup the owner does not have, and a backup lost
+		call("WrapNode.AddBackup", map[string]chord.DataItem{stale: {Value: "stale", Version: 1}}, &o)
+		call("WrapNode.ErasePairInBackup", lost, &o)
+		//the same round of another node gets a tree of its own, the node of the round keeps its tree
+		arg.Sender = addrs[2]
+		call("WrapNode.MerkleNodes", arg, &otherRoot)
+		arg.Sender = owner
+		call("WrapNode.MerkleNodes", arg, &againRoot)
+		call("WrapNode.StoredKeys", 0, &damaged)
+		//a round of anti-entropy
+		s.Sleep(5 * time.Second)
+		call("WrapNode.StoredKeys", 0, &repaired)
+	})
+	if joinErr != nil || putErr != nil || callErr != nil {
+		t.Fatalf("join: %v, put: %v, call: %v", joinErr, putErr, callErr)
+	}
+	if len(firstRoot) != 1 || len(otherRoot) != 1 || len(againRoot) != 1 {
+		t.Fatalf("roots are %x, %x and %x", firstRoot, otherRoot, againRoot)
+	}
+	if bytes.Equal(firstRoot[0], otherRoot[0]) {
+		t.Errorf("the round of another node is answered from the tree built before the backups changed")
+	}
+	if !bytes.Equal(firstRoot[0], againRoot[0]) {
+		t.Errorf("the later round trips of a round are not answered from its tree")
+	}
+	if has_key(damaged.Backup, lost) || !has_key(damaged.Backup, stale) {
+		t.Fatalf("the backups of %s are not damaged: %v", holder, damaged.Backup)
+	}
+	for _, key := range keys {
+		if !has_key(repaired.Backup, key) {
+			t.Errorf("the backup of %s is not repaired in %s", key, holder)
+		}
+	}
+	if has_key(repaired.Backup, stale) {
+		t.Errorf("the backup %s the owner does not have is kept in %s", stale, holder)
+	}
+}
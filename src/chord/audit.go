@@ -0,0 +1,312 @@
+package chord
+
+import (
+	"context"
+	"dht"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"sort"
+)
+
+//CheckRing walks a ring by successor pointers and checks that
+//  - the predecessor of every node is the node whose successor it is,
+//  - the ring goes round the 2^160 space exactly once,
+//  - every key in the dataSet of a node hashes into (predecessor, node],
+//  - every key has a backup in one of the replica holders of its node.
+//With repair the offending nodes are asked to fix themselves.
+
+//a node which has more wrong keys than this only reports the first ones
+const auditKeySamples = 5
+
+//a walk longer than this is given up
+const auditMaxNodes = 100000
+
+type Violation struct {
+	Node string
+	//"successor", "predecessor", "coverage", "misplaced-key" or "missing-backup"
+	Kind   string
+	Detail string
+}
+
+type RingReport struct {
+	//nodes in the order of the walk
+	Nodes      []string
+	Violations []Violation
+	//repairs which were asked for, such as "stabilize 192.168.1.2:20000"
+	Repairs []string
+}
+
+func (this *RingReport) add(node string, kind string, format string, args ...interface{}) {
+	this.Violations = append(this.Violations, Violation{node, kind, fmt.Sprintf(format, args...)})
+}
+
+//StoredKeys are the keys kept by a node, used by CheckRing
+type StoredKeys struct {
+	Data     []string
+	Backup   []string
+	Replicas int
+}
+
+type RepairArg struct {
+	//run stabilize, so the successor is refreshed and notified
+	Stabilize bool
+	//hand the pairs not in (predecessor, node] to their owners
+	Rehome bool
+	//push the whole dataSet to the replica holders again
+	Backups bool
+}
+
+func (this *ChordNode) stored_keys(res *StoredKeys) error {
+	now := this.config.Clock.Now()
+	this.dataLock.RLock()
+	this.dataSet.Iterate(func(key string, item DataItem) bool {
+		if !item.Expired(now) {
+			res.Data = append(res.Data, key)
+		}
+		return true
+	})
+	this.dataLock.RUnlock()
+	this.backupLock.RLock()
+	this.backupSet.Iterate(func(key string, item DataItem) bool {
+		if !item.Expired(now) {
+			res.Backup = append(res.Backup, key)
+		}
+		return true
+	})
+	this.backupLock.RUnlock()
+	res.Replicas = this.config.Replicas
+	return nil
+}
+
+func (this *ChordNode) repair(arg RepairArg) error {
+	if arg.Stabilize {
+		tmp_err := this.stabilize()
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	if arg.Rehome {
+		tmp_err := this.rehome_data()
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	if arg.Backups {
+		//forget the replica holders, so replicate pushes to all of them
+		this.rwLock.Lock()
+		this.replicaList = nil
+		this.rwLock.Unlock()
+		this.replicate()
+	}
+	return nil
+}
+
+//hand the pairs outside (predecessor, this] to their owners with AddData
+func (this *ChordNode) rehome_data() error {
+	this.rwLock.RLock()
+	pred := this.predecessor
+	this.rwLock.RUnlock()
+	if pred == "" {
+		return nil
+	}
+	this.dataLock.RLock()
+	misplaced := this.dataSet.RangeByHash(this.get_id(), NodeID(pred), true)
+	this.dataLock.RUnlock()
+	return this.hand_to_owners(misplaced, func(data map[string]DataItem) {
+		this.dataLock.Lock()
+		drop_handed(this.dataSet, data)
+		this.dataLock.Unlock()
+	})
+}
+
+//hand the pairs to their owners with AddData, leaving out those this node owns,
+//and call handed with the pairs of each owner which took them
+func (this *ChordNode) hand_to_owners(items map[string]DataItem, handed func(data map[string]DataItem)) error {
+	byOwner := make(map[string]map[string]DataItem)
+	for key, item := range items {
+		var owner string
+		tmp_err := this.innner_find_successor(context.Background(), ConsistentHash(key), &owner)
+		if tmp_err != nil {
+			return tmp_err
+		}
+		if owner == this.get_address() {
+			continue
+		}
+		if byOwner[owner] == nil {
+			byOwner[owner] = make(map[string]DataItem)
+		}
+		byOwner[owner][key] = item
+	}
+	for owner, data := range byOwner {
+		var o string
+		tmp_err := this.call(owner, "WrapNode.AddData", data, &o)
+		if tmp_err != nil {
+			log.Errorln("In function hand_to_owners can not hand pairs to", owner)
+			return tmp_err
+		}
+		handed(data)
+	}
+	return nil
+}
+
+//delete the handed pairs from s, but keep the pairs written again in the meantime
+func drop_handed(s Storage, data map[string]DataItem) {
+	for key, item := range data {
+		now, ok := s.Get(key)
+		if ok && now.Version == item.Version {
+			s.Delete(key)
+		}
+	}
+}
+
+//CheckRing checks the ring which seed is in, see the invariants above.
+func CheckRing(ctx context.Context, seed string, repair bool) (*RingReport, error) {
+	report := new(RingReport)
+	//walk by the first online successor until the walk comes back
+	index := make(map[string]int)
+	cur := seed
+	for {
+		if ctx.Err() != nil {
+			return report, dht.RemoteError(ctx, ctx.Err())
+		}
+		if _, ok := index[cur]; ok {
+			if cur != seed {
+				report.add(cur, "coverage", "the walk from %s comes back to %s instead of %s", seed, cur, seed)
+			}
+			break
+		}
+		if len(report.Nodes) >= auditMaxNodes {
+			return report, fmt.Errorf("the walk from %s is longer than %d nodes", seed, auditMaxNodes)
+		}
+		index[cur] = len(report.Nodes)
+		report.Nodes = append(report.Nodes, cur)
+		var succList [successorListLength]string
+		tmp_err := RemoteCallContext(ctx, cur, "WrapNode.GetSuccessorList", 0, &succList)
+		if tmp_err != nil {
+			if cur == seed {
+				return report, dht.RemoteError(ctx, tmp_err)
+			}
+			report.add(cur, "successor", "can not get the successor list: %v", tmp_err)
+			break
+		}
+		next := ""
+		for i, succ := range succList {
+			if succ == "" {
+				continue
+			}
+			if CheckOnlineContext(ctx, succ) {
+				next = succ
+				break
+			}
+			if i == 0 {
+				report.add(cur, "successor", "successor %s is offline", succ)
+			}
+		}
+		if next == "" {
+			report.add(cur, "successor", "no successor is online")
+			break
+		}
+		cur = next
+	}
+	nodes := report.Nodes
+	ids := make([]*big.Int, len(nodes))
+	for i, addr := range nodes {
+		ids[i] = NodeID(addr)
+	}
+	prev := func(i int) int {
+		return (i + len(nodes) - 1) % len(nodes)
+	}
+	//the walk should go up the ring and wrap exactly once
+	if len(nodes) > 1 {
+		wraps := 0
+		for i := range nodes {
+			if ids[(i+1)%len(nodes)].Cmp(ids[i]) <= 0 {
+				wraps++
+			}
+		}
+		if wraps != 1 {
+			report.add(seed, "coverage", "the successor pointers go round the ring %d times", wraps)
+		}
+	}
+	stabilizeList := make(map[string]bool)
+	rehomeList := make(map[string]bool)
+	backupList := make(map[string]bool)
+	stored := make([]StoredKeys, len(nodes))
+	for i, addr := range nodes {
+		var pre string
+		tmp_err := RemoteCallContext(ctx, addr, "WrapNode.GetPredecessor", 0, &pre)
+		if tmp_err != nil {
+			report.add(addr, "predecessor", "can not get the predecessor: %v", tmp_err)
+			continue
+		}
+		expect := nodes[prev(i)]
+		if pre != expect {
+			report.add(addr, "predecessor", "predecessor is %q but the node before it in the walk is %s", pre, expect)
+			stabilizeList[expect] = true
+		}
+		tmp_err = RemoteCallContext(ctx, addr, "WrapNode.StoredKeys", 0, &stored[i])
+		if tmp_err != nil {
+			report.add(addr, "misplaced-key", "can not get the stored keys: %v", tmp_err)
+		}
+	}
+	for i, addr := range nodes {
+		//keys outside (the node before it, node]
+		var misplaced []string
+		for _, key := range stored[i].Data {
+			if len(nodes) > 1 && !inDur(ConsistentHash(key), ids[prev(i)], ids[i], true) {
+				misplaced = append(misplaced, key)
+			}
+		}
+		if len(misplaced) > 0 {
+			sort.Strings(misplaced)
+			report.add(addr, "misplaced-key", "%d keys do not belong to it, such as %v", len(misplaced), sample(misplaced))
+			rehomeList[addr] = true
+		}
+		//keys without a backup in the next Replicas-1 nodes
+		if stored[i].Replicas <= 1 || len(nodes) == 1 {
+			continue
+		}
+		backups := make(map[string]bool)
+		for j := 1; j < stored[i].Replicas && j < len(nodes); j++ {
+			for _, key := range stored[(i+j)%len(nodes)].Backup {
+				backups[key] = true
+			}
+		}
+		var missing []string
+		for _, key := range stored[i].Data {
+			if !backups[key] {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			report.add(addr, "missing-backup", "%d keys have no backup, such as %v", len(missing), sample(missing))
+			backupList[addr] = true
+		}
+	}
+	if !repair {
+		return report, nil
+	}
+	for _, addr := range nodes {
+		arg := RepairArg{stabilizeList[addr], rehomeList[addr], backupList[addr]}
+		if !arg.Stabilize && !arg.Rehome && !arg.Backups {
+			continue
+		}
+		var o string
+		tmp_err := RemoteCallContext(ctx, addr, "WrapNode.Repair", arg, &o)
+		desc := fmt.Sprintf("repair %s stabilize=%t rehome=%t backups=%t", addr, arg.Stabilize, arg.Rehome, arg.Backups)
+		if tmp_err != nil {
+			desc += fmt.Sprintf(" failed: %v", tmp_err)
+		}
+		report.Repairs = append(report.Repairs, desc)
+	}
+	return report, nil
+}
+
+func sample(keys []string) []string {
+	if len(keys) > auditKeySamples {
+		return keys[:auditKeySamples]
+	}
+	return keys
+}
@@ -0,0 +1,138 @@
+package chord_test
+
+import (
+	"chord"
+	"context"
+	"dht"
+	"fmt"
+	"reflect"
+	"sim"
+	"sort"
+	"testing"
+	"time"
+)
+
+//key_in finds a key in (pred, owner]
+func key_in(pred string, owner string) string {
+	for i := 0; ; i++ {
+		key := fmt.Sprint("key", i)
+		if in_range(chord.ConsistentHash(key), chord.NodeID(pred), chord.NodeID(owner)) {
+			return key
+		}
+	}
+}
+
+func has_key(keys []string, key string) bool {
+	for _, elt := range keys {
+		if elt == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckRingRepair(t *testing.T) {
+	const size = 4
+	s := sim.New(1)
+	conf := memory_config()
+	conf.AdvertiseAddress = "audit"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, 22900+i))
+	}
+	seed := addrs[0]
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	//a key of addrs[1] in the dataSet of addrs[3], and a key of addrs[2] without its
+	//backup in addrs[3]
+	misplaced := key_in(addrs[0], addrs[1])
+	unbacked := key_in(addrs[1], addrs[2])
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	var joinErr, putErr, breakErr, checkErr, getErr error
+	var broken, repaired *chord.RingReport
+	var owner, wrong chord.StoredKeys
+	var value string
+	s.Run(func() {
+		var ring []*chord.ChordNode
+		for i := 0; i < size; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(22900+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(seed); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		s.Sleep(30 * time.Second)
+		defer func() {
+			for _, node := range ring {
+				node.ForceQuit()
+			}
+		}()
+		putErr = ring[0].Put(unbacked, "value")
+		//the clock stands still from here on, so no maintenance fixes the ring by itself
+		var o string
+		breakErr = chord.RemoteCall(addrs[3], "WrapNode.AddData", map[string]chord.DataItem{misplaced: {Value: "misplaced", Version: 1}}, &o)
+		if breakErr == nil {
+			breakErr = chord.RemoteCall(addrs[3], "WrapNode.ErasePairInBackup", unbacked, &o)
+		}
+		if breakErr != nil {
+			return
+		}
+		broken, checkErr = chord.CheckRing(context.Background(), addrs[0], true)
+		if checkErr != nil {
+			return
+		}
+		repaired, checkErr = chord.CheckRing(context.Background(), addrs[0], false)
+		if checkErr != nil {
+			return
+		}
+		checkErr = chord.RemoteCall(addrs[1], "WrapNode.StoredKeys", 0, &owner)
+		if checkErr == nil {
+			checkErr = chord.RemoteCall(addrs[3], "WrapNode.StoredKeys", 0, &wrong)
+		}
+		value, getErr = ring[2].Get(misplaced)
+	})
+	if joinErr != nil || putErr != nil || breakErr != nil || checkErr != nil {
+		t.Fatalf("join: %v, put: %v, break the ring: %v, check: %v", joinErr, putErr, breakErr, checkErr)
+	}
+
+	var kinds []string
+	for _, violation := range broken.Violations {
+		kinds = append(kinds, violation.Node+" "+violation.Kind)
+	}
+	wantKinds := []string{addrs[2] + " missing-backup", addrs[3] + " misplaced-key"}
+	sort.Strings(kinds)
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("violations of the broken ring are %+v, want %v", broken.Violations, wantKinds)
+	}
+	wantRepairs := []string{
+		fmt.Sprintf("repair %s stabilize=false rehome=false backups=true", addrs[2]),
+		fmt.Sprintf("repair %s stabilize=false rehome=true backups=false", addrs[3]),
+	}
+	if !reflect.DeepEqual(broken.Repairs, wantRepairs) {
+		t.Errorf("repairs are %v, want %v", broken.Repairs, wantRepairs)
+	}
+
+	if len(repaired.Violations) > 0 || !reflect.DeepEqual(repaired.Nodes, addrs) {
+		t.Errorf("the repaired ring is %v with %+v, want %v without violations", repaired.Nodes, repaired.Violations, addrs)
+	}
+	if !has_key(owner.Data, misplaced) || has_key(wrong.Data, misplaced) {
+		t.Errorf("the misplaced key is in %s: %t, in %s: %t, want it moved", addrs[1], has_key(owner.Data, misplaced),
+			addrs[3], has_key(wrong.Data, misplaced))
+	}
+	if !has_key(wrong.Backup, unbacked) {
+		t.Errorf("the backup of %s is not back in %s", unbacked, addrs[3])
+	}
+	if getErr != nil || value != "misplaced" {
+		t.Errorf("get the misplaced key after the repair = %q, %v", value, getErr)
+	}
+}
@@ -0,0 +1,167 @@
+package chord
+
+import (
+	"context"
+	"crypto/rand"
+	"dht"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+//A lightly loaded node balances the ring by moving: it samples some nodes, and if one
+//of them holds Config.BalanceRatio times its keys, it leaves and joins again at the
+//identifier which splits the keys of that node in half. Each node asks at most once in
+//Config.BalancePeriod, and a heavy node gives out at most one split point in that time,
+//so two nodes never take the same identifier and a range is not split over and over.
+
+//how many nodes are sampled in a round
+const balanceSamples = 3
+
+//a node holding fewer keys is never split
+const balanceMinKeys = 16
+
+var errSplitReserved = errors.New("split point is given out recently")
+
+//LoadInfo is what a node tells about its load.
+type LoadInfo struct {
+	Address     string
+	Predecessor string
+	Keys        int
+}
+
+func (this *ChordNode) load_info(res *LoadInfo) error {
+	this.rwLock.RLock()
+	res.Address = this.get_address()
+	res.Predecessor = this.predecessor
+	this.rwLock.RUnlock()
+	this.dataLock.RLock()
+	res.Keys = this.dataSet.Size()
+	this.dataLock.RUnlock()
+	return nil
+}
+
+//compare the load with some sampled nodes, and move if one of them is much heavier
+func (this *ChordNode) balance() error {
+	var own LoadInfo
+	this.load_info(&own)
+	var heavy LoadInfo
+	for i := 0; i < balanceSamples; i++ {
+		id, tmp_err := rand.Int(rand.Reader, mod)
+		if tmp_err != nil {
+			return tmp_err
+		}
+		var addr string
+		tmp_err = this.innner_find_successor(context.Background(), id, &addr)
+		if tmp_err != nil || addr == this.get_address() {
+			continue
+		}
+		var info LoadInfo
+		tmp_err = this.call(addr, "WrapNode.Load", 0, &info)
+		if tmp_err != nil {
+			continue
+		}
+		if info.Keys > heavy.Keys {
+			heavy = info
+		}
+	}
+	if heavy.Keys < balanceMinKeys || float64(heavy.Keys) < this.config.BalanceRatio*float64(own.Keys+1) {
+		return nil
+	}
+	var split big.Int
+	tmp_err := this.call(heavy.Address, "WrapNode.ReserveSplit", this.get_address(), &split)
+	if tmp_err != nil {
+		return dht.FromRemote(tmp_err)
+	}
+	log.Infoln("In function balance", this.get_address(), "with", own.Keys, "keys moves to split", heavy.Address, "with", heavy.Keys, "keys")
+	return this.move(&split, heavy.Address)
+}
+
+//the identifier which splits the pairs in (predecessor, this] in half,
+//at most one is given out in a BalancePeriod
+func (this *ChordNode) reserve_split(requester string, res *big.Int) error {
+	this.rwLock.RLock()
+	pred := this.predecessor
+	this.rwLock.RUnlock()
+	if pred == "" || pred == this.get_address() {
+		return fmt.Errorf("%w: %s has no predecessor", dht.ErrNoRoute, this.get_address())
+	}
+	predID := NodeID(pred)
+	this.dataLock.Lock()
+	defer this.dataLock.Unlock()
+	if this.config.Clock.Now().Sub(this.lastSplit) < this.config.BalancePeriod {
+		return errSplitReserved
+	}
+	var dists []*big.Int
+	for key := range this.dataSet.RangeByHash(predID, this.get_id(), true) {
+		dist := new(big.Int).Sub(ConsistentHash(key), predID)
+		dists = append(dists, dist.Mod(dist, mod))
+	}
+	if len(dists) < balanceMinKeys {
+		return fmt.Errorf("only %d keys to split", len(dists))
+	}
+	sort.Slice(dists, func(i, j int) bool {
+		return dists[i].Cmp(dists[j]) < 0
+	})
+	//the requester takes the first half
+	res.Add(predID, dists[len(dists)/2-1])
+	res.Mod(res, mod)
+	this.lastSplit = this.config.Clock.Now()
+	log.Infoln("In function reserve_split", this.get_address(), "gives", res.Text(16), "to", requester)
+	return nil
+}
+
+//leave the ring, and join again through via at the identifier id
+func (this *ChordNode) move(id *big.Int, via string) error {
+	this.rwLock.RLock()
+	succList := this.successorList
+	this.rwLock.RUnlock()
+	tmp_err := this.leave(context.Background())
+	if errors.Is(tmp_err, errNoTakeOver) {
+		//nothing was handed off, stay where it is
+		this.stay()
+		return tmp_err
+	}
+	if tmp_err != nil {
+		log.Warningln("In function move", this.get_address(), "leaves with", tmp_err)
+	}
+	old := this.get_address()
+	base := old
+	if pos := strings.LastIndex(old, idSeparator); pos >= 0 {
+		base = old[:pos]
+	}
+	this.clear_storage()
+	this.unregister_gauges()
+	//from now on the station answers the calls to the old address as a node which is not in the ring
+	this.idLock.Lock()
+	this.address = base + idSeparator + id.Text(16)
+	this.ID = new(big.Int).Set(id)
+	this.idLock.Unlock()
+	this.rwLock.Lock()
+	this.predecessor = ""
+	this.successorList = [successorListLength]string{}
+	this.fingerTable = [fingerTableLength]string{}
+	this.fingerCands = [fingerTableLength][]string{}
+	this.replicaList = nil
+	this.rwLock.Unlock()
+	this.reset()
+	this.register_gauges()
+	this.rwLock.Lock()
+	this.conRoutineFlag = true
+	this.rwLock.Unlock()
+	//the virtual nodes stay where they are
+	tmp_err = this.join_self(via)
+	if tmp_err == nil {
+		return nil
+	}
+	log.Errorln("In function move", this.get_address(), "can not join through", via, tmp_err)
+	for _, addr := range succList {
+		if addr != "" && addr != old && this.join_self(addr) == nil {
+			return nil
+		}
+	}
+	return tmp_err
+}
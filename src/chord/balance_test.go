@@ -0,0 +1,199 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+//put_owned puts count keys in (pred, owner] through node, and returns them
+func put_owned(t *testing.T, node *chord.ChordNode, pred string, owner string, count int) []string {
+	t.Helper()
+	var keys []string
+	for i := 0; len(keys) < count; i++ {
+		key := fmt.Sprint("key", i)
+		if !in_range(chord.ConsistentHash(key), chord.NodeID(pred), chord.NodeID(owner)) {
+			continue
+		}
+		tmp_err := node.Put(key, "value")
+		if tmp_err != nil {
+			t.Fatalf("put %s: %v", key, tmp_err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestReserveSplit(t *testing.T) {
+	conf := memory_config()
+	conf.BalancePeriod = time.Hour
+	ring := start_ring(t, conf, 22300, 2)
+	heavy := dht.JoinAddress(conf.AdvertiseAddress, 22300)
+	light := dht.JoinAddress(conf.AdvertiseAddress, 22301)
+	keys := put_owned(t, ring[0], light, heavy, 40)
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	var split big.Int
+	tmp_err := chord.RemoteCall(light, "WrapNode.ReserveSplit", heavy, &split)
+	if tmp_err == nil {
+		t.Errorf("a node without keys gives out the split point %s", split.Text(16))
+	}
+	tmp_err = chord.RemoteCall(heavy, "WrapNode.ReserveSplit", light, &split)
+	if tmp_err != nil {
+		t.Fatalf("reserve a split: %v", tmp_err)
+	}
+	//the requester takes the first half of the range
+	if !in_range(&split, chord.NodeID(light), chord.NodeID(heavy)) {
+		t.Fatalf("the split point %s is not in the range of %s", split.Text(16), heavy)
+	}
+	first := 0
+	for _, key := range keys {
+		if in_range(chord.ConsistentHash(key), chord.NodeID(light), &split) {
+			first++
+		}
+	}
+	if first != len(keys)/2 {
+		t.Errorf("the split point gives %d of the %d keys, want half", first, len(keys))
+	}
+	//a second requester waits for the BalancePeriod
+	tmp_err = chord.RemoteCall(heavy, "WrapNode.ReserveSplit", light, &split)
+	if tmp_err == nil {
+		t.Errorf("a second split point %s is given out in the same period", split.Text(16))
+	}
+}
+
+func TestMove(t *testing.T) {
+	const count = 64
+	conf := memory_config()
+	conf.Balance = true
+	conf.BalancePeriod = 300 * time.Millisecond
+	ring := start_ring(t, conf, 22400, 1)
+	heavy := dht.JoinAddress(conf.AdvertiseAddress, 22400)
+	light := dht.JoinAddress(conf.AdvertiseAddress, 22401)
+	//the keys are put before the other node joins, so it can not move while they are put
+	keys := put_owned(t, ring[0], light, heavy, count)
+	node := new(chord.ChordNode)
+	node.InitWithConfig(22401, conf)
+	node.Run()
+	t.Cleanup(node.Quit)
+	tmp_err := node.Join(heavy)
+	if tmp_err != nil {
+		t.Fatalf("join %s: %v", light, tmp_err)
+	}
+	ring = append(ring, node)
+
+	//the node without keys moves into the range of the other one
+	moved := light
+	for try := 0; try < 40 && moved == light; try++ {
+		time.Sleep(settleWait / 4)
+		moved = ring[1].DebugState().Address
+	}
+	if moved == light {
+		t.Fatalf("%s does not move", light)
+	}
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	wait_ring(t, heavy, []string{heavy, moved})
+	var o string
+	tmp_err = dht.FromRemote(chord.RemoteCall(light, "WrapNode.Ping", 0, &o))
+	if !errors.Is(tmp_err, dht.ErrNotJoined) {
+		t.Errorf("ping the address %s moved away from: %v, want ErrNotJoined", light, tmp_err)
+	}
+	tmp_err = chord.RemoteCall(moved, "WrapNode.Ping", 0, &o)
+	if tmp_err != nil {
+		t.Errorf("ping %s: %v", moved, tmp_err)
+	}
+
+	for _, addr := range []string{heavy, moved} {
+		var stored chord.StoredKeys
+		tmp_err := chord.RemoteCall(addr, "WrapNode.StoredKeys", 0, &stored)
+		if tmp_err != nil {
+			t.Fatalf("stored keys of %s: %v", addr, tmp_err)
+		}
+		if len(stored.Data) != count/2 {
+			t.Errorf("%s keeps %d keys, want %d", addr, len(stored.Data), count/2)
+		}
+	}
+	for _, key := range keys {
+		for i, node := range ring {
+			value, tmp_err := node.Get(key)
+			if tmp_err != nil || value != "value" {
+				t.Errorf("get %s from node %d after the move = %q, %v", key, i, value, tmp_err)
+			}
+		}
+	}
+}
+
+func TestMoveVirtualNodes(t *testing.T) {
+	const count = 64
+	conf := memory_config()
+	conf.VirtualNodes = 2
+	conf.Balance = true
+	conf.BalancePeriod = 300 * time.Millisecond
+	ring := start_ring(t, conf, 22420, 1)
+	heavy := dht.JoinAddress(conf.AdvertiseAddress, 22420)
+	light := dht.JoinAddress(conf.AdvertiseAddress, 22421)
+	vnode := light + "#1"
+	addrs := []string{heavy, heavy + "#1", light, vnode}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	//every node but light gets count keys, so only light is light enough to move
+	var keys []string
+	for i, addr := range addrs {
+		if addr != light {
+			keys = append(keys, put_owned(t, ring[0], addrs[(i+len(addrs)-1)%len(addrs)], addr, count)...)
+		}
+	}
+	node := new(chord.ChordNode)
+	node.InitWithConfig(22421, conf)
+	node.Run()
+	t.Cleanup(node.Quit)
+	tmp_err := node.Join(heavy)
+	if tmp_err != nil {
+		t.Fatalf("join %s: %v", light, tmp_err)
+	}
+	ring = append(ring, node)
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	//the virtual node is not joined again by the move, so it never loses its successor,
+	//it is watched until a while after light takes its new address
+	moved := light
+	for try, after := 0, 0; try < 500 && after < 25; try++ {
+		var state chord.DebugState
+		tmp_err := chord.RemoteCall(vnode, "WrapNode.DebugState", 0, &state)
+		if tmp_err != nil || state.SuccessorList[0] == vnode {
+			t.Fatalf("the successors of %s while %s moves are %v, %v", vnode, light, state.SuccessorList, tmp_err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		moved = ring[1].DebugState().Address
+		if moved != light {
+			after++
+		}
+	}
+	if moved == light {
+		t.Fatalf("%s does not move", light)
+	}
+	//every pair is in its owner and backed up in the successor of the owner
+	report := wait_ring(t, heavy, addrs)
+	for _, addr := range []string{heavy, heavy + "#1", vnode} {
+		if !has_key(report.Nodes, addr) {
+			t.Errorf("the walk found %v without %s", report.Nodes, addr)
+		}
+	}
+	for _, key := range keys {
+		for i, node := range ring {
+			value, tmp_err := node.Get(key)
+			if tmp_err != nil || value != "value" {
+				t.Errorf("get %s from node %d after the move = %q, %v", key, i, value, tmp_err)
+			}
+		}
+	}
+}
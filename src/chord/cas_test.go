@@ -0,0 +1,113 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	ring := start_ring(t, memory_config(), 21600, 3)
+	created, tmp_err := ring[0].CompareAndSwap("key", 0, "a")
+	if tmp_err != nil {
+		t.Fatalf("create: %v", tmp_err)
+	}
+	//the current version comes back with the mismatch
+	version, tmp_err := ring[1].CompareAndSwap("key", 0, "b")
+	if !errors.Is(tmp_err, dht.ErrVersionMismatch) || version != created {
+		t.Fatalf("create again = %d, %v, want %d and ErrVersionMismatch", version, tmp_err, created)
+	}
+	swapped, tmp_err := ring[1].CompareAndSwap("key", created, "b")
+	if tmp_err != nil || swapped <= created {
+		t.Fatalf("swap = %d, %v, want a version after %d", swapped, tmp_err, created)
+	}
+
+	//a key stored again after a Delete does not take an old version
+	tmp_err = ring[2].Delete("key")
+	if tmp_err != nil {
+		t.Fatalf("delete: %v", tmp_err)
+	}
+	tmp_err = ring[2].Put("key", "c")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	value, current, tmp_err := ring[0].GetWithVersion("key")
+	if tmp_err != nil || value != "c" || current <= swapped {
+		t.Fatalf("get = %q, %d, %v, want c with a version after %d", value, current, tmp_err, swapped)
+	}
+	version, tmp_err = ring[0].CompareAndSwap("key", swapped, "d")
+	if !errors.Is(tmp_err, dht.ErrVersionMismatch) || version != current {
+		t.Fatalf("swap with the version before the delete = %d, %v, want %d and ErrVersionMismatch", version, tmp_err, current)
+	}
+}
+
+func TestStaleMirror(t *testing.T) {
+	conf := memory_config()
+	ring := start_ring(t, conf, 21700, 2)
+	owner := dht.JoinAddress(conf.AdvertiseAddress, 21700)
+	holder := dht.JoinAddress(conf.AdvertiseAddress, 21701)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if in_range(chord.ConsistentHash(fmt.Sprint("key", i)), chord.NodeID(holder), chord.NodeID(owner)) {
+			key = fmt.Sprint("key", i)
+		}
+	}
+	tmp_err := ring[1].Put(key, "fresh")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	_, version, tmp_err := ring[1].GetWithVersion(key)
+	if tmp_err != nil {
+		t.Fatalf("get: %v", tmp_err)
+	}
+	//an older mirror which arrives late does not replace the backup
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	var o string
+	tmp_err = chord.RemoteCall(holder, "WrapNode.InsertPairInBackup", chord.KeyValuePair{Key: key, Value: "stale", Version: version - 1}, &o)
+	if tmp_err != nil {
+		t.Fatalf("mirror: %v", tmp_err)
+	}
+	ring[0].ForceQuit()
+	//the holder takes over the backup once it finds the owner failed
+	var value string
+	for try := 0; try < 20; try++ {
+		value, tmp_err = ring[1].Get(key)
+		if tmp_err == nil {
+			break
+		}
+		time.Sleep(settleWait / 4)
+	}
+	if tmp_err != nil || value != "fresh" {
+		t.Errorf("get after the owner failed = %q, %v, want fresh", value, tmp_err)
+	}
+}
+
+func TestCompareAndSwapKeepsExpiry(t *testing.T) {
+	const ttl = time.Second
+	ring := start_ring(t, memory_config(), 21800, 2)
+	tmp_err := ring[0].PutWithTTL("key", "a", ttl)
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	_, version, tmp_err := ring[1].GetWithVersion("key")
+	if tmp_err != nil {
+		t.Fatalf("get: %v", tmp_err)
+	}
+	_, tmp_err = ring[1].CompareAndSwap("key", version, "b")
+	if tmp_err != nil {
+		t.Fatalf("swap: %v", tmp_err)
+	}
+	value, tmp_err := ring[0].Get("key")
+	if tmp_err != nil || value != "b" {
+		t.Fatalf("get after the swap = %q, %v", value, tmp_err)
+	}
+	time.Sleep(ttl + ttl/2)
+	_, tmp_err = ring[0].Get("key")
+	if !errors.Is(tmp_err, dht.ErrNotFound) {
+		t.Errorf("get after the TTL: %v, want ErrNotFound", tmp_err)
+	}
+}
@@ -0,0 +1,58 @@
+package chord
+
+import (
+	"crypto/tls"
+	"dht"
+	"time"
+)
+
+//Config is used to set up a ChordNode in InitWithConfig.
+type Config struct {
+	//address other nodes reach this node by, the ID is hashed from it. It is a host name,
+	//an IPv4 or IPv6 address, the port of InitWithConfig is added if it has no port,
+	//and the local address is detected if it is empty
+	AdvertiseAddress string
+	//address the listener binds, in the same form, AdvertiseAddress is used if it is empty
+	BindAddress string
+	//which Storage is used for dataSet and backupSet
+	Storage StorageType
+	//root directory of DiskStorage, each node use a sub directory named by its address
+	DataDir string
+	//every pair is kept by its owner and the next Replicas-1 online successors,
+	//so it should be in [1, successorListLength]
+	Replicas int
+	//drive lookups from this node hop by hop instead of forwarding them recursively
+	IterativeLookup bool
+	//how long a hop of an iterative lookup may take before the next candidate is tried
+	HopTimeout time.Duration
+	//number of identifiers the node takes in the ring, a stronger machine can take more
+	VirtualNodes int
+	//move the node to split the range of a much heavier node, see balance.go
+	Balance bool
+	//a node compares its load with others once in a period, and moves at most once in it
+	BalancePeriod time.Duration
+	//a node moves when a sampled node holds BalanceRatio times its keys
+	BalanceRatio float64
+	//serve the metrics in the Prometheus text format at http://MetricsAddress/metrics,
+	//such as "127.0.0.1:9100", they are not served if it is empty
+	MetricsAddress string
+	//carries the rpc calls, such as a dht.MemoryNetwork for a test in one process,
+	//dht.TCP if it is nil. The node calls other nodes over it as well
+	Transport dht.Transport
+	//serve and call rpc over TLS with mutual authentication, such as the config of
+	//dht.LoadMutualTLS. The node calls other nodes with it as well, so nodes of
+	//different clusters can run in one process
+	TLS *tls.Config
+	//pre-shared key of the cluster, every call is signed with it and a call
+	//not signed with it is rejected
+	ClusterKey []byte
+	//time of the background loops and of the expiry of the pairs, such as a sim.Simulator
+	//running the ring in virtual time, dht.RealClock if it is nil. It is also used to
+	//measure the calls to other nodes
+	Clock dht.Clock
+}
+
+func DefaultConfig() Config {
+	return Config{Storage: MemoryStorage, DataDir: "data", Replicas: 2, HopTimeout: time.Second, VirtualNodes: 1,
+		BalancePeriod: 30 * time.Second, BalanceRatio: 4}
+}
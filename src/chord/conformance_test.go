@@ -0,0 +1,22 @@
+package chord_test
+
+import (
+	"chord"
+	"conformance"
+	"dht"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	conf := chord.DefaultConfig()
+	conf.AdvertiseAddress = "chord"
+	conf.Transport = dht.NewMemoryNetwork()
+	conformance.Run(t, func(port int) (conformance.Node, string) {
+		node := new(chord.ChordNode)
+		node.InitWithConfig(port, conf)
+		return node, dht.JoinAddress(conf.AdvertiseAddress, port)
+	}, conformance.DefaultConfig())
+}
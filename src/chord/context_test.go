@@ -0,0 +1,75 @@
+package chord_test
+
+import (
+	"context"
+	"dht"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	conf := memory_config()
+	network := dht.NewFaultNetwork(conf.Transport, 1)
+	conf.Transport = network
+	ring := start_ring(t, conf, 22600, 4)
+	tmp_err := ring[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	ops := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"get", func(ctx context.Context) error {
+			_, tmp_err := ring[1].GetContext(ctx, "key")
+			return tmp_err
+		}},
+		{"put", func(ctx context.Context) error {
+			return ring[1].PutContext(ctx, "other", "value")
+		}},
+		{"delete", func(ctx context.Context) error {
+			return ring[1].DeleteContext(ctx, "key")
+		}},
+		{"compare and swap", func(ctx context.Context) error {
+			_, tmp_err := ring[1].CompareAndSwapContext(ctx, "key", 0, "new")
+			return tmp_err
+		}},
+		{"scan", func(ctx context.Context) error {
+			_, _, tmp_err := ring[1].ScanContext(ctx, "", 10)
+			return tmp_err
+		}},
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for _, op := range ops {
+			tmp_err := op.run(ctx)
+			if !errors.Is(tmp_err, context.Canceled) {
+				t.Errorf("%s with a cancelled context: %v, want context.Canceled", op.name, tmp_err)
+			}
+		}
+	})
+
+	//every reply takes longer than the deadline, so the first call of the lookup
+	//already runs out of time and nothing else is tried
+	t.Run("Deadline", func(t *testing.T) {
+		const deadline = 100 * time.Millisecond
+		network.SetReplyLatency(dht.FixedLatency(3 * deadline))
+		defer network.Reset()
+		for _, op := range ops {
+			ctx, cancel := context.WithTimeout(context.Background(), deadline)
+			start := time.Now()
+			tmp_err := op.run(ctx)
+			elapsed := time.Since(start)
+			cancel()
+			if !errors.Is(tmp_err, dht.ErrTimeout) {
+				t.Errorf("%s past the deadline: %v, want ErrTimeout", op.name, tmp_err)
+			}
+			if elapsed > 2*deadline {
+				t.Errorf("%s took %v with a deadline of %v", op.name, elapsed, deadline)
+			}
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package chord
+
+import (
+	"context"
+	"dht"
+	"math/big"
+)
+
+//FingerRange is a run of fingerTable entries pointing at the same node, which is
+//used for the aim IDs in [Start, End), that is from the start of finger First
+//to the start of the finger after Last.
+type FingerRange struct {
+	Address string
+	First   int
+	Last    int
+	Start   *big.Int
+	End     *big.Int
+}
+
+//DebugState is the routing state of a ChordNode.
+type DebugState struct {
+	Address       string
+	ID            *big.Int
+	Running       bool
+	Predecessor   string
+	SuccessorList [successorListLength]string
+	Fingers       []FingerRange
+	Next          int
+	DataSize      int
+	BackupSize    int
+}
+
+//DebugState returns the routing state of this node.
+func (this *ChordNode) DebugState() DebugState {
+	res := DebugState{Address: this.get_address(), ID: new(big.Int).Set(this.get_id())}
+	this.rwLock.RLock()
+	res.Running = this.conRoutineFlag
+	res.Predecessor = this.predecessor
+	res.SuccessorList = this.successorList
+	res.Next = this.next
+	fingerTable := this.fingerTable
+	this.rwLock.RUnlock()
+	for i := 0; i < fingerTableLength; i++ {
+		if fingerTable[i] == "" {
+			continue
+		}
+		last := len(res.Fingers) - 1
+		if last >= 0 && res.Fingers[last].Address == fingerTable[i] && res.Fingers[last].Last == i-1 {
+			res.Fingers[last].Last = i
+			continue
+		}
+		res.Fingers = append(res.Fingers, FingerRange{Address: fingerTable[i], First: i, Last: i})
+	}
+	for i := range res.Fingers {
+		res.Fingers[i].Start = getID(this.get_id(), res.Fingers[i].First)
+		if res.Fingers[i].Last+1 < fingerTableLength {
+			res.Fingers[i].End = getID(this.get_id(), res.Fingers[i].Last+1)
+		} else {
+			res.Fingers[i].End = new(big.Int).Set(this.get_id())
+		}
+	}
+	this.dataLock.RLock()
+	res.DataSize = this.dataSet.Size()
+	this.dataLock.RUnlock()
+	this.backupLock.RLock()
+	res.BackupSize = this.backupSet.Size()
+	this.backupLock.RUnlock()
+	return res
+}
+
+//FetchDebugState asks the node addr for its routing state.
+func FetchDebugState(ctx context.Context, addr string) (DebugState, error) {
+	var res DebugState
+	tmp_err := RemoteCallContext(ctx, addr, "WrapNode.DebugState", 0, &res)
+	return res, dht.RemoteError(ctx, tmp_err)
+}
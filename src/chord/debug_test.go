@@ -0,0 +1,109 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"math/big"
+	"sim"
+	"sort"
+	"testing"
+	"time"
+)
+
+//ring_successor is the node of addrs, sorted by identifier, which the identifier id belongs to
+func ring_successor(id *big.Int, addrs []string) string {
+	for _, addr := range addrs {
+		if chord.NodeID(addr).Cmp(id) >= 0 {
+			return addr
+		}
+	}
+	return addrs[0]
+}
+
+func TestDebugState(t *testing.T) {
+	const size = 6
+	mod := new(big.Int).Lsh(big.NewInt(1), 160)
+	s := sim.New(1)
+	conf := memory_config()
+	conf.AdvertiseAddress = "debug"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, 22800+i))
+	}
+	var joinErr error
+	states := make(map[string]chord.DebugState)
+	s.Run(func() {
+		var ring []*chord.ChordNode
+		for i := 0; i < size; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(22800+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(addrs[0]); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		//fix_fingerTable goes over the 160 fingers in 32 seconds, twice in this time
+		s.Sleep(80 * time.Second)
+		for i, node := range ring {
+			states[addrs[i]] = node.DebugState()
+		}
+		for _, node := range ring {
+			node.ForceQuit()
+		}
+	})
+	if joinErr != nil {
+		t.Fatalf("join: %v", joinErr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	for i, addr := range addrs {
+		state := states[addr]
+		if state.Address != addr || state.ID.Cmp(chord.NodeID(addr)) != 0 || !state.Running {
+			t.Errorf("state of %s is %s %s, running %v", addr, state.Address, state.ID.Text(16), state.Running)
+		}
+		if want := addrs[(i+size-1)%size]; state.Predecessor != want {
+			t.Errorf("predecessor of %s = %s, want %s", addr, state.Predecessor, want)
+		}
+		for j, succ := range state.SuccessorList {
+			if want := addrs[(i+j+1)%size]; succ != want {
+				t.Errorf("successor %d of %s = %s, want %s", j, addr, succ, want)
+			}
+		}
+		//the ranges cover the fingers in order, and each finger is the successor of its start
+		next := 0
+		for _, finger := range state.Fingers {
+			if finger.First != next || finger.Last < finger.First {
+				t.Errorf("fingers of %s: range [%d, %d] after finger %d", addr, finger.First, finger.Last, next-1)
+			}
+			next = finger.Last + 1
+			start := new(big.Int).Add(chord.NodeID(addr), new(big.Int).Lsh(big.NewInt(1), uint(finger.First)))
+			start.Mod(start, mod)
+			end := chord.NodeID(addr)
+			if finger.Last+1 < 160 {
+				end = new(big.Int).Add(end, new(big.Int).Lsh(big.NewInt(1), uint(finger.Last+1)))
+				end.Mod(end, mod)
+			}
+			if finger.Start.Cmp(start) != 0 || finger.End.Cmp(end) != 0 {
+				t.Errorf("fingers %d-%d of %s cover [%s, %s), want [%s, %s)", finger.First, finger.Last, addr,
+					finger.Start.Text(16), finger.End.Text(16), start.Text(16), end.Text(16))
+			}
+			for j := finger.First; j <= finger.Last; j++ {
+				jStart := new(big.Int).Add(chord.NodeID(addr), new(big.Int).Lsh(big.NewInt(1), uint(j)))
+				jStart.Mod(jStart, mod)
+				if want := ring_successor(jStart, addrs); finger.Address != want {
+					t.Errorf("finger %d of %s = %s, want %s", j, addr, finger.Address, want)
+				}
+			}
+		}
+		if next != 160 {
+			t.Errorf("fingers of %s end at %d, want 160", addr, next)
+		}
+	}
+}
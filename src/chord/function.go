@@ -2,72 +2,30 @@ package chord
 
 import (
 	"crypto/sha1"
+	"dht"
 	"math/big"
-	"net"
 	"time"
 )
 
-var localAddress string
-var timeCut time.Duration
-var waitTime time.Duration
-var mod *big.Int
-var base *big.Int
+var timeCut = 200 * time.Millisecond
+var waitTime = 250 * time.Millisecond
+var base = big.NewInt(2)
+var mod = new(big.Int).Exp(base, big.NewInt(160), nil)
 
 type KeyValue struct {
 	Key   string
 	Value string
 }
 
-func init() {
-	localAddress = GetLocalAddress()
-	base = big.NewInt(2)
-	mod = new(big.Int).Exp(base, big.NewInt(160), nil)
-	timeCut = 200 * time.Millisecond
-	waitTime = 250 * time.Millisecond
-	//fmt.Println("localaddress : ", localAddress)
-	//fmt.Println("mod : ", mod)
-	//fmt.Println("base : ", base)
-	//log.Infoln("init success!")
-}
-
 func ConsistentHash(str string) *big.Int {
 	hash := sha1.New()
 	hash.Write([]byte(str))
 	return (&big.Int{}).SetBytes(hash.Sum(nil))
 }
 
+//GetLocalAddress returns the address used when Config.AdvertiseAddress is empty.
 func GetLocalAddress() string {
-	var localaddress string
-
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		panic("init: failed to find network interfaces")
-	}
-
-	// find the first non-loopback interface with an IP address
-	for _, elt := range ifaces {
-		if elt.Flags&net.FlagLoopback == 0 && elt.Flags&net.FlagUp != 0 {
-			addrs, err := elt.Addrs()
-			if err != nil {
-				panic("init: failed to get addresses for network interface")
-			}
-
-			for _, addr := range addrs {
-				ipnet, ok := addr.(*net.IPNet)
-				if ok {
-					if ip4 := ipnet.IP.To4(); len(ip4) == net.IPv4len {
-						localaddress = ip4.String()
-						break
-					}
-				}
-			}
-		}
-	}
-	if localaddress == "" {
-		panic("init: failed to find non-loopback interface with valid address on this node")
-	}
-
-	return localaddress
+	return dht.LocalAddress()
 }
 
 func inDur(x, l, r *big.Int, isClose bool) bool {
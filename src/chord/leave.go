@@ -0,0 +1,272 @@
+package chord
+
+import (
+	"context"
+	"dht"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+//returned by leave when the node still holds its pairs
+var errNoTakeOver = fmt.Errorf("%w: no successor takes over", dht.ErrNoRoute)
+
+//LeaveArg tells the neighbours of a leaving node what it knows.
+//Data and Backup are only sent to the node taking over.
+type LeaveArg struct {
+	Address       string
+	Predecessor   string
+	SuccessorList [successorListLength]string
+	Data          map[string]DataItem
+	Backup        map[string]DataItem
+}
+
+//Leave takes the node and its virtual nodes out of the network: each of them hands
+//its dataSet and backupSet to its first successor that accepts them, and points its
+//predecessor to that successor, then the station is shut down.
+//It returns after every handoff is acknowledged, or with the first handoff that failed.
+//A node whose pairs no successor takes over stays in the ring with them, and the station
+//keeps serving it, so Leave can be tried again. After other failures a disk storage
+//keeps the pairs of that node.
+func (this *ChordNode) Leave() error {
+	return this.LeaveContext(context.Background())
+}
+
+func (this *ChordNode) LeaveContext(ctx context.Context) error {
+	return this.leave_network(ctx, true)
+}
+
+//hand off the node and its virtual nodes, with stay a node whose pairs no successor
+//takes over stays in the ring, otherwise it is shut down like the others
+func (this *ChordNode) leave_network(ctx context.Context, stay bool) error {
+	if !this.joined() {
+		return dht.ErrNotJoined
+	}
+	var res error
+	handed := make([]bool, len(this.all_nodes()))
+	stayed := false
+	for i, node := range this.all_nodes() {
+		if !node.is_running() {
+			//handed off by an earlier Leave
+			handed[i] = true
+			continue
+		}
+		tmp_err := node.leave(ctx)
+		if errors.Is(tmp_err, errNoTakeOver) {
+			if stay {
+				node.stay()
+				stayed = true
+			} else if unlink_err := node.unlink(ctx); unlink_err != nil {
+				//the predecessor finds a successor on its own, unless all of its successors are gone
+				log.Warningln("In function Leave", node.get_address(), "can not update its predecessor", unlink_err)
+			}
+		}
+		if tmp_err != nil {
+			log.Errorln("In function Leave", node.get_address(), "can not hand off because", tmp_err)
+			if res == nil {
+				res = fmt.Errorf("leave %s: %w", node.get_address(), tmp_err)
+			}
+			continue
+		}
+		handed[i] = true
+	}
+	if !stayed {
+		tmp_err := this.station.ShutDown()
+		if tmp_err != nil {
+			log.Errorln("In function Leave station shutDown error")
+		}
+		this.stop_metrics()
+	}
+	for i, node := range this.all_nodes() {
+		if stayed && !handed[i] {
+			continue
+		}
+		if handed[i] {
+			//the pairs live on other nodes now, so the persisted ones are useless
+			node.clear_storage()
+		}
+		node.reset()
+	}
+	return res
+}
+
+//whether the node or one of its virtual nodes is still in the ring
+func (this *ChordNode) joined() bool {
+	for _, node := range this.all_nodes() {
+		if node.is_running() {
+			return true
+		}
+	}
+	return false
+}
+
+//undo a leave which handed nothing off, the node goes on in the ring with its pairs
+func (this *ChordNode) stay() {
+	this.dataLock.Lock()
+	this.leaving = false
+	this.dataLock.Unlock()
+	this.rwLock.Lock()
+	this.conRoutineFlag = true
+	this.rwLock.Unlock()
+	this.bgMaintain()
+}
+
+//hand off this node while the station still serves
+func (this *ChordNode) leave(ctx context.Context) error {
+	this.rwLock.Lock()
+	this.conRoutineFlag = false
+	pred := this.predecessor
+	succList := this.successorList
+	this.rwLock.Unlock()
+	//from now on the pairs can not change here
+	this.dataLock.Lock()
+	this.leaving = true
+	data := storageCopy(this.dataSet)
+	this.dataLock.Unlock()
+	this.backupLock.RLock()
+	backup := storageCopy(this.backupSet)
+	this.backupLock.RUnlock()
+
+	arg := LeaveArg{Address: this.get_address(), Predecessor: pred, Data: data, Backup: backup}
+	succAddr := ""
+	for _, addr := range succList {
+		if addr == "" || addr == this.get_address() || addr == succAddr {
+			continue
+		}
+		var o string
+		tmp_err := this.call_context(ctx, addr, "WrapNode.TakeOver", arg, &o)
+		if tmp_err == nil {
+			succAddr = addr
+			break
+		}
+		if ctx.Err() != nil {
+			return dht.RemoteError(ctx, tmp_err)
+		}
+		log.Warningln("In function leave", addr, "can not take over", this.get_address(), tmp_err)
+	}
+	if succAddr == "" {
+		if len(data) == 0 && len(backup) == 0 {
+			//the last node of the network, or a node which never joined
+			return nil
+		}
+		return errNoTakeOver
+	}
+	if pred == succAddr {
+		return nil
+	}
+	return this.unlink(ctx)
+}
+
+//point the predecessor of a leaving node to the successors of the node
+func (this *ChordNode) unlink(ctx context.Context) error {
+	this.rwLock.RLock()
+	pred := this.predecessor
+	succList := this.successorList
+	this.rwLock.RUnlock()
+	if pred == "" || pred == this.get_address() {
+		return nil
+	}
+	arg := LeaveArg{Address: this.get_address(), SuccessorList: succList}
+	var o string
+	tmp_err := this.call_context(ctx, pred, "WrapNode.SuccessorLeave", arg, &o)
+	if tmp_err != nil {
+		return fmt.Errorf("can not update predecessor %s: %w", pred, dht.RemoteError(ctx, tmp_err))
+	}
+	return nil
+}
+
+//the error of a call which would change the pairs of a leaving node
+func (this *ChordNode) leaving_error() error {
+	return fmt.Errorf("%w: %s is leaving", dht.ErrNotJoined, this.get_address())
+}
+
+//the predecessor arg.Address is leaving, take over its pairs and its replicas
+func (this *ChordNode) take_over(arg LeaveArg) error {
+	this.dataLock.RLock()
+	leaving := this.leaving
+	this.dataLock.RUnlock()
+	if !this.is_running() || leaving {
+		return dht.ErrNotJoined
+	}
+	this.rwLock.Lock()
+	if this.predecessor == "" || this.predecessor == arg.Address {
+		this.predecessor = arg.Predecessor
+		if arg.Predecessor == arg.Address {
+			this.predecessor = ""
+		}
+	}
+	this.remove_node(arg.Address)
+	this.rwLock.Unlock()
+	var keys []string
+	for key := range arg.Data {
+		keys = append(keys, key)
+	}
+	tmp_err := this.add_data(arg.Data)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	this.backupLock.Lock()
+	defer this.backupLock.Unlock()
+	//the pairs of arg.Address are owned here now
+	for _, key := range keys {
+		this.backupSet.Delete(key)
+	}
+	if this.config.Replicas <= 1 {
+		return nil
+	}
+	//the replicas arg.Address kept for the predecessors are kept here instead
+	return storagePutAll(this.backupSet, arg.Backup)
+}
+
+//the successor arg.Address is leaving, use its successor list instead
+func (this *ChordNode) successor_leave(arg LeaveArg) error {
+	this.rwLock.Lock()
+	var succList [successorListLength]string
+	j := 0
+	for _, addr := range arg.SuccessorList {
+		if addr != "" && addr != arg.Address {
+			succList[j] = addr
+			j++
+		}
+	}
+	if j == 0 {
+		succList[0] = this.get_address()
+	}
+	this.successorList = succList
+	this.fingerTable[0] = succList[0]
+	this.remove_node(arg.Address)
+	this.rwLock.Unlock()
+	//the successor after arg.Address becomes a replica holder
+	this.replicate()
+	return nil
+}
+
+//forget addr in successorList, fingerTable and the finger candidates
+//need hold rwLock
+func (this *ChordNode) remove_node(addr string) {
+	j := 0
+	for _, succ := range this.successorList {
+		if succ != addr {
+			this.successorList[j] = succ
+			j++
+		}
+	}
+	for ; j < successorListLength; j++ {
+		this.successorList[j] = ""
+	}
+	if this.successorList[0] == "" {
+		this.successorList[0] = this.get_address()
+	}
+	for i := range this.fingerTable {
+		if this.fingerTable[i] == addr {
+			this.fingerTable[i] = this.successorList[0]
+		}
+		var cands []string
+		for _, cand := range this.fingerCands[i] {
+			if cand != addr {
+				cands = append(cands, cand)
+			}
+		}
+		this.fingerCands[i] = cands
+	}
+}
@@ -0,0 +1,150 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLeaveWithoutTakeOver(t *testing.T) {
+	conf := memory_config()
+	ring := start_ring(t, conf, 22200, 1)
+	for i := 0; i < 10; i++ {
+		tmp_err := ring[0].Put(fmt.Sprint("key", i), "value")
+		if tmp_err != nil {
+			t.Fatalf("put key%d: %v", i, tmp_err)
+		}
+	}
+	//no other node can take the pairs, so the node stays with them
+	tmp_err := ring[0].Leave()
+	if !errors.Is(tmp_err, dht.ErrNoRoute) {
+		t.Fatalf("leave the last node with pairs: %v, want ErrNoRoute", tmp_err)
+	}
+	if !ring[0].DebugState().Running {
+		t.Fatalf("the node is not running after the failed leave")
+	}
+	value, tmp_err := ring[0].Get("key0")
+	if tmp_err != nil || value != "value" {
+		t.Fatalf("get after the failed leave = %q, %v", value, tmp_err)
+	}
+	tmp_err = ring[0].Put("key10", "value")
+	if tmp_err != nil {
+		t.Fatalf("put after the failed leave: %v", tmp_err)
+	}
+
+	//the station still serves, so a new node can join and take the pairs
+	node := new(chord.ChordNode)
+	node.InitWithConfig(22201, conf)
+	node.Run()
+	defer node.Quit()
+	tmp_err = node.Join(dht.JoinAddress(conf.AdvertiseAddress, 22200))
+	if tmp_err != nil {
+		t.Fatalf("join the node which stayed: %v", tmp_err)
+	}
+	time.Sleep(settleWait)
+	tmp_err = ring[0].Leave()
+	if tmp_err != nil {
+		t.Fatalf("leave again: %v", tmp_err)
+	}
+	for i := 0; i <= 10; i++ {
+		value, tmp_err := node.Get(fmt.Sprint("key", i))
+		if tmp_err != nil || value != "value" {
+			t.Errorf("key%d after the leave = %q, %v", i, value, tmp_err)
+		}
+	}
+}
+
+func TestQuitWithoutTakeOver(t *testing.T) {
+	conf := memory_config()
+	conf.Storage = chord.DiskStorage
+	conf.DataDir = t.TempDir()
+	ring := start_ring(t, conf, 22210, 1)
+	for i := 0; i < 10; i++ {
+		tmp_err := ring[0].Put(fmt.Sprint("key", i), "value")
+		if tmp_err != nil {
+			t.Fatalf("put key%d: %v", i, tmp_err)
+		}
+	}
+	//unlike Leave, Quit shuts the node down although no other node takes the pairs
+	ring[0].Quit()
+	if ring[0].DebugState().Running {
+		t.Errorf("the node is running after Quit")
+	}
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	if chord.CheckOnline(dht.JoinAddress(conf.AdvertiseAddress, 22210)) {
+		t.Errorf("the station still serves after Quit")
+	}
+	before := ring[0].DebugState().Next
+	time.Sleep(settleWait / 2)
+	if next := ring[0].DebugState().Next; next != before {
+		t.Errorf("fix_fingerTable goes on after Quit, next %d then %d", before, next)
+	}
+
+	//the disk storage kept the pairs
+	node := new(chord.ChordNode)
+	node.InitWithConfig(22210, conf)
+	node.Run()
+	node.Create()
+	defer node.Quit()
+	for i := 0; i < 10; i++ {
+		value, tmp_err := node.Get(fmt.Sprint("key", i))
+		if tmp_err != nil || value != "value" {
+			t.Errorf("key%d after the restart = %q, %v", i, value, tmp_err)
+		}
+	}
+}
+
+func TestQuitUnlinks(t *testing.T) {
+	const size = 7
+	conf := memory_config()
+	network := dht.NewFaultNetwork(conf.Transport, 1)
+	conf.Transport = network
+	ring := start_ring(t, conf, 22220, size)
+	byAddr := make(map[string]*chord.ChordNode)
+	var addrs []string
+	for i, node := range ring {
+		addr := dht.JoinAddress(conf.AdvertiseAddress, 22220+i)
+		byAddr[addr] = node
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	var keys []string
+	for i := 0; len(keys) < 10; i++ {
+		if key := fmt.Sprint("key", i); in_range(chord.ConsistentHash(key), chord.NodeID(addrs[0]), chord.NodeID(addrs[1])) {
+			keys = append(keys, key)
+			tmp_err := ring[0].Put(key, "value")
+			if tmp_err != nil {
+				t.Fatalf("put %s: %v", key, tmp_err)
+			}
+		}
+	}
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	//addrs[1] can reach none of its successors, so Quit shuts it down with its pairs,
+	//but its predecessor still learns of the successors past it
+	network.Partition(addrs[:2], addrs[2:])
+	byAddr[addrs[1]].Quit()
+	var succList [5]string
+	tmp_err := chord.RemoteCall(addrs[0], "WrapNode.GetSuccessorList", 0, &succList)
+	if tmp_err != nil || succList[0] != addrs[2] {
+		t.Errorf("the successors of the predecessor after Quit are %v, %v, want them from %s", succList, tmp_err, addrs[2])
+	}
+
+	//the replicas of the pairs are in addrs[2], which owns them now
+	network.Heal()
+	time.Sleep(settleWait)
+	for _, key := range keys {
+		value, tmp_err := byAddr[addrs[0]].Get(key)
+		if tmp_err != nil || value != "value" {
+			t.Errorf("get %s after Quit = %q, %v", key, value, tmp_err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package chord
+
+import (
+	"context"
+	"dht"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+)
+
+//LookupStep is the answer of one hop in an iterative lookup.
+type LookupStep struct {
+	//if Done, Next is the successor of the aim ID,
+	//otherwise Next is the closest preceding node known by this hop
+	Done bool
+	Next string
+	//used as fallback when Next does not answer
+	SuccessorList [successorListLength]string
+}
+
+//answer one hop of an iterative lookup
+func (this *ChordNode) closest_preceding_finger(aimID *big.Int, res *LookupStep) error {
+	ctx := context.Background()
+	var succAddr string
+	tmp_err := this.find_first_online_succ(ctx, &succAddr)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	this.get_successor_list(&res.SuccessorList)
+	if inDur(aimID, this.get_id(), NodeID(succAddr), true) {
+		res.Done = true
+		res.Next = succAddr
+		return nil
+	}
+	res.Done = false
+	res.Next = this.first_pre_node(ctx, aimID)
+	return nil
+}
+
+//the querying node drives the lookup itself: every hop is asked for its closest
+//preceding finger with a timeout of HopTimeout, and when that finger does not answer
+//the successors of the last hop which still precede aimID are tried instead.
+//A node which did not answer is not asked again in the same lookup, and when none
+//of the successors preceding aimID answers, the first one past aimID is the successor,
+//as when the successors before it have failed
+func (this *ChordNode) iterative_find_successor(ctx context.Context, aimID *big.Int, res *FindSuccessorRes) error {
+	var step LookupStep
+	tmp_err := this.closest_preceding_finger(aimID, &step)
+	if tmp_err != nil {
+		return dht.RemoteError(ctx, tmp_err)
+	}
+	curNode := this.get_address()
+	failed := make(map[string]bool)
+	for hop := 0; hop < fingerTableLength; hop++ {
+		if step.Done {
+			*res = FindSuccessorRes{Address: step.Next, Hops: hop}
+			return nil
+		}
+		//the finger first, then the successors from the farthest one
+		candidates := []string{step.Next}
+		for i := successorListLength - 1; i >= 0; i-- {
+			candidates = append(candidates, step.SuccessorList[i])
+		}
+		var nextStep LookupStep
+		nextNode := ""
+		for _, candidate := range candidates {
+			if candidate == "" || candidate == curNode || failed[candidate] {
+				continue
+			}
+			if candidate != step.Next && !inDur(NodeID(candidate), NodeID(curNode), aimID, false) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return dht.RemoteError(ctx, ctx.Err())
+			}
+			hopCtx, cancel := dht.WithTimeout(this.config.Clock, ctx, this.config.HopTimeout)
+			tmp_err = this.call_context(hopCtx, candidate, "WrapNode.ClosestPrecedingFinger", aimID, &nextStep)
+			cancel()
+			if tmp_err == nil {
+				nextNode = candidate
+				break
+			}
+			log.Warningln("In function iterative_find_successor hop", hop, "to", candidate, "failed because", tmp_err)
+			failed[candidate] = true
+		}
+		if nextNode == "" {
+			if ctx.Err() != nil {
+				return dht.RemoteError(ctx, ctx.Err())
+			}
+			for _, succ := range step.SuccessorList {
+				if succ != "" && !failed[succ] && inDur(aimID, NodeID(curNode), NodeID(succ), true) {
+					*res = FindSuccessorRes{Address: succ, Hops: hop}
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: no hop after %s answers", dht.ErrNoRoute, curNode)
+		}
+		curNode = nextNode
+		step = nextStep
+	}
+	return fmt.Errorf("%w: too many hops", dht.ErrNoRoute)
+}
@@ -0,0 +1,114 @@
+package chord_test
+
+import (
+	"bytes"
+	"chord"
+	"dht"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+//stuckNetwork is a transport on which the nodes in stuck never answer a lookup hop,
+//while the other calls to them go through
+type stuckNetwork struct {
+	dht.Transport
+	lock  sync.Mutex
+	stuck map[string]bool
+}
+
+type stuckConn struct {
+	net.Conn
+	network *stuckNetwork
+	address string
+}
+
+func (this *stuckNetwork) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, tmp_err := this.Transport.Dial(address, timeout)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	return &stuckConn{conn, this, address}, nil
+}
+
+func (this *stuckNetwork) set_stuck(address string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.stuck[address] = true
+}
+
+func (this *stuckNetwork) is_stuck(address string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.stuck[address]
+}
+
+//a request is written at once, so the method name is in the same write
+func (this *stuckConn) Write(b []byte) (int, error) {
+	if this.network.is_stuck(this.address) && bytes.Contains(b, []byte("ClosestPrecedingFinger")) {
+		return len(b), nil
+	}
+	return this.Conn.Write(b)
+}
+
+func TestIterativeLookupHopTimeout(t *testing.T) {
+	const hopTimeout = 200 * time.Millisecond
+	conf := memory_config()
+	network := &stuckNetwork{Transport: conf.Transport, stuck: make(map[string]bool)}
+	conf.Transport = network
+	conf.IterativeLookup = true
+	conf.HopTimeout = hopTimeout
+	ring := start_ring(t, conf, 21300, 8)
+	var addrs []string
+	for i := range ring {
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, 21300+i))
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	successor := make(map[string]string)
+	for i, addr := range addrs {
+		successor[addr] = addrs[(i+1)%len(addrs)]
+	}
+
+	//the farthest finger of the querying node is its first hop for the keys just after it
+	querier := ring[0]
+	state := querier.DebugState()
+	stuck := ""
+	for i := len(state.Fingers) - 1; i >= 0 && stuck == ""; i-- {
+		if addr := state.Fingers[i].Address; addr != state.Address && successor[addr] != state.Address {
+			stuck = addr
+		}
+	}
+	if stuck == "" {
+		t.Fatalf("%s has no finger to make stuck: %+v", state.Address, state.Fingers)
+	}
+	var keys []string
+	for i := 0; len(keys) < 5; i++ {
+		key := fmt.Sprint("key", i)
+		if in_range(chord.ConsistentHash(key), chord.NodeID(stuck), chord.NodeID(successor[stuck])) {
+			keys = append(keys, key)
+			tmp_err := ring[1].Put(key, "value")
+			if tmp_err != nil {
+				t.Fatalf("put %s: %v", key, tmp_err)
+			}
+		}
+	}
+
+	network.set_stuck(stuck)
+	for _, key := range keys {
+		start := time.Now()
+		value, tmp_err := querier.Get(key)
+		elapsed := time.Since(start)
+		if tmp_err != nil || value != "value" {
+			t.Errorf("get %s past the stuck %s = %q, %v", key, stuck, value, tmp_err)
+		}
+		//the stuck hop is waited for once, it is not asked again in the same lookup
+		if elapsed < hopTimeout || elapsed > 2*hopTimeout {
+			t.Errorf("get %s took %v, want about the hop timeout %v", key, elapsed, hopTimeout)
+		}
+	}
+}
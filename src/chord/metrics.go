@@ -0,0 +1,94 @@
+package chord
+
+import (
+	log "github.com/sirupsen/logrus"
+	"metrics"
+	"strings"
+	"time"
+)
+
+//the metrics of the chord nodes in this process are in metrics.Default,
+//a node serves them over http when Config.MetricsAddress is set
+
+var lookupHopBuckets = []float64{0, 1, 2, 3, 4, 6, 8, 12, 16, 24, 32}
+
+func count_sent(method string, failed bool) {
+	metrics.Default.Counter("chord_rpc_sent_total", "Rpc calls sent by method.", "method", method).Inc()
+	if failed {
+		metrics.Default.Counter("chord_rpc_sent_errors_total", "Rpc calls sent which failed by method.", "method", method).Inc()
+	}
+}
+
+//the service of a virtual node is "WrapNode#i", all of them are counted as "WrapNode"
+func served_func(address string) metrics.ServedFunc {
+	return func(method string, duration time.Duration, failed bool) {
+		if pos := strings.IndexAny(method, vnodeSeparator+idSeparator); pos >= 0 {
+			method = method[:pos] + method[strings.Index(method, "."):]
+		}
+		metrics.Default.Counter("chord_rpc_served_total", "Rpc calls served by method.", "node", address, "method", method).Inc()
+		if failed {
+			metrics.Default.Counter("chord_rpc_served_errors_total", "Rpc calls served with an error by method.", "node", address, "method", method).Inc()
+		}
+		metrics.Default.Histogram("chord_rpc_served_seconds", "Time to serve a rpc call.", metrics.DefaultBuckets, "node", address, "method", method).Observe(duration.Seconds())
+	}
+}
+
+func count_dial_failure() {
+	metrics.Default.Counter("chord_dial_failures_total", "Connections which could not be dialed in GetClient.").Inc()
+}
+
+func observe_lookup(hops int, duration time.Duration, failed bool) {
+	if failed {
+		metrics.Default.Counter("chord_lookup_failures_total", "Lookups which found no successor.").Inc()
+		return
+	}
+	metrics.Default.Histogram("chord_lookup_hops", "Remote hops of a successful lookup.", lookupHopBuckets).Observe(float64(hops))
+	metrics.Default.Histogram("chord_lookup_seconds", "Time of a successful lookup.", metrics.DefaultBuckets).Observe(duration.Seconds())
+}
+
+func (this *ChordNode) count_maintenance(task string) {
+	metrics.Default.Counter("chord_maintenance_total", "Iterations of background maintenance tasks.", "node", this.get_address(), "task", task).Inc()
+}
+
+func (this *ChordNode) register_gauges() {
+	metrics.Default.Gauge("chord_keys", "Pairs in dataSet.", func() float64 {
+		this.dataLock.RLock()
+		defer this.dataLock.RUnlock()
+		return float64(this.dataSet.Size())
+	}, "node", this.get_address())
+	metrics.Default.Gauge("chord_backup_keys", "Pairs in backupSet.", func() float64 {
+		this.backupLock.RLock()
+		defer this.backupLock.RUnlock()
+		return float64(this.backupSet.Size())
+	}, "node", this.get_address())
+}
+
+//the gauges read the node, so they go away with it
+func (this *ChordNode) unregister_gauges() {
+	metrics.Default.Unregister("chord_keys", "node", this.get_address())
+	metrics.Default.Unregister("chord_backup_keys", "node", this.get_address())
+}
+
+func (this *ChordNode) start_metrics() {
+	for _, node := range this.all_nodes() {
+		node.register_gauges()
+	}
+	if this.config.MetricsAddress == "" {
+		return
+	}
+	var tmp_err error
+	this.metricsServer, tmp_err = metrics.Default.Serve(this.config.MetricsAddress)
+	if tmp_err != nil {
+		log.Errorln("In function start_metrics can not serve metrics in", this.config.MetricsAddress, tmp_err)
+	}
+}
+
+func (this *ChordNode) stop_metrics() {
+	for _, node := range this.all_nodes() {
+		node.unregister_gauges()
+	}
+	if this.metricsServer != nil {
+		this.metricsServer.Close()
+		this.metricsServer = nil
+	}
+}
@@ -0,0 +1,44 @@
+package chord_test
+
+import (
+	"dht"
+	"metrics"
+	"strings"
+	"testing"
+)
+
+func metrics_text(t *testing.T) string {
+	t.Helper()
+	var res strings.Builder
+	tmp_err := metrics.Default.WriteText(&res)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	return res.String()
+}
+
+func TestMetricsOfQuitNode(t *testing.T) {
+	conf := memory_config()
+	ring := start_ring(t, conf, 21900, 2)
+	tmp_err := ring[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	label := `node="` + dht.JoinAddress(conf.AdvertiseAddress, 21901) + `"`
+	text := metrics_text(t)
+	for _, name := range []string{"chord_keys", "chord_backup_keys", "chord_rpc_served_seconds_count"} {
+		if !strings.Contains(text, name+"{"+label) {
+			t.Errorf("no %s of the node in\n%s", name, text)
+		}
+	}
+	ring[1].Quit()
+	text = metrics_text(t)
+	for _, name := range []string{"chord_keys", "chord_backup_keys"} {
+		if strings.Contains(text, name+"{"+label) {
+			t.Errorf("the %s gauge of the node is still there after Quit", name)
+		}
+	}
+	if !strings.Contains(text, `chord_keys{node="`+dht.JoinAddress(conf.AdvertiseAddress, 21900)+`"}`) {
+		t.Errorf("the gauge of the remaining node is gone")
+	}
+}
@@ -1,121 +1,310 @@
 package chord
 
 import (
+	"context"
+	"crypto/tls"
+	"dht"
 	"errors"
 	log "github.com/sirupsen/logrus"
+	"metrics"
 	"net"
 	"net/rpc"
-	"time"
+	"rpcpool"
+	"strings"
+	"sync"
 )
 
+//caller makes the calls of the nodes with the same Config settings, see rpcpool.Callers
+type caller struct {
+	*rpcpool.Caller
+}
+
+//the callers of chord, the one of RemoteCall and CheckOnline is set by SetTransport,
+//SetClientTLS, SetClusterKey and SetClock
+var callers = rpcpool.NewCallers(func(c *rpcpool.Caller) rpcpool.DialFunc {
+	return caller{c}.get_client
+})
+
+//the caller of a node with conf
+func config_caller(conf Config) caller {
+	return caller{callers.For(rpcpool.Settings{Transport: conf.Transport, TLS: conf.TLS, Key: string(conf.ClusterKey), Clock: conf.Clock})}
+}
+
+func default_caller() caller {
+	return caller{callers.Default()}
+}
+
+//SetClientTLS sets the TLS config RemoteCall and CheckOnline use, nil means plaintext.
+//A node calls others with its own Config, so it is only needed by a tool calling nodes.
+func SetClientTLS(conf *tls.Config) {
+	callers.SetClientTLS(conf)
+}
+
+//SetTransport sets the transport RemoteCall and CheckOnline use, nil means TCP.
+func SetTransport(t dht.Transport) {
+	callers.SetTransport(t)
+}
+
+//SetClock sets the clock RemoteCall and CheckOnline are measured with, nil means dht.RealClock.
+func SetClock(c dht.Clock) {
+	callers.SetClock(c)
+}
+
+//SetClusterKey sets the key RemoteCall and CheckOnline sign the calls with,
+//nil means they are not signed.
+func SetClusterKey(key []byte) {
+	callers.SetClusterKey(key)
+}
+
 type network struct {
 	serv    *rpc.Server
 	lis     net.Listener
 	nodePtr *WrapNode
+	//the node which runs the station and the address it listens on for others
+	owner   *ChordNode
+	address string
+	//rpc services of the node and its virtual nodes by the name they were registered with,
+	//a node which moves keeps its service, see route
+	services    map[string]*WrapNode
+	serviceLock sync.Mutex
+	//checks the calls if Config.ClusterKey is set
+	auth *dht.ClusterAuth
+
+	//accepted connections, they are closed in ShutDown so that
+	//the pooled connections of other nodes can not reach a quited node
+	conns    map[net.Conn]bool
+	connLock sync.Mutex
 }
 
-func Accept(ser *rpc.Server, lis net.Listener, ptr *ChordNode) {
+//Accept serves the connections of lis until a signal on quit, which is the IsQuit
+//of ptr when the station started, as reset makes a new one for the next run
+func Accept(ser *rpc.Server, lis net.Listener, ptr *ChordNode, quit <-chan bool) {
 	for {
 		//always run
 		conn, tmp_err := lis.Accept()
 		select {
-		case <-ptr.IsQuit:
+		case <-quit:
 			return
 		default:
 			if tmp_err != nil {
 				log.Print("rpc.Serve: accept:", tmp_err.Error())
 				return
 			}
-			go ser.ServeConn(conn)
+			go ptr.station.serve(conn)
 		}
 	}
 }
 
+func (this *network) serve(conn net.Conn) {
+	this.connLock.Lock()
+	this.conns[conn] = true
+	this.connLock.Unlock()
+	var codec rpc.ServerCodec
+	if this.auth != nil {
+		codec = metrics.WrapServerCodec(this.auth.NewServerCodec(conn, this.address), served_func(this.address))
+	} else {
+		codec = metrics.NewServerCodec(conn, served_func(this.address))
+	}
+	this.serv.ServeCodec(&routeCodec{codec, this})
+	this.connLock.Lock()
+	delete(this.conns, conn)
+	this.connLock.Unlock()
+}
+
 func (this *network) Init(address string, ptr *ChordNode) error {
 	this.serv = rpc.NewServer()
 	this.nodePtr = new(WrapNode)
 	this.nodePtr.node = ptr
+	this.owner = ptr
+	this.address, _ = splitAddress(ptr.get_address())
+	this.services = map[string]*WrapNode{"WrapNode": this.nodePtr}
+	this.conns = make(map[net.Conn]bool)
+	if ptr.config.ClusterKey != nil {
+		this.auth = dht.NewClusterAuth(ptr.config.ClusterKey)
+	}
 	//register rpc service
 	tmp_err := this.serv.Register(this.nodePtr)
 	if tmp_err != nil {
 		log.Errorf("[error] register rpc service error!")
 		return tmp_err
 	}
+	retired := &ChordNode{address: this.address, ID: NodeID(this.address), config: ptr.config, caller: ptr.caller, retired: true, leaving: true,
+		dataSet: newMemStorage(), backupSet: newMemStorage(), IsQuit: make(chan bool, 2)}
+	tmp_err = this.serv.RegisterName(retiredService, &WrapNode{node: retired})
+	if tmp_err != nil {
+		log.Errorf("[error] register rpc service %s error!", retiredService)
+		return tmp_err
+	}
 	//for tcp listen
-	this.lis, tmp_err = net.Listen("tcp", address)
+	this.lis, tmp_err = dht.Listen(ptr.config.Transport, address, ptr.config.TLS)
 	if tmp_err != nil {
 		log.Errorf("[error] tcp error!")
 		return tmp_err
 	}
-	go Accept(this.serv, this.lis, this.nodePtr.node)
+	ptr.rwLock.RLock()
+	quit := ptr.IsQuit
+	ptr.rwLock.RUnlock()
+	go Accept(this.serv, this.lis, ptr, quit)
+	return nil
+}
+
+//Register adds the rpc service of a virtual node to this station.
+func (this *network) Register(ptr *ChordNode) error {
+	_, service := splitAddress(ptr.get_address())
+	service = service_slot(service)
+	wrap := new(WrapNode)
+	wrap.node = ptr
+	tmp_err := this.serv.RegisterName(service, wrap)
+	if tmp_err != nil {
+		log.Errorf("[error] register rpc service %s error!", service)
+		return tmp_err
+	}
+	this.serviceLock.Lock()
+	this.services[service] = wrap
+	this.serviceLock.Unlock()
+	return nil
+}
+
+//the service answering the calls to the addresses the nodes of a station moved away from
+const retiredService = "Retired"
+
+//route gives the service which serves service, the one of the node whose address
+//gives service, or retiredService if no node of the station has that address now
+func (this *network) route(service string) string {
+	slot := service_slot(service)
+	this.serviceLock.Lock()
+	wrap, ok := this.services[slot]
+	this.serviceLock.Unlock()
+	if !ok {
+		return service
+	}
+	_, current := splitAddress(wrap.node.get_address())
+	if current != service {
+		return retiredService
+	}
+	return slot
+}
+
+//the name the service of a node is registered with, which does not change when it moves
+func service_slot(service string) string {
+	if pos := strings.Index(service, idSeparator); pos >= 0 {
+		return service[:pos]
+	}
+	return service
+}
+
+//routeCodec sends each call to the service route gives, since net/rpc
+//can not rename or remove a service
+type routeCodec struct {
+	rpc.ServerCodec
+	station *network
+}
+
+func (this *routeCodec) ReadRequestHeader(r *rpc.Request) error {
+	tmp_err := this.ServerCodec.ReadRequestHeader(r)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	if pos := strings.LastIndex(r.ServiceMethod, "."); pos >= 0 {
+		r.ServiceMethod = this.station.route(r.ServiceMethod[:pos]) + r.ServiceMethod[pos:]
+	}
 	return nil
 }
 
 func (this *network) ShutDown() error {
-	this.nodePtr.node.IsQuit <- true
+	this.owner.IsQuit <- true
 	tmp_err := this.lis.Close()
 	if tmp_err != nil {
 		log.Errorln("ShutDown error")
 	}
+	this.connLock.Lock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.connLock.Unlock()
 	return tmp_err
 }
 
+//GetClient dials addr with the settings of RemoteCall.
 func GetClient(addr string) (*rpc.Client, error) {
-	var res *rpc.Client
-	var err error
-	errCh := make(chan error)
-	for i := 0; i < 5; i++ {
-		go func() {
-			res, err = rpc.Dial("tcp", addr)
-			errCh <- err
-		}()
-		select {
-		case <-errCh:
-			if err == nil {
-				return res, err
-			} else {
-				return nil, err
-			}
-		case <-time.After(waitTime):
-			log.Errorln("In function GetClient time out in" + addr)
-			err = errors.New("Time out!")
-		}
+	return default_caller().get_client(addr)
+}
+
+func (this caller) get_client(addr string) (*rpc.Client, error) {
+	client, tmp_err := this.DialWait(addr, 5, waitTime)
+	if errors.Is(tmp_err, rpcpool.ErrDialTimeout) {
+		log.Errorln("In function GetClient time out in" + addr)
 	}
-	return nil, err
+	if tmp_err != nil {
+		count_dial_failure()
+	}
+	return client, tmp_err
 }
 
 func RemoteCall(aimNode string, aimFunc string, input interface{}, res interface{}) error {
+	return RemoteCallContext(context.Background(), aimNode, aimFunc, input, res)
+}
+
+//RemoteCallContext gives up when ctx is done, res is only written if the call succeeds.
+func RemoteCallContext(ctx context.Context, aimNode string, aimFunc string, input interface{}, res interface{}) error {
+	return default_caller().remote_call(ctx, "", aimNode, aimFunc, input, res)
+}
+
+//call is RemoteCall made by this node
+func (this *ChordNode) call(aimNode string, aimFunc string, input interface{}, res interface{}) error {
+	return this.call_context(context.Background(), aimNode, aimFunc, input, res)
+}
+
+func (this *ChordNode) call_context(ctx context.Context, aimNode string, aimFunc string, input interface{}, res interface{}) error {
+	from, _ := splitAddress(this.get_address())
+	return this.caller.remote_call(ctx, from, aimNode, aimFunc, input, res)
+}
+
+//online is CheckOnlineContext made by this node
+func (this *ChordNode) online(ctx context.Context, addr string) bool {
+	from, _ := splitAddress(this.get_address())
+	return this.caller.check_online(ctx, from, addr)
+}
+
+//from is the network address of the calling station
+func (this caller) remote_call(ctx context.Context, from string, aimNode string, aimFunc string, input interface{}, res interface{}) error {
 	if aimNode == "" {
 		log.Warningln("<RemoteCall> IP address is nil")
 		return errors.New("Null address for RemoteCall")
 	}
-	c, tmp_err := GetClient(aimNode)
-	if tmp_err != nil {
-		log.Warningln("<RemoteCall> Fail to dial in ", aimNode, " and error is ", tmp_err)
-		return tmp_err
-	}
-	if c != nil {
-		defer c.Close()
-	}
-	tmp_err = c.Call(aimFunc, input, res)
+	netAddr, method := routeCall(aimNode, aimFunc)
+	start := this.Clock.Now()
+	tmp_err := this.Pool.CallContext(ctx, this.PoolKey(from, netAddr), method, input, res)
+	count_sent(aimFunc, tmp_err != nil)
 	if tmp_err != nil {
 		log.Infoln("Can not call function in ", aimNode, " the func is ", aimFunc, tmp_err)
 	} else {
+		this.observe_rtt(netAddr, this.Clock.Now().Sub(start))
 		log.Infoln("<RemoteCall> in ", aimNode, " with ", aimFunc, " success!")
 	}
 	return tmp_err
 }
 
 func CheckOnline(addr string) bool {
+	return CheckOnlineContext(context.Background(), addr)
+}
+
+func CheckOnlineContext(ctx context.Context, addr string) bool {
+	return default_caller().check_online(ctx, "", addr)
+}
+
+func (this caller) check_online(ctx context.Context, from string, addr string) bool {
 	if addr == "" {
 		log.Warningln("In checkonline the addr is nil")
 		return false
 	}
-	cli, _ := GetClient(addr)
-	if cli != nil {
-		defer cli.Close()
-		return true
-	} else {
-		return false
+	var o string
+	netAddr, method := routeCall(addr, "WrapNode.Ping")
+	start := this.Clock.Now()
+	tmp_err := this.Pool.CallContext(ctx, this.PoolKey(from, netAddr), method, 0, &o)
+	count_sent("WrapNode.Ping", tmp_err != nil)
+	if tmp_err == nil {
+		this.observe_rtt(netAddr, this.Clock.Now().Sub(start))
 	}
+	return tmp_err == nil
 }
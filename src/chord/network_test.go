@@ -0,0 +1,143 @@
+package chord_test
+
+import (
+	"chord"
+	"context"
+	"dht"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/rand"
+	"sim"
+	"testing"
+	"time"
+)
+
+//how long a small ring needs to settle after the joins
+const settleWait = 2 * time.Second
+
+//memory_config is the default config on a new MemoryNetwork
+func memory_config() chord.Config {
+	log.SetOutput(ioutil.Discard)
+	conf := chord.DefaultConfig()
+	conf.AdvertiseAddress = "chord"
+	conf.Transport = dht.NewMemoryNetwork()
+	return conf
+}
+
+//start_ring runs size nodes with conf on the ports from firstPort, the first one creates
+//the ring and the others join it, and the nodes quit when the test ends
+func start_ring(t *testing.T, conf chord.Config, firstPort int, size int) []*chord.ChordNode {
+	t.Helper()
+	var nodes []*chord.ChordNode
+	for i := 0; i < size; i++ {
+		node := new(chord.ChordNode)
+		node.InitWithConfig(firstPort+i, conf)
+		node.Run()
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.Quit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		tmp_err := nodes[i].Join(dht.JoinAddress(conf.AdvertiseAddress, firstPort))
+		if tmp_err != nil {
+			t.Fatalf("join %d: %v", firstPort+i, tmp_err)
+		}
+	}
+	time.Sleep(settleWait)
+	return nodes
+}
+
+func TestTwoNetworksInOneProcess(t *testing.T) {
+	//the same addresses on two transports are two rings
+	first := start_ring(t, memory_config(), 21000, 3)
+	second := start_ring(t, memory_config(), 21000, 3)
+	tmp_err := first[1].Put("key", "first")
+	if tmp_err != nil {
+		t.Fatalf("put in the first ring: %v", tmp_err)
+	}
+	value, tmp_err := first[2].Get("key")
+	if tmp_err != nil || value != "first" {
+		t.Fatalf("get in the first ring = %q, %v", value, tmp_err)
+	}
+	_, tmp_err = second[2].Get("key")
+	if !errors.Is(tmp_err, dht.ErrNotFound) {
+		t.Fatalf("get in the second ring: %v, want ErrNotFound", tmp_err)
+	}
+}
+
+func TestManyNodes(t *testing.T) {
+	const size = 300
+	const firstPort = 23100
+	//virtual time on the memory network of the simulator, so the maintenance of the nodes
+	//runs as fast as they can do it
+	s := sim.New(1)
+	conf := memory_config()
+	conf.Transport = s.Network()
+	conf.Clock = s
+	seed := dht.JoinAddress(conf.AdvertiseAddress, firstPort)
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	var joinErr, putErr error
+	var report *chord.RingReport
+	var failed []string
+	s.Run(func() {
+		//the nodes share the caller of their config and its pool of connections
+		var ring []*chord.ChordNode
+		for i := 0; i < size; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(firstPort+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		defer func() {
+			for _, node := range ring {
+				node.ForceQuit()
+			}
+		}()
+		ring[0].Create()
+		//a ring settles much sooner when the joins are spread out a bit
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(seed); tmp_err != nil {
+				joinErr = tmp_err
+				return
+			}
+			s.Sleep(50 * time.Millisecond)
+		}
+		for try := 0; try < 60; try++ {
+			s.Sleep(time.Second)
+			var tmp_err error
+			report, tmp_err = chord.CheckRing(context.Background(), seed, false)
+			if tmp_err == nil && len(report.Nodes) == size && len(report.Violations) == 0 {
+				break
+			}
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 100; i++ {
+			if putErr = ring[r.Intn(size)].Put(fmt.Sprint("key", i), fmt.Sprint("value", i)); putErr != nil {
+				return
+			}
+		}
+		for i := 0; i < 100; i++ {
+			at := r.Intn(size)
+			value, tmp_err := ring[at].Get(fmt.Sprint("key", i))
+			if tmp_err != nil || value != fmt.Sprint("value", i) {
+				failed = append(failed, fmt.Sprintf("get key%d from node %d = %q, %v", i, at, value, tmp_err))
+			}
+		}
+	})
+	if joinErr != nil || putErr != nil {
+		t.Fatalf("join: %v, put: %v", joinErr, putErr)
+	}
+	if report == nil || len(report.Nodes) != size || len(report.Violations) > 0 {
+		t.Fatalf("the ring of %d nodes does not settle: %+v", size, report)
+	}
+	for _, fail := range failed {
+		t.Error(fail)
+	}
+}
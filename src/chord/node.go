@@ -1,26 +1,40 @@
 package chord
 
 import (
+	"context"
+	"dht"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"math/big"
+	"merkle"
+	"net/http"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 const successorListLength int = 5
 const fingerTableLength int = 160
+const expireSweepPeriod = time.Second
 
 type ChordNode struct {
-	//address & ID
+	//address & ID, they change when the node moves, see balance.go
 	address string
 	ID      *big.Int
+	idLock  sync.RWMutex
 
 	//network
 	station *network
+	//makes the calls to other nodes with the settings of config
+	caller caller
+	//address the station listens on
+	bindAddress string
+	//serves the metrics if Config.MetricsAddress is set
+	metricsServer *http.Server
 
-	//for quit
+	//for quit, both under rwLock
 	IsQuit         chan bool
 	conRoutineFlag bool
 
@@ -29,67 +43,168 @@ type ChordNode struct {
 	fingerTable   [fingerTableLength]string
 	predecessor   string
 	rwLock        sync.RWMutex
+	//the other candidates of each finger, see proximity.go
+	fingerCands [fingerTableLength][]string
 
 	//for data
-	dataSet    map[string]string
-	backupSet  map[string]string
+	config     Config
+	dataSet    Storage
+	backupSet  Storage
 	dataLock   sync.RWMutex
 	backupLock sync.RWMutex
+	//the trees over backupSet built for the comparisons of the owners, see antientropy.go
+	merkleTrees *merkle.Cache
+	//set by leave under dataLock, the pairs can not change any more
+	leaving bool
+	//when reserve_split gave out a split point, under dataLock
+	lastSplit time.Time
+	//stands for an address the node moved away from, see balance.go
+	retired bool
+	//bumped by bgMaintain, so the loops of an earlier run stop
+	maintainGen int
+
+	//successors holding replicas of dataSet in the last stabilize
+	replicaList []string
+
+	//virtual nodes sharing the station of this node
+	vnodes []*ChordNode
 
 	next int
 }
 
 func (this *ChordNode) Init(port int) {
-	this.address = fmt.Sprintf("%s:%d", localAddress, port)
-	this.ID = ConsistentHash(this.address)
+	this.InitWithConfig(port, DefaultConfig())
+}
+
+func (this *ChordNode) InitWithConfig(port int, conf Config) {
+	if conf.VirtualNodes < 1 {
+		conf.VirtualNodes = 1
+	}
+	this.init_node(dht.JoinAddress(conf.AdvertiseAddress, port), conf)
+	this.bindAddress = this.get_address()
+	if conf.BindAddress != "" {
+		this.bindAddress = dht.JoinAddress(conf.BindAddress, port)
+	}
+	this.init_vnodes(this.config)
+}
+
+func (this *ChordNode) init_node(address string, conf Config) {
+	this.address = address
+	this.ID = NodeID(this.address)
+	if conf.Replicas < 1 || conf.Replicas > successorListLength {
+		log.Errorln("In function InitWithConfig replicas should be in [ 1 ,", successorListLength, "] but is", conf.Replicas)
+		conf.Replicas = DefaultConfig().Replicas
+	}
+	if conf.HopTimeout <= 0 {
+		conf.HopTimeout = DefaultConfig().HopTimeout
+	}
+	if conf.BalancePeriod <= 0 {
+		conf.BalancePeriod = DefaultConfig().BalancePeriod
+	}
+	if conf.BalanceRatio < 1 {
+		conf.BalanceRatio = DefaultConfig().BalanceRatio
+	}
+	if conf.Clock == nil {
+		conf.Clock = dht.RealClock
+	}
+	this.config = conf
+	this.caller = config_caller(conf)
+	this.merkleTrees = merkle.NewCache(successorListLength)
 	this.conRoutineFlag = false
 	this.reset()
 }
 
+func (this *ChordNode) get_address() string {
+	this.idLock.RLock()
+	defer this.idLock.RUnlock()
+	return this.address
+}
+
+func (this *ChordNode) get_id() *big.Int {
+	this.idLock.RLock()
+	defer this.idLock.RUnlock()
+	return this.ID
+}
+
+//whether the node is in the ring, so its loops run and it serves calls
+func (this *ChordNode) is_running() bool {
+	this.rwLock.RLock()
+	defer this.rwLock.RUnlock()
+	return this.conRoutineFlag
+}
+
 func (this *ChordNode) Run() {
 	this.station = new(network)
 	//create a station for this node.
-	tmp_err := this.station.Init(this.address, this)
+	tmp_err := this.station.Init(this.bindAddress, this)
 	if tmp_err != nil {
-		log.Errorln("Run error in ", this.address)
+		log.Errorln("Run error in ", this.get_address())
 		return
 	}
-	log.Infoln("Run success in ", this.address)
-	this.conRoutineFlag = true //after joining in the network always run stablize and fix_finger.
-	this.next = 1
+	for _, vnode := range this.vnodes {
+		vnode.station = this.station
+		tmp_err = this.station.Register(vnode)
+		if tmp_err != nil {
+			log.Errorln("Run error in ", vnode.get_address())
+			return
+		}
+	}
+	log.Infoln("Run success in ", this.get_address())
+	this.start_metrics()
+	for _, node := range this.all_nodes() {
+		node.rwLock.Lock()
+		node.conRoutineFlag = true //after joining in the network always run stablize and fix_finger.
+		node.next = 1
+		node.rwLock.Unlock()
+	}
 }
 
 func (this *ChordNode) Create() {
 	this.predecessor = ""
-	this.fingerTable[0] = this.address
-	this.successorList[0] = this.address
+	this.fingerTable[0] = this.get_address()
+	this.successorList[0] = this.get_address()
 	this.bgMaintain()
+	tmp_err := this.join_vnodes(this.get_address())
+	if tmp_err != nil {
+		log.Errorln("In function Create", tmp_err)
+	}
 }
 
-func (this *ChordNode) Join(addr string) bool {
+func (this *ChordNode) Join(addr string) error {
+	tmp_err := this.join_self(addr)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	//the node itself is in the network even if a virtual node is not
+	return this.join_vnodes(addr)
+}
+
+//join the node without its virtual nodes
+func (this *ChordNode) join_self(addr string) error {
 	//Node "this" join in a network by node "addr"
 	//function join just indicates the existence of the node
-	isOnline := CheckOnline(addr)
+	isOnline := this.online(context.Background(), addr)
 	if !isOnline {
 		log.Errorln("Node Join Error : Node is not online!")
-		return false
+		return dht.ErrNoRoute
 	}
-	var succAddr string
+	var found FindSuccessorRes
 	//Call node "addr" to find the successor of node "this"
-	tmp_err := RemoteCall(addr, "WrapNode.FindSuccessor", this.ID, &succAddr)
+	tmp_err := this.call(addr, "WrapNode.FindSuccessor", FindSuccessorArg{ID: this.get_id()}, &found)
 	if tmp_err != nil {
 		log.Errorln("In function Join FindSuccessor remote call error")
-		return false
+		return dht.RemoteError(context.Background(), tmp_err)
 	}
+	succAddr := found.Address
 	var tmpSuccList [successorListLength]string
 	//Call node successor to get successor list of node "succAddr"
 	//the result is in "tmpSuccList"
-	tmp_err = RemoteCall(succAddr, "WrapNode.GetSuccessorList", 0, &tmpSuccList)
+	tmp_err = this.call(succAddr, "WrapNode.GetSuccessorList", 0, &tmpSuccList)
 	if tmp_err != nil {
 		log.Errorln("In function Join GetSuccessor remote call error")
-		return false
+		return dht.RemoteError(context.Background(), tmp_err)
 	}
-	log.Infoln("Join node success! The address is", this.address)
+	log.Infoln("Join node success! The address is", this.get_address())
 	this.rwLock.Lock()
 	this.predecessor = ""
 	this.successorList[0] = succAddr
@@ -99,146 +214,262 @@ func (this *ChordNode) Join(addr string) bool {
 	}
 	this.rwLock.Unlock()
 	//Transfer data from succAddr to this
-	tmp_err = RemoteCall(succAddr, "WrapNode.TransferData", this.address, &this.dataSet)
+	var data map[string]DataItem
+	tmp_err = this.call(succAddr, "WrapNode.TransferData", this.get_address(), &data)
 	if tmp_err != nil {
 		log.Errorln("In function Join TransferDate error")
-		return false
+		return dht.RemoteError(context.Background(), tmp_err)
 	}
+	this.dataLock.Lock()
+	storagePutAll(this.dataSet, data)
+	this.dataLock.Unlock()
 	this.bgMaintain()
-	return true
+	return nil
 }
 
+//Quit is Leave, but the node is shut down even when no successor takes its pairs over,
+//a disk storage keeps them then, and its predecessor is still pointed past it.
 func (this *ChordNode) Quit() {
-	if !this.conRoutineFlag {
-		return
-	}
-	tmp_err := this.station.ShutDown()
-	if tmp_err != nil {
-		log.Errorln("In function Quit station shutDown error")
-	}
-	this.rwLock.Lock()
-	this.conRoutineFlag = false
-	this.rwLock.Unlock()
-	var succAddr string
-	this.find_first_online_succ(&succAddr)
-	var o string
-	tmp_err = RemoteCall(succAddr, "WrapNode.ChangePredecessor", 0, &o)
-	if tmp_err != nil {
-		log.Errorln("In function Quit checkpre error")
+	tmp_err := this.leave_network(context.Background(), false)
+	if tmp_err != nil && tmp_err != dht.ErrNotJoined {
+		log.Errorln("In function Quit", tmp_err)
 	}
-	tmp_err = RemoteCall(this.predecessor, "WrapNode.Stabilize", 0, &o)
-	if tmp_err != nil {
-		log.Errorln("In function Quit stabilize error")
-	}
-	this.reset()
 }
 
 func (this *ChordNode) ForceQuit() {
 	//compare to Quit, ForceQuit can't notify other nodes
-	if !this.conRoutineFlag {
+	if !this.joined() {
 		return
 	}
 	tmp_err := this.station.ShutDown()
 	if tmp_err != nil {
 		log.Errorln("In function ForceQuit station shutDown error")
 	}
+	this.stop_metrics()
 
-	this.rwLock.Lock()
-	this.conRoutineFlag = false
-	this.rwLock.Unlock()
+	for _, node := range this.all_nodes() {
+		node.rwLock.Lock()
+		node.conRoutineFlag = false
+		node.rwLock.Unlock()
 
-	this.reset()
+		node.reset()
+	}
 }
 
 func (this *ChordNode) Ping(addr string) bool {
-	isOnline := CheckOnline(addr)
+	isOnline := this.online(context.Background(), addr)
 	return isOnline
 }
 
 type KeyValuePair struct {
 	Key   string
 	Value string
+	//set by the owner when the pair is mirrored to backups
+	Version uint64
+	//the pair expires TTL after the owner stores it, 0 means never
+	TTL    time.Duration
+	Expire time.Time
+}
+
+type CompareAndSwapArg struct {
+	Key      string
+	Expected uint64
+	Value    string
 }
 
-func (this *ChordNode) Put(key string, value string) bool {
-	if !this.conRoutineFlag {
+//CompareAndSwapRes is the reply of a CompareAndSwap call, a mismatch is not an error
+//of the call because net/rpc does not send the reply of a failed call
+type CompareAndSwapRes struct {
+	//the new version, or the current one if Mismatch
+	Version  uint64
+	Mismatch bool
+}
+
+func (this *ChordNode) Put(key string, value string) error {
+	return this.PutContext(context.Background(), key, value)
+}
+
+//PutContext is like Put, but it stops waiting when ctx is done.
+func (this *ChordNode) PutContext(ctx context.Context, key string, value string) error {
+	return this.put_pair(ctx, KeyValuePair{Key: key, Value: value})
+}
+
+//PutWithTTL is like Put, but the pair is dropped ttl after it is stored.
+func (this *ChordNode) PutWithTTL(key string, value string, ttl time.Duration) error {
+	return this.PutWithTTLContext(context.Background(), key, value, ttl)
+}
+
+func (this *ChordNode) PutWithTTLContext(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("TTL should be positive")
+	}
+	return this.put_pair(ctx, KeyValuePair{Key: key, Value: value, TTL: ttl})
+}
+
+func (this *ChordNode) put_pair(ctx context.Context, p KeyValuePair) error {
+	if !this.is_running() {
 		//node this is sleep
-		return false
+		return dht.ErrNotJoined
 	}
 
 	//fmt.Println("Hello this is in function Put")
 
 	var aimAddr string
-	tmp_err := this.innner_find_successor(ConsistentHash(key), &aimAddr)
+	tmp_err := this.innner_find_successor(ctx, ConsistentHash(p.Key), &aimAddr)
 	if tmp_err != nil {
-		log.Errorln("In function Put can not get successor of key : ", key)
-		return false
+		log.Errorln("In function Put can not get successor of key : ", p.Key)
+		return tmp_err
 	}
-	p := KeyValuePair{key, value}
 	var o string
-	tmp_err = RemoteCall(aimAddr, "WrapNode.InsertPairInData", p, &o)
+	tmp_err = this.call_context(ctx, aimAddr, "WrapNode.InsertPairInData", p, &o)
 	if tmp_err != nil {
-		log.Errorln("In function Put insert pair error", key, value)
-		return false
+		log.Errorln("In function Put insert pair error", p.Key, p.Value)
+		return dht.RemoteError(ctx, tmp_err)
 	}
-	log.Infoln("Put pair success", key, value, aimAddr)
-	return true
+	log.Infoln("Put pair success", p.Key, p.Value, aimAddr)
+	return nil
+}
+
+func (this *ChordNode) Get(key string) (string, error) {
+	return this.GetContext(context.Background(), key)
 }
 
-func (this *ChordNode) Get(key string) (bool, string) {
-	if !this.conRoutineFlag {
-		return false, ""
+//GetContext is like Get, but it stops waiting when ctx is done.
+func (this *ChordNode) GetContext(ctx context.Context, key string) (string, error) {
+	if !this.is_running() {
+		return "", dht.ErrNotJoined
 	}
 	var aimAddr string
-	tmp_err := this.innner_find_successor(ConsistentHash(key), &aimAddr)
+	tmp_err := this.innner_find_successor(ctx, ConsistentHash(key), &aimAddr)
 	if tmp_err != nil {
 		log.Errorln("Can not find the aim node for key : ", key)
-		return false, ""
+		return "", tmp_err
 	}
 	var res string
-	tmp_err = RemoteCall(aimAddr, "WrapNode.GetValue", key, &res)
+	tmp_err = this.call_context(ctx, aimAddr, "WrapNode.GetValue", key, &res)
 	if tmp_err != nil {
 		log.Errorln("Get value error", key)
-		return false, ""
+		return "", dht.RemoteError(ctx, tmp_err)
 	}
-	return true, res
+	return res, nil
 }
 
-func (this *ChordNode) Delete(key string) bool {
-	if !this.conRoutineFlag {
-		return false
+//GetWithVersion is like Get, and it also returns the version of the value
+//which can be passed to CompareAndSwap.
+func (this *ChordNode) GetWithVersion(key string) (string, uint64, error) {
+	return this.GetWithVersionContext(context.Background(), key)
+}
+
+func (this *ChordNode) GetWithVersionContext(ctx context.Context, key string) (string, uint64, error) {
+	if !this.is_running() {
+		return "", 0, dht.ErrNotJoined
 	}
 	var aimAddr string
-	tmp_err := this.innner_find_successor(ConsistentHash(key), &aimAddr)
+	tmp_err := this.innner_find_successor(ctx, ConsistentHash(key), &aimAddr)
+	if tmp_err != nil {
+		log.Errorln("Can not find the aim node for key : ", key)
+		return "", 0, tmp_err
+	}
+	var res DataItem
+	tmp_err = this.call_context(ctx, aimAddr, "WrapNode.GetItem", key, &res)
+	if tmp_err != nil {
+		log.Errorln("Get item error", key)
+		return "", 0, dht.RemoteError(ctx, tmp_err)
+	}
+	return res.Value, res.Version, nil
+}
+
+//CompareAndSwap sets key to value only if its version is still expectedVersion,
+//use 0 to create a key which is not stored yet. It returns the new version,
+//or the current version with dht.ErrVersionMismatch. The versions of a key only
+//grow, also when it is deleted and stored again. A value put with a TTL keeps
+//its expiry when it is swapped.
+func (this *ChordNode) CompareAndSwap(key string, expectedVersion uint64, value string) (uint64, error) {
+	return this.CompareAndSwapContext(context.Background(), key, expectedVersion, value)
+}
+
+func (this *ChordNode) CompareAndSwapContext(ctx context.Context, key string, expectedVersion uint64, value string) (uint64, error) {
+	if !this.is_running() {
+		return 0, dht.ErrNotJoined
+	}
+	var aimAddr string
+	tmp_err := this.innner_find_successor(ctx, ConsistentHash(key), &aimAddr)
+	if tmp_err != nil {
+		log.Errorln("In function CompareAndSwap can not get successor of key : ", key)
+		return 0, tmp_err
+	}
+	var res CompareAndSwapRes
+	tmp_err = this.call_context(ctx, aimAddr, "WrapNode.CompareAndSwap", CompareAndSwapArg{key, expectedVersion, value}, &res)
+	if tmp_err != nil {
+		return 0, dht.RemoteError(ctx, tmp_err)
+	}
+	if res.Mismatch {
+		return res.Version, fmt.Errorf("%w: version of %s is %d", dht.ErrVersionMismatch, key, res.Version)
+	}
+	return res.Version, nil
+}
+
+func (this *ChordNode) Delete(key string) error {
+	return this.DeleteContext(context.Background(), key)
+}
+
+//DeleteContext is like Delete, but it stops waiting when ctx is done.
+func (this *ChordNode) DeleteContext(ctx context.Context, key string) error {
+	if !this.is_running() {
+		return dht.ErrNotJoined
+	}
+	var aimAddr string
+	tmp_err := this.innner_find_successor(ctx, ConsistentHash(key), &aimAddr)
 	if tmp_err != nil {
 		log.Errorln("In function delete find successor error", key)
-		return false
+		return tmp_err
 	}
 	var o string
-	tmp_err = RemoteCall(aimAddr, "WrapNode.ErasePairInData", key, &o)
+	tmp_err = this.call_context(ctx, aimAddr, "WrapNode.ErasePairInData", key, &o)
 	if tmp_err != nil {
 		log.Errorln("In function delete can not erase key")
-		return false
+		return dht.RemoteError(ctx, tmp_err)
 	}
-	return true
+	return nil
 }
 
 //private functions:
 
-func (this *ChordNode) innner_find_successor(aimID *big.Int, res *string) error {
+func (this *ChordNode) innner_find_successor(ctx context.Context, aimID *big.Int, res *string) error {
+	start := this.config.Clock.Now()
+	var found FindSuccessorRes
+	tmp_err := this.find_successor(ctx, aimID, &found)
+	observe_lookup(found.Hops, this.config.Clock.Now().Sub(start), tmp_err != nil)
+	if tmp_err == nil {
+		*res = found.Address
+	}
+	return tmp_err
+}
+
+func (this *ChordNode) find_successor(ctx context.Context, aimID *big.Int, res *FindSuccessorRes) error {
+	if this.config.IterativeLookup {
+		return this.iterative_find_successor(ctx, aimID, res)
+	}
 	//use the first joined Node to find aimID's successor
 	var firstNode string
-	tmp_err := this.find_first_online_succ(&firstNode)
+	tmp_err := this.find_first_online_succ(ctx, &firstNode)
 	if tmp_err != nil {
 		log.Errorln("In function innner_find_successor can not get the first firstNode")
-		return tmp_err
+		return dht.RemoteError(ctx, tmp_err)
 	}
-	if inDur(aimID, this.ID, ConsistentHash(firstNode), true) {
-		*res = firstNode
+	if inDur(aimID, this.get_id(), NodeID(firstNode), true) {
+		*res = FindSuccessorRes{Address: firstNode}
 		return nil
 	}
-	firstPre := this.first_pre_node(aimID)
-	return RemoteCall(firstPre, "WrapNode.FindSuccessor", aimID, res)
+	firstPre := this.first_pre_node(ctx, aimID)
+	arg := FindSuccessorArg{ID: aimID}
+	arg.Deadline, _ = ctx.Deadline()
+	tmp_err = this.call_context(ctx, firstPre, "WrapNode.FindSuccessor", arg, res)
+	if tmp_err != nil {
+		return dht.RemoteError(ctx, tmp_err)
+	}
+	res.Hops++
+	return nil
 }
 
 func (this *ChordNode) get_successor_list(res *[successorListLength]string) error {
@@ -256,25 +487,35 @@ func (this *ChordNode) get_predecessor(res *string) error {
 	return nil
 }
 
-func (this *ChordNode) transfer_data(preNode string, data *map[string]string) error {
+func (this *ChordNode) transfer_data(preNode string, data *map[string]DataItem) error {
 	this.dataLock.Lock()
+	if this.leaving {
+		this.dataLock.Unlock()
+		return this.leaving_error()
+	}
 	this.backupLock.Lock()
-	this.backupSet = make(map[string]string)
-	for key, value := range this.dataSet {
-		if !inDur(ConsistentHash(key), ConsistentHash(preNode), this.ID, true) {
-			(*data)[key] = value
-			this.backupSet[key] = value
-			delete(this.dataSet, key)
+	//pairs not in (preNode, this] now belong to preNode
+	*data = this.dataSet.RangeByHash(this.get_id(), NodeID(preNode), true)
+	if this.config.Replicas == 2 {
+		//the only replica held here is the old predecessor's data, which moves to preNode
+		this.backupSet.Clear()
+	}
+	for key, item := range *data {
+		if this.config.Replicas > 1 {
+			this.backupSet.Put(key, item)
 		}
+		this.dataSet.Delete(key)
 	}
 	this.backupLock.Unlock()
 	this.dataLock.Unlock()
-	var succAddr string
-	this.find_first_online_succ(&succAddr)
-	var o string
-	tmp_err := RemoteCall(succAddr, "WrapNode.SubBackup", *data, &o)
-	if tmp_err != nil {
-		log.Errorln("In function transfer_data can not sub backup")
+	//this node becomes a replica of the pairs, so the last replica holder can drop them
+	replicas := this.replica_list()
+	if len(replicas) == this.config.Replicas-1 && len(replicas) > 0 {
+		var o string
+		tmp_err := this.call(replicas[len(replicas)-1], "WrapNode.SubBackup", *data, &o)
+		if tmp_err != nil {
+			log.Errorln("In function transfer_data can not sub backup")
+		}
 	}
 	this.rwLock.Lock()
 	this.predecessor = preNode
@@ -282,28 +523,99 @@ func (this *ChordNode) transfer_data(preNode string, data *map[string]string) er
 	return nil
 }
 
-func (this *ChordNode) find_first_online_succ(res *string) error {
+func (this *ChordNode) find_first_online_succ(ctx context.Context, res *string) error {
+	var succList [successorListLength]string
+	this.get_successor_list(&succList)
 	for i := 0; i < successorListLength; i++ {
-		flag := CheckOnline(this.successorList[i])
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		flag := this.online(ctx, succList[i])
 		if flag == true {
-			*res = this.successorList[i]
+			*res = succList[i]
 			return nil
 		}
 	}
-	res_error := errors.New("Can not find online successor")
+	res_error := fmt.Errorf("%w: can not find online successor", dht.ErrNoRoute)
 	return res_error
 }
 
-func (this *ChordNode) first_pre_node(aimID *big.Int) string {
+//the first Replicas-1 distinct online successors, which keep the replicas of dataSet
+func (this *ChordNode) replica_list() []string {
+	var res []string
+	if this.config.Replicas <= 1 {
+		return res
+	}
+	var succList [successorListLength]string
+	this.get_successor_list(&succList)
+	for i := 0; i < successorListLength && len(res) < this.config.Replicas-1; i++ {
+		if succList[i] == "" || succList[i] == this.get_address() {
+			continue
+		}
+		repeated := false
+		for _, addr := range res {
+			if addr == succList[i] {
+				repeated = true
+				break
+			}
+		}
+		if !repeated && this.online(context.Background(), succList[i]) {
+			res = append(res, succList[i])
+		}
+	}
+	return res
+}
+
+//push the whole dataSet to the successors which newly become replica holders
+func (this *ChordNode) replicate() {
+	replicas := this.replica_list()
+	this.rwLock.Lock()
+	oldReplicas := this.replicaList
+	this.replicaList = replicas
+	this.rwLock.Unlock()
+	var data map[string]DataItem
+	for _, addr := range replicas {
+		isOld := false
+		for _, oldAddr := range oldReplicas {
+			if oldAddr == addr {
+				isOld = true
+				break
+			}
+		}
+		if isOld {
+			continue
+		}
+		if data == nil {
+			this.dataLock.RLock()
+			data = storageCopy(this.dataSet)
+			this.dataLock.RUnlock()
+		}
+		var o string
+		tmp_err := this.call(addr, "WrapNode.AddBackup", data, &o)
+		if tmp_err != nil {
+			log.Errorln("In function replicate can not add backup in", addr)
+			//try again in the next stabilize
+			this.rwLock.Lock()
+			this.replicaList = nil
+			this.rwLock.Unlock()
+		}
+	}
+}
+
+func (this *ChordNode) first_pre_node(ctx context.Context, aimID *big.Int) string {
 	for i := fingerTableLength - 1; i >= 0; i-- {
-		if this.fingerTable[i] != "" && CheckOnline(this.fingerTable[i]) {
-			if inDur(ConsistentHash(this.fingerTable[i]), this.ID, aimID, false) {
-				return this.fingerTable[i]
+		//the fastest candidate of the farthest finger preceding aimID
+		for _, addr := range this.finger_candidates(i, aimID) {
+			if ctx.Err() != nil {
+				return ""
+			}
+			if this.online(ctx, addr) {
+				return addr
 			}
 		}
 	}
 	var res string
-	tmp_err := this.find_first_online_succ(&res)
+	tmp_err := this.find_first_online_succ(ctx, &res)
 	if tmp_err != nil {
 		log.Errorln("In function first_pre_node can not find successor")
 		return ""
@@ -312,11 +624,14 @@ func (this *ChordNode) first_pre_node(aimID *big.Int) string {
 }
 
 func (this *ChordNode) reset() {
+	//reopen the storages, a memory storage comes back empty
+	//while a disk storage comes back with what it had
 	this.dataLock.Lock()
-	this.dataSet = make(map[string]string)
+	this.dataSet = this.reopen_storage(this.dataSet, "data")
+	this.leaving = false
 	this.dataLock.Unlock()
 	this.backupLock.Lock()
-	this.backupSet = make(map[string]string)
+	this.backupSet = this.reopen_storage(this.backupSet, "backup")
 	this.backupLock.Unlock()
 	this.rwLock.Lock()
 	this.IsQuit = make(chan bool, 2)
@@ -324,106 +639,300 @@ func (this *ChordNode) reset() {
 	this.rwLock.Unlock()
 }
 
-func (this *ChordNode) sub_backup(data map[string]string) error {
+func (this *ChordNode) reopen_storage(old Storage, name string) Storage {
+	if old != nil {
+		tmp_err := old.Close()
+		if tmp_err != nil {
+			log.Errorln("In function reopen_storage close", name, "error", tmp_err)
+		}
+	}
+	dir := filepath.Join(this.config.DataDir, strings.Replace(this.get_address(), ":", "_", -1), name)
+	res, tmp_err := OpenStorage(this.config.Storage, dir)
+	if tmp_err != nil {
+		log.Errorln("In function reopen_storage can not open", dir, "use memory storage instead, because", tmp_err)
+		return newMemStorage()
+	}
+	return res
+}
+
+func (this *ChordNode) clear_storage() {
+	this.dataLock.Lock()
+	tmp_err := this.dataSet.Clear()
+	this.dataLock.Unlock()
+	if tmp_err != nil {
+		log.Errorln("In function clear_storage clear data error", tmp_err)
+	}
+	this.backupLock.Lock()
+	tmp_err = this.backupSet.Clear()
+	this.backupLock.Unlock()
+	if tmp_err != nil {
+		log.Errorln("In function clear_storage clear backup error", tmp_err)
+	}
+}
+
+func (this *ChordNode) sub_backup(data map[string]DataItem) error {
 	this.backupLock.Lock()
 	for key := range data {
-		delete(this.backupSet, key)
+		this.backupSet.Delete(key)
 	}
 	this.backupLock.Unlock()
 	return nil
 }
 
-func (this *ChordNode) add_backup(data map[string]string) error {
+func (this *ChordNode) add_backup(data map[string]DataItem) error {
 	this.backupLock.Lock()
-	for key, value := range data {
-		this.backupSet[key] = value
-	}
+	tmp_err := storagePutNewer(this.backupSet, data)
 	this.backupLock.Unlock()
+	return tmp_err
+}
+
+//merge pairs handed over by the successor into dataSet, a pair never replaces
+//a newer version, then mirror them to the replica holders
+func (this *ChordNode) add_data(data map[string]DataItem) error {
+	this.dataLock.Lock()
+	if this.leaving {
+		this.dataLock.Unlock()
+		return this.leaving_error()
+	}
+	for key, item := range data {
+		old, ok := this.dataSet.Get(key)
+		if ok && old.Version >= item.Version {
+			delete(data, key)
+			continue
+		}
+		tmp_err := this.dataSet.Put(key, item)
+		if tmp_err != nil {
+			this.dataLock.Unlock()
+			log.Errorln("In function add_data can not store pair", key, tmp_err)
+			return tmp_err
+		}
+	}
+	this.dataLock.Unlock()
+	for _, addr := range this.replica_list() {
+		var o string
+		tmp_err := this.call(addr, "WrapNode.AddBackup", data, &o)
+		if tmp_err != nil {
+			log.Warningln("In function add_data can not add backup in", addr)
+		}
+	}
 	return nil
 }
 
-func (this *ChordNode) set_backup(backup *map[string]string) error {
+func (this *ChordNode) set_backup(backup *map[string]DataItem) error {
 	this.dataLock.RLock()
-	*backup = make(map[string]string) //remember to clear the map
-	for key, value := range this.dataSet {
-		(*backup)[key] = value
-	}
+	*backup = storageCopy(this.dataSet)
 	this.dataLock.RUnlock()
 	return nil
 }
 
 func (this *ChordNode) change_predecessor() error {
-	if this.predecessor != "" && !CheckOnline(this.predecessor) {
-		this.rwLock.Lock()
-		this.predecessor = ""
-		this.rwLock.Unlock()
-		//then put backup into dataset
+	this.rwLock.RLock()
+	pred := this.predecessor
+	this.rwLock.RUnlock()
+	if pred != "" && !this.online(context.Background(), pred) {
+		//put backup into dataset before the predecessor is cleared, since notify drops the
+		//replicas in the range of the new predecessor from backupSet as soon as it is accepted.
+		//The pairs which are not in the range of this node go back to backupSet then
 		this.dataLock.Lock()
 		this.backupLock.RLock()
-		for key, value := range this.backupSet {
-			this.dataSet[key] = value
-		}
+		backup := storageCopy(this.backupSet)
+		storagePutAll(this.dataSet, backup)
 		this.dataLock.Unlock()
 		this.backupLock.RUnlock()
+		this.rwLock.Lock()
+		if this.predecessor == pred {
+			this.predecessor = ""
+		}
+		this.rwLock.Unlock()
 		//then add new back up
-		var succAddr string
-		tmp_err := this.find_first_online_succ(&succAddr)
-		if tmp_err != nil {
+		replicas := this.replica_list()
+		if this.config.Replicas > 1 && len(replicas) == 0 {
 			log.Errorln("In function change_predecessor can not find a succ")
-			return tmp_err
+			return fmt.Errorf("%w: can not find online successor", dht.ErrNoRoute)
+		}
+		for _, addr := range replicas {
+			var o string
+			tmp_err := this.call(addr, "WrapNode.AddBackup", backup, &o)
+			if tmp_err != nil {
+				log.Errorln("In function change_predecessor can not add backup in", addr)
+			}
 		}
-		var o string
-		tmp_err = RemoteCall(succAddr, "WrapNode.AddBackup", this.backupSet, &o)
-		this.backupLock.Lock()
-		this.backupSet = make(map[string]string)
-		this.backupLock.Unlock()
 	}
 	return nil
 }
 
+//drop the backups outside the ranges of the Replicas-1 predecessors, which are left
+//when this node is no longer a replica holder of a range or missed a Delete.
+//Without replicas, the pairs left by a notify which could not hand them over are
+//handed to their owners instead.
+func (this *ChordNode) prune_backup() {
+	this.rwLock.RLock()
+	pred := this.predecessor
+	this.rwLock.RUnlock()
+	if pred == "" || pred == this.get_address() {
+		return
+	}
+	if this.config.Replicas <= 1 {
+		this.backupLock.RLock()
+		left := storageCopy(this.backupSet)
+		this.backupLock.RUnlock()
+		if len(left) == 0 {
+			return
+		}
+		tmp_err := this.hand_to_owners(left, func(data map[string]DataItem) {
+			this.backupLock.Lock()
+			drop_handed(this.backupSet, data)
+			this.backupLock.Unlock()
+		})
+		if tmp_err != nil {
+			log.Warningln("In function prune_backup can not hand over the pairs left in", this.get_address(), tmp_err)
+		}
+		return
+	}
+	//the replicas kept here are in (low, pred], low is the Replicas-th predecessor,
+	//or this node if there are not so many other nodes
+	low := pred
+	for i := 1; i < this.config.Replicas; i++ {
+		var next string
+		tmp_err := this.call(low, "WrapNode.GetPredecessor", 0, &next)
+		if tmp_err != nil || next == "" {
+			//the predecessors are changing, keep everything until they are known
+			return
+		}
+		if next == this.get_address() || next == pred {
+			low = this.get_address()
+			break
+		}
+		low = next
+	}
+	this.backupLock.Lock()
+	defer this.backupLock.Unlock()
+	this.rwLock.RLock()
+	changed := this.predecessor != pred
+	this.rwLock.RUnlock()
+	//a new predecessor demotes its pairs to backupSet after this lock
+	if changed || low == pred {
+		return
+	}
+	for key := range this.backupSet.RangeByHash(NodeID(pred), NodeID(low), true) {
+		this.backupSet.Delete(key)
+	}
+}
+
 func (this *ChordNode) bgMaintain() {
 	//this func always run three functions below
 	//background maintain for finger_table & predecessor & stabilize
 	//first is stabilize
-	go func() {
-		for this.conRoutineFlag {
+	this.rwLock.Lock()
+	this.maintainGen++
+	gen := this.maintainGen
+	this.rwLock.Unlock()
+	running := func() bool {
+		this.rwLock.RLock()
+		defer this.rwLock.RUnlock()
+		return this.conRoutineFlag && this.maintainGen == gen
+	}
+	this.config.Clock.Go(func() {
+		for running() {
 			this.stabilize()
-			time.Sleep(timeCut)
+			this.count_maintenance("stabilize")
+			this.config.Clock.Sleep(timeCut)
 		}
-	}()
+	})
 
-	go func() {
-		for this.conRoutineFlag {
+	this.config.Clock.Go(func() {
+		for running() {
 			this.change_predecessor()
-			time.Sleep(timeCut)
+			this.count_maintenance("change_predecessor")
+			this.config.Clock.Sleep(timeCut)
 		}
-	}()
+	})
 
-	go func() {
-		for this.conRoutineFlag {
+	this.config.Clock.Go(func() {
+		for running() {
 			this.fix_fingerTable()
-			time.Sleep(timeCut)
+			this.count_maintenance("fix_fingerTable")
+			this.config.Clock.Sleep(timeCut)
+		}
+	})
+
+	this.config.Clock.Go(func() {
+		for running() {
+			this.config.Clock.Sleep(antiEntropyPeriod)
+			this.anti_entropy()
+			this.count_maintenance("anti_entropy")
+			this.prune_backup()
+			this.count_maintenance("prune_backup")
+		}
+	})
+
+	this.config.Clock.Go(func() {
+		for running() {
+			this.expire_data()
+			this.count_maintenance("expire_data")
+			this.config.Clock.Sleep(expireSweepPeriod)
+		}
+	})
+
+	if this.config.Balance {
+		this.config.Clock.Go(func() {
+			for running() {
+				this.config.Clock.Sleep(this.config.BalancePeriod)
+				if !running() {
+					return
+				}
+				tmp_err := this.balance()
+				if tmp_err != nil {
+					log.Warningln("In function balance", this.get_address(), tmp_err)
+				}
+				this.count_maintenance("balance")
+			}
+		})
+	}
+}
+
+//drop the expired pairs from dataSet and backupSet, the replicas expire
+//by themselves since they have the same Expire
+func (this *ChordNode) expire_data() {
+	now := this.config.Clock.Now()
+	this.dataLock.Lock()
+	expire_storage(this.dataSet, now)
+	this.dataLock.Unlock()
+	this.backupLock.Lock()
+	expire_storage(this.backupSet, now)
+	this.backupLock.Unlock()
+}
+
+func expire_storage(s Storage, now time.Time) {
+	var expiredList []string
+	s.Iterate(func(key string, item DataItem) bool {
+		if item.Expired(now) {
+			expiredList = append(expiredList, key)
 		}
-	}()
+		return true
+	})
+	for _, key := range expiredList {
+		s.Delete(key)
+	}
 }
 
 func (this *ChordNode) stabilize() error {
 	var succAddr string
 	var preAddr string
-	this.find_first_online_succ(&succAddr)
-	log.Infoln("In stabilize find first online succ : ", this.address, succAddr)
-	tmp_err := RemoteCall(succAddr, "WrapNode.GetPredecessor", 0, &preAddr)
+	this.find_first_online_succ(context.Background(), &succAddr)
+	log.Infoln("In stabilize find first online succ : ", this.get_address(), succAddr)
+	tmp_err := this.call(succAddr, "WrapNode.GetPredecessor", 0, &preAddr)
 	if tmp_err != nil {
 		log.Errorln("In stabilize get pre error")
 		return tmp_err
 	}
-	if preAddr != "" && inDur(ConsistentHash(preAddr), this.ID, ConsistentHash(succAddr), false) {
+	if preAddr != "" && inDur(NodeID(preAddr), this.get_id(), NodeID(succAddr), false) {
 		succAddr = preAddr
 	}
 	var tmpSuccList [successorListLength]string
-	tmp_err = RemoteCall(succAddr, "WrapNode.GetSuccessorList", 0, &tmpSuccList)
+	tmp_err = this.call(succAddr, "WrapNode.GetSuccessorList", 0, &tmpSuccList)
 	if tmp_err != nil {
-		log.Errorln("In stabilize GetSuccessorList error, because of : ", tmp_err, "this addr: ", this.address, "aimAddr : ", succAddr)
+		log.Errorln("In stabilize GetSuccessorList error, because of : ", tmp_err, "this addr: ", this.get_address(), "aimAddr : ", succAddr)
 		return tmp_err
 	}
 	this.rwLock.Lock()
@@ -434,23 +943,26 @@ func (this *ChordNode) stabilize() error {
 	}
 	this.rwLock.Unlock()
 	var o string
-	tmp_err = RemoteCall(succAddr, "WrapNode.Notify", this.address, &o)
+	tmp_err = this.call(succAddr, "WrapNode.Notify", this.get_address(), &o)
 	if tmp_err != nil {
 		log.Errorln("In func satbilize can not let succ notify")
 	}
+	this.replicate()
 	return nil
 }
 
 func (this *ChordNode) fix_fingerTable() {
 	//change one item for each run this function
 	var aimSucc string
-	tmp_err := this.innner_find_successor(getID(this.ID, this.next), &aimSucc)
+	tmp_err := this.innner_find_successor(context.Background(), getID(this.get_id(), this.next), &aimSucc)
 	if tmp_err != nil {
 		log.Errorln("In function fix_finger find successor error")
 		return
 	}
+	candidates := this.interval_candidates(this.next, aimSucc)
 	this.rwLock.Lock()
 	this.fingerTable[this.next] = aimSucc
+	this.fingerCands[this.next] = candidates[1:]
 	//change next
 	this.next = (this.next + 1) % fingerTableLength
 	if this.next == 0 {
@@ -460,15 +972,64 @@ func (this *ChordNode) fix_fingerTable() {
 }
 
 func (this *ChordNode) notify(preNode string) error {
-	if this.predecessor == "" || inDur(ConsistentHash(preNode), ConsistentHash(this.predecessor), this.ID, false) {
-		this.rwLock.Lock()
+	this.rwLock.Lock()
+	closer := this.predecessor == "" || inDur(NodeID(preNode), NodeID(this.predecessor), this.get_id(), false)
+	if closer {
 		this.predecessor = preNode
-		this.rwLock.Unlock()
-		tmp_err := RemoteCall(this.predecessor, "WrapNode.SetBackup", 0, &this.backupSet)
+	}
+	this.rwLock.Unlock()
+	if closer {
+		//pairs not in (preNode, this] belong to preNode, hand them to it and keep
+		//them as replicas only, and replicas in (preNode, this] are in dataSet already
+		preID := NodeID(preNode)
+		var demoted map[string]DataItem
+		this.dataLock.Lock()
+		this.backupLock.Lock()
+		if preNode != this.get_address() {
+			demoted = this.dataSet.RangeByHash(this.get_id(), preID, true)
+			for key, item := range demoted {
+				this.backupSet.Put(key, item)
+				this.dataSet.Delete(key)
+			}
+		}
+		for key := range this.backupSet.RangeByHash(preID, this.get_id(), true) {
+			this.backupSet.Delete(key)
+		}
+		this.backupLock.Unlock()
+		this.dataLock.Unlock()
+		if len(demoted) > 0 {
+			var o string
+			tmp_err := this.call(preNode, "WrapNode.AddData", demoted, &o)
+			if tmp_err != nil {
+				//they are still in backupSet, so do not clear it
+				log.Errorln("In function notify can not hand pairs to predecessor", preNode)
+				return tmp_err
+			}
+		}
+		if this.config.Replicas <= 1 {
+			//no replica is kept, the pairs were only kept until preNode has them
+			this.backupLock.Lock()
+			drop_handed(this.backupSet, demoted)
+			this.backupLock.Unlock()
+			return nil
+		}
+		var backup map[string]DataItem
+		tmp_err := this.call(preNode, "WrapNode.SetBackup", 0, &backup)
 		if tmp_err != nil {
 			log.Errorln("In function notify can not set backup data")
 			return tmp_err
 		}
+		this.backupLock.Lock()
+		if this.config.Replicas == 2 {
+			//only the predecessor's data is kept here
+			this.backupSet.Clear()
+		}
+		tmp_err = storagePutAll(this.backupSet, backup)
+		this.backupLock.Unlock()
+		if tmp_err != nil {
+			log.Errorln("In function notify can not store backup data", tmp_err)
+			return tmp_err
+		}
 	}
 	return nil
 }
@@ -476,65 +1037,136 @@ func (this *ChordNode) notify(preNode string) error {
 //func for hash table:
 func (this *ChordNode) insert_pair_inData(p KeyValuePair) error {
 	this.dataLock.Lock()
-	this.dataSet[p.Key] = p.Value
+	if this.leaving {
+		this.dataLock.Unlock()
+		return this.leaving_error()
+	}
+	old := this.live_item(p.Key)
+	p.Version = this.next_version(old.Version)
+	p.Expire = time.Time{}
+	if p.TTL > 0 {
+		p.Expire = this.config.Clock.Now().Add(p.TTL)
+	}
+	tmp_err := this.dataSet.Put(p.Key, DataItem{p.Value, p.Version, p.Expire})
 	this.dataLock.Unlock()
-	var succAddr string
-	tmp_err := this.find_first_online_succ(&succAddr)
 	if tmp_err != nil {
-		log.Warningln("Can not find a succ", p)
+		log.Errorln("In function insert_pair_inData can not store pair", p, tmp_err)
+		return tmp_err
 	}
-	if succAddr != "" {
+	this.mirror_pair(p)
+	return nil
+}
+
+//the item of key in dataSet, an expired item is the same as a missing one
+//need hold dataLock
+func (this *ChordNode) live_item(key string) DataItem {
+	item, ok := this.dataSet.Get(key)
+	if !ok || item.Expired(this.config.Clock.Now()) {
+		return DataItem{}
+	}
+	return item
+}
+
+//the version of a key after old, which is not before the clock either, so a key
+//stored again after a Delete does not take a version a CompareAndSwap may still expect
+func (this *ChordNode) next_version(old uint64) uint64 {
+	res := uint64(this.config.Clock.Now().UnixNano())
+	if res <= old {
+		res = old + 1
+	}
+	return res
+}
+
+func (this *ChordNode) compare_and_swap(arg CompareAndSwapArg, res *CompareAndSwapRes) error {
+	this.dataLock.Lock()
+	if this.leaving {
+		this.dataLock.Unlock()
+		return this.leaving_error()
+	}
+	old := this.live_item(arg.Key)
+	if old.Version != arg.Expected {
+		this.dataLock.Unlock()
+		*res = CompareAndSwapRes{Version: old.Version, Mismatch: true}
+		return nil
+	}
+	//the pair keeps the expiry of the value it replaces
+	p := KeyValuePair{Key: arg.Key, Value: arg.Value, Version: this.next_version(old.Version), Expire: old.Expire}
+	tmp_err := this.dataSet.Put(p.Key, DataItem{p.Value, p.Version, p.Expire})
+	this.dataLock.Unlock()
+	if tmp_err != nil {
+		log.Errorln("In function compare_and_swap can not store pair", p, tmp_err)
+		return tmp_err
+	}
+	*res = CompareAndSwapRes{Version: p.Version}
+	this.mirror_pair(p)
+	return nil
+}
+
+//put the pair with its version into the backupSet of every replica holder
+func (this *ChordNode) mirror_pair(p KeyValuePair) {
+	replicas := this.replica_list()
+	if len(replicas) < this.config.Replicas-1 {
+		log.Warningln("Can not find enough succ for replicas", p, replicas)
+	}
+	for _, addr := range replicas {
 		var o string
-		tmp_err = RemoteCall(succAddr, "WrapNode.InsertPairInBackup", p, &o)
+		tmp_err := this.call(addr, "WrapNode.InsertPairInBackup", p, &o)
 		if tmp_err != nil {
-			log.Warningln("Can not success store pair in backup", p)
+			log.Warningln("Can not success store pair in backup", p, addr)
 		}
 	}
-	return nil
 }
 
+//a mirror which arrives after a newer one is dropped
 func (this *ChordNode) insert_pair_inBackup(p KeyValuePair) error {
 	this.backupLock.Lock()
-	this.backupSet[p.Key] = p.Value
+	tmp_err := storagePutNewer(this.backupSet, map[string]DataItem{p.Key: {p.Value, p.Version, p.Expire}})
 	this.backupLock.Unlock()
-	return nil
+	return tmp_err
 }
 
 func (this *ChordNode) get_value(key string, res *string) error {
+	var item DataItem
+	tmp_err := this.get_item(key, &item)
+	*res = item.Value
+	return tmp_err
+}
+
+func (this *ChordNode) get_item(key string, res *DataItem) error {
 	this.dataLock.RLock()
-	value, flag := this.dataSet[key]
+	item, flag := this.dataSet.Get(key)
 	this.dataLock.RUnlock()
-	if flag {
-		*res = value
+	if flag && !item.Expired(this.config.Clock.Now()) {
+		*res = item
 		return nil
 	} else {
-		*res = ""
-		return errors.New("Nil value")
+		*res = DataItem{}
+		return dht.ErrNotFound
 	}
 }
 
 func (this *ChordNode) erase_pair_inData(key string) error {
 	this.dataLock.Lock()
-	_, ok := this.dataSet[key]
-	if ok {
-		delete(this.dataSet, key)
+	if this.leaving {
+		this.dataLock.Unlock()
+		return this.leaving_error()
 	}
+	ok, tmp_err := this.dataSet.Delete(key)
 	this.dataLock.Unlock()
+	if tmp_err != nil {
+		log.Errorln("In erase_pair_inData can not delete", key, tmp_err)
+		return tmp_err
+	}
 	if !ok {
 		//delete error
 		log.Errorln("In erase_pair_inData delete not exit", key)
-		return errors.New("Delete failed!")
+		return dht.ErrNotFound
 	} else {
-		var succAddr string
-		tmp_err := this.innner_find_successor(ConsistentHash(key), &succAddr)
-		if tmp_err != nil {
-			log.Warningln("In erase_pair_inData delete pair in backup error")
-		}
-		if succAddr != "" && succAddr != this.address {
+		for _, addr := range this.replica_list() {
 			var o string
-			tmp_err = RemoteCall(succAddr, "WrapNode.ErasePairInBackup", key, &o)
+			tmp_err = this.call(addr, "WrapNode.ErasePairInBackup", key, &o)
 			if tmp_err != nil {
-				log.Warningln("Can not delete pair in backup")
+				log.Warningln("Can not delete pair in backup", addr)
 			}
 		}
 		return nil
@@ -543,14 +1175,14 @@ func (this *ChordNode) erase_pair_inData(key string) error {
 
 func (this *ChordNode) erase_pair_inBackup(key string) error {
 	this.backupLock.Lock()
-	_, ok := this.backupSet[key]
-	if ok {
-		delete(this.backupSet, key)
-	}
+	ok, tmp_err := this.backupSet.Delete(key)
 	this.backupLock.Unlock()
+	if tmp_err != nil {
+		return tmp_err
+	}
 	if ok {
 		return nil
 	} else {
-		return errors.New("Not found key backup")
+		return dht.ErrNotFound
 	}
 }
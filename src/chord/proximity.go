@@ -0,0 +1,112 @@
+package chord
+
+import (
+	"math/big"
+	"rpcpool"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Proximity neighbour selection: any node in [ID + 2^i, ID + 2^(i+1)) can be finger i
+//without making a lookup take more hops, so fix_fingerTable keeps the exact successor
+//of ID + 2^i and the nodes following it in that interval as candidates, and
+//first_pre_node goes to the candidate with the lowest round-trip time.
+
+//candidates kept for a finger, the exact successor included
+const fingerCandidates = 4
+
+//weight of a new sample in the smoothed round-trip time, as TCP does
+const rttWeight = 0.125
+
+//smoothed round-trip times of the calls of each caller by network address, since the
+//virtual nodes of a station share their connections
+var rtts = make(map[*rpcpool.Caller]map[string]time.Duration)
+var rttLock sync.RWMutex
+
+//observe_rtt records a successful call to netAddr which took d
+func (this caller) observe_rtt(netAddr string, d time.Duration) {
+	rttLock.Lock()
+	defer rttLock.Unlock()
+	times, ok := rtts[this.Caller]
+	if !ok {
+		times = make(map[string]time.Duration)
+		rtts[this.Caller] = times
+	}
+	old, ok := times[netAddr]
+	if !ok {
+		times[netAddr] = d
+		return
+	}
+	times[netAddr] = old + time.Duration(rttWeight*float64(d-old))
+}
+
+func (this caller) rtt(addr string) (time.Duration, bool) {
+	netAddr, _ := splitAddress(addr)
+	rttLock.RLock()
+	defer rttLock.RUnlock()
+	res, ok := rtts[this.Caller][netAddr]
+	return res, ok
+}
+
+//RTT is the smoothed round-trip time of the calls of this node to the station of
+//the (virtual) node at addr, false if no call to it has succeeded yet. The nodes
+//sharing the settings of their calls share the times.
+func (this *ChordNode) RTT(addr string) (time.Duration, bool) {
+	return this.caller.rtt(addr)
+}
+
+//the exact successor of the start of finger i, and the nodes following it in the interval of finger i
+func (this *ChordNode) interval_candidates(i int, exact string) []string {
+	res := []string{exact}
+	//the interval of the last finger ends at ID itself
+	end := getID(this.get_id(), i+1)
+	if exact == this.get_address() || !inDur(NodeID(exact), this.get_id(), end, false) {
+		return res
+	}
+	var succList [successorListLength]string
+	tmp_err := this.call(exact, "WrapNode.GetSuccessorList", 0, &succList)
+	if tmp_err != nil {
+		return res
+	}
+	last := NodeID(exact)
+	for _, addr := range succList {
+		if len(res) == fingerCandidates {
+			break
+		}
+		//stop at the end of the interval, or when the list wraps around
+		if addr == "" || addr == this.get_address() || !inDur(NodeID(addr), last, end, false) {
+			break
+		}
+		res = append(res, addr)
+		last = NodeID(addr)
+	}
+	return res
+}
+
+//the candidates of finger i which precede aimID, the fastest first,
+//a candidate never called comes after the others
+func (this *ChordNode) finger_candidates(i int, aimID *big.Int) []string {
+	this.rwLock.RLock()
+	all := append([]string{this.fingerTable[i]}, this.fingerCands[i]...)
+	this.rwLock.RUnlock()
+	var res []string
+	seen := make(map[string]bool)
+	for _, addr := range all {
+		if addr == "" || seen[addr] || !inDur(NodeID(addr), this.get_id(), aimID, false) {
+			continue
+		}
+		seen[addr] = true
+		res = append(res, addr)
+	}
+	//stable, so the exact successor stays first among the unknown ones
+	sort.SliceStable(res, func(a, b int) bool {
+		rttA, okA := this.RTT(res[a])
+		rttB, okB := this.RTT(res[b])
+		if okA != okB {
+			return okA
+		}
+		return okA && rttA < rttB
+	})
+	return res
+}
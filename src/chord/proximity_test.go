@@ -0,0 +1,166 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"fmt"
+	"math/big"
+	"net"
+	"sim"
+	"sort"
+	"testing"
+	"time"
+)
+
+//slowNetwork delays every write to the station at slow on the clock of a simulator
+type slowNetwork struct {
+	*dht.FaultNetwork
+	clock *sim.Simulator
+	slow  string
+	delay time.Duration
+}
+
+type slowConn struct {
+	net.Conn
+	network *slowNetwork
+}
+
+func (this *slowNetwork) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return this.DialFrom("", address, timeout)
+}
+
+func (this *slowNetwork) DialFrom(from string, address string, timeout time.Duration) (net.Conn, error) {
+	conn, tmp_err := this.FaultNetwork.DialFrom(from, address, timeout)
+	if tmp_err != nil || address != this.slow {
+		return conn, tmp_err
+	}
+	return &slowConn{conn, this}, nil
+}
+
+func (this *slowConn) Write(b []byte) (int, error) {
+	this.network.clock.Sleep(this.network.delay)
+	return this.Conn.Write(b)
+}
+
+//two_in_interval finds the farthest finger of the node at addr whose interval holds two nodes
+//of addrs, which are sorted by identifier, and returns the two nodes nearest to its start
+func two_in_interval(addr string, addrs []string) (string, string) {
+	mod := new(big.Int).Lsh(big.NewInt(1), 160)
+	for i := 159; i >= 0; i-- {
+		start := new(big.Int).Add(chord.NodeID(addr), new(big.Int).Lsh(big.NewInt(1), uint(i)))
+		start.Mod(start, mod)
+		end := new(big.Int).Add(chord.NodeID(addr), new(big.Int).Lsh(big.NewInt(1), uint(i+1)))
+		end.Mod(end, mod)
+		//[start, end) is (start-1, end-1]
+		low := new(big.Int).Mod(new(big.Int).Sub(start, big.NewInt(1)), mod)
+		high := new(big.Int).Mod(new(big.Int).Sub(end, big.NewInt(1)), mod)
+		var inside []string
+		for _, other := range addrs {
+			if other != addr && in_range(chord.NodeID(other), low, high) {
+				inside = append(inside, other)
+			}
+		}
+		if len(inside) < 2 {
+			continue
+		}
+		sort.Slice(inside, func(a, b int) bool {
+			distA := new(big.Int).Mod(new(big.Int).Sub(chord.NodeID(inside[a]), start), mod)
+			distB := new(big.Int).Mod(new(big.Int).Sub(chord.NodeID(inside[b]), start), mod)
+			return distA.Cmp(distB) < 0
+		})
+		return inside[0], inside[1]
+	}
+	return "", ""
+}
+
+func TestProximityFingers(t *testing.T) {
+	const size = 16
+	const delay = 50 * time.Millisecond
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addrs = append(addrs, dht.JoinAddress("proximity", 22500+i))
+	}
+	sorted := append([]string(nil), addrs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return chord.NodeID(sorted[i]).Cmp(chord.NodeID(sorted[j])) < 0
+	})
+	successor := make(map[string]string)
+	for i, addr := range sorted {
+		successor[addr] = sorted[(i+1)%size]
+	}
+	//a querier whose farthest finger with two candidates does not start at its successor,
+	//so only the lookup can meet the slow node
+	querier, exact, other := -1, "", ""
+	for i := 0; i < size && querier < 0; i++ {
+		exact, other = two_in_interval(addrs[i], sorted)
+		if exact != "" && successor[addrs[i]] != exact {
+			querier = i
+		}
+	}
+	if querier < 0 {
+		t.Fatalf("no node of %v has a finger interval with two nodes", addrs)
+	}
+	key := ""
+	for i := 0; key == ""; i++ {
+		if in_range(chord.ConsistentHash(fmt.Sprint("key", i)), chord.NodeID(other), chord.NodeID(successor[other])) {
+			key = fmt.Sprint("key", i)
+		}
+	}
+
+	s := sim.New(1)
+	conf := memory_config()
+	conf.AdvertiseAddress = "proximity"
+	conf.Transport = &slowNetwork{FaultNetwork: s.Network(), clock: s, slow: exact, delay: delay}
+	conf.Clock = s
+	var joinErr, putErr, getErr error
+	var value string
+	var took, slowRTT, fastRTT time.Duration
+	var slowKnown, fastKnown bool
+	s.Run(func() {
+		var ring []*chord.ChordNode
+		for i := 0; i < size; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(22500+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(addrs[0]); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		//until fix_fingerTable starts over twice, so it has gone over all the
+		//fingers of the querier since the ring is complete
+		for last, wraps, waited := 0, 0, 0; wraps < 2 && waited < 600; waited++ {
+			s.Sleep(time.Second)
+			next := ring[querier].DebugState().Next
+			if next < last {
+				wraps++
+			}
+			last = next
+		}
+		putErr = ring[(querier+1)%size].Put(key, "value")
+		slowRTT, slowKnown = ring[querier].RTT(exact)
+		fastRTT, fastKnown = ring[querier].RTT(other)
+		start := s.Now()
+		value, getErr = ring[querier].Get(key)
+		took = s.Now().Sub(start)
+		for _, node := range ring {
+			node.ForceQuit()
+		}
+	})
+	if joinErr != nil || putErr != nil {
+		t.Fatalf("join: %v, put: %v", joinErr, putErr)
+	}
+	if !slowKnown || !fastKnown || slowRTT < delay || fastRTT >= delay {
+		t.Fatalf("RTT of the slow %s = %v, %v and of %s = %v, %v", exact, slowRTT, slowKnown, other, fastRTT, fastKnown)
+	}
+	if getErr != nil || value != "value" {
+		t.Fatalf("get %s = %q, %v", key, value, getErr)
+	}
+	//the lookup goes to the faster candidate of the finger
+	if took >= delay {
+		t.Errorf("get %s from %s took %v, it went through the slow %s rather than %s", key, addrs[querier], took, exact, other)
+	}
+}
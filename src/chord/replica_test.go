@@ -0,0 +1,97 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+//time for a round of anti-entropy and pruning
+const pruneWait = 5 * time.Second
+
+//in (l, r] on the ring
+func in_range(id *big.Int, l *big.Int, r *big.Int) bool {
+	if l.Cmp(r) < 0 {
+		return id.Cmp(l) > 0 && id.Cmp(r) <= 0
+	}
+	return id.Cmp(l) > 0 || id.Cmp(r) <= 0
+}
+
+//check_backups checks that every node only keeps the backups of the ranges of
+//its replicas-1 predecessors, the ring being the nodes at the ports
+func check_backups(t *testing.T, conf chord.Config, ports []int, replicas int) {
+	t.Helper()
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	var addrs []string
+	for _, port := range ports {
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, port))
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	for i, addr := range addrs {
+		var stored chord.StoredKeys
+		tmp_err := chord.RemoteCall(addr, "WrapNode.StoredKeys", 0, &stored)
+		if tmp_err != nil {
+			t.Fatalf("stored keys of %s: %v", addr, tmp_err)
+		}
+		pred := addrs[(i+len(addrs)-1)%len(addrs)]
+		low := addrs[(i+len(addrs)-replicas)%len(addrs)]
+		for _, key := range stored.Backup {
+			if replicas <= 1 || !in_range(chord.ConsistentHash(key), chord.NodeID(low), chord.NodeID(pred)) {
+				t.Errorf("%s keeps a backup of %s which is not in the range of its %d predecessors", addr, key, replicas-1)
+			}
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	for _, replicas := range []int{1, 3} {
+		t.Run(fmt.Sprint("Replicas", replicas), func(t *testing.T) {
+			conf := memory_config()
+			conf.Replicas = replicas
+			ring := start_ring(t, conf, 21200, 5)
+			for i := 0; i < 100; i++ {
+				tmp_err := ring[i%len(ring)].Put(fmt.Sprint("key", i), "value")
+				if tmp_err != nil {
+					t.Fatalf("put key%d: %v", i, tmp_err)
+				}
+			}
+			//the new nodes take over ranges, so some nodes stop holding their replicas
+			var ports []int
+			for i := 0; i < 8; i++ {
+				ports = append(ports, 21200+i)
+			}
+			for _, port := range ports[5:] {
+				node := new(chord.ChordNode)
+				node.InitWithConfig(port, conf)
+				node.Run()
+				tmp_err := node.Join(dht.JoinAddress(conf.AdvertiseAddress, 21200))
+				if tmp_err != nil {
+					t.Fatalf("join %d: %v", port, tmp_err)
+				}
+				defer node.Quit()
+			}
+			time.Sleep(settleWait)
+			for i := 0; i < 100; i += 2 {
+				tmp_err := ring[0].Delete(fmt.Sprint("key", i))
+				if tmp_err != nil {
+					t.Fatalf("delete key%d: %v", i, tmp_err)
+				}
+			}
+			time.Sleep(pruneWait)
+			check_backups(t, conf, ports, replicas)
+			for i := 1; i < 100; i += 2 {
+				value, tmp_err := ring[0].Get(fmt.Sprint("key", i))
+				if tmp_err != nil || value != "value" {
+					t.Errorf("key%d = %q, %v", i, value, tmp_err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package chord
+
+import (
+	"context"
+	"dht"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+//ScanItem is a key found by Scan, items are in ring order, that is
+//ordered by Hash from 0 to 2^160-1 and then by Key.
+type ScanItem struct {
+	Key  string
+	Hash *big.Int
+}
+
+type ScanArg struct {
+	//return the items after (After, AfterKey), all items if Start is true
+	Start    bool
+	After    *big.Int
+	AfterKey string
+	Limit    int
+}
+
+type ScanPage struct {
+	Items []ScanItem
+	//the last hash covered by this page if it is not truncated by Limit
+	Upto *big.Int
+	//the node where the scan goes on
+	Successor string
+}
+
+//return the page of dataSet after the cursor, up to this node's ID or to the end of
+//the ring when the cursor is already after this node's ID
+func (this *ChordNode) scan_data(arg ScanArg, res *ScanPage) error {
+	res.Upto = new(big.Int).Sub(mod, big.NewInt(1))
+	if arg.Start || arg.After.Cmp(this.get_id()) < 0 {
+		res.Upto = new(big.Int).Set(this.get_id())
+	}
+	var items []ScanItem
+	this.dataLock.RLock()
+	now := this.config.Clock.Now()
+	this.dataSet.Iterate(func(key string, item DataItem) bool {
+		if item.Expired(now) {
+			return true
+		}
+		hash := ConsistentHash(key)
+		if hash.Cmp(res.Upto) > 0 {
+			return true
+		}
+		if !arg.Start && scanLess(hash, key, arg.After, arg.AfterKey) {
+			return true
+		}
+		if !arg.Start && hash.Cmp(arg.After) == 0 && key == arg.AfterKey {
+			return true
+		}
+		items = append(items, ScanItem{key, hash})
+		return true
+	})
+	this.dataLock.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		return scanLess(items[i].Hash, items[i].Key, items[j].Hash, items[j].Key)
+	})
+	if arg.Limit > 0 && len(items) > arg.Limit {
+		items = items[:arg.Limit]
+		res.Upto = nil
+	}
+	res.Items = items
+	return this.find_first_online_succ(context.Background(), &res.Successor)
+}
+
+func scanLess(hashA *big.Int, keyA string, hashB *big.Int, keyB string) bool {
+	cmp := hashA.Cmp(hashB)
+	return cmp < 0 || (cmp == 0 && keyA < keyB)
+}
+
+func encodeCursor(item ScanItem) string {
+	return fmt.Sprintf("%x/%s", item.Hash, item.Key)
+}
+
+func decodeCursor(cursor string) (*big.Int, string, error) {
+	pos := strings.Index(cursor, "/")
+	if pos < 0 {
+		return nil, "", errors.New("Invalid scan cursor")
+	}
+	hash, ok := new(big.Int).SetString(cursor[:pos], 16)
+	if !ok {
+		return nil, "", errors.New("Invalid scan cursor")
+	}
+	return hash, cursor[pos+1:], nil
+}
+
+//Scan returns at most limit keys in ring order after cursor, starting from the
+//beginning of the ring when cursor is "". The returned cursor is used to get
+//the next page, it is "" once the whole ring is scanned.
+func (this *ChordNode) Scan(cursor string, limit int) ([]ScanItem, string, error) {
+	return this.ScanContext(context.Background(), cursor, limit)
+}
+
+func (this *ChordNode) ScanContext(ctx context.Context, cursor string, limit int) ([]ScanItem, string, error) {
+	if !this.is_running() {
+		return nil, "", dht.ErrNotJoined
+	}
+	if limit <= 0 {
+		return nil, "", errors.New("Scan limit should be positive")
+	}
+	arg := ScanArg{Start: true, After: big.NewInt(0)}
+	if cursor != "" {
+		after, afterKey, tmp_err := decodeCursor(cursor)
+		if tmp_err != nil {
+			return nil, "", tmp_err
+		}
+		arg = ScanArg{Start: false, After: after, AfterKey: afterKey}
+	}
+	var aimAddr string
+	tmp_err := this.innner_find_successor(ctx, arg.After, &aimAddr)
+	if tmp_err != nil {
+		return nil, "", tmp_err
+	}
+	var res []ScanItem
+	//the first node of the ring is visited twice, for the start of the ring and for the
+	//keys after the last node, a third visit means the ring changed during the scan
+	visited := make(map[string]int)
+	for visited[aimAddr] < 2 {
+		visited[aimAddr]++
+		arg.Limit = limit - len(res)
+		var page ScanPage
+		tmp_err = this.call_context(ctx, aimAddr, "WrapNode.ScanData", arg, &page)
+		if tmp_err != nil {
+			return res, "", dht.RemoteError(ctx, tmp_err)
+		}
+		res = append(res, page.Items...)
+		if page.Upto == nil || len(res) == limit {
+			return res, encodeCursor(res[len(res)-1]), nil
+		}
+		if page.Upto.Cmp(new(big.Int).Sub(mod, big.NewInt(1))) == 0 {
+			break
+		}
+		//go on with the successor after the range of this node
+		arg = ScanArg{Start: false, After: page.Upto, AfterKey: "\xff"}
+		aimAddr = page.Successor
+	}
+	return res, "", nil
+}
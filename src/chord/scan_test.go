@@ -0,0 +1,77 @@
+package chord_test
+
+import (
+	"chord"
+	"fmt"
+	"testing"
+)
+
+//scan_all pages through the whole ring with limit
+func scan_all(t *testing.T, node *chord.ChordNode, limit int) []chord.ScanItem {
+	t.Helper()
+	var res []chord.ScanItem
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 1000 {
+			t.Fatalf("the scan does not end, at %q", cursor)
+		}
+		items, next, tmp_err := node.Scan(cursor, limit)
+		if tmp_err != nil {
+			t.Fatalf("scan after %q: %v", cursor, tmp_err)
+		}
+		if len(items) > limit {
+			t.Fatalf("a page of %d items, the limit is %d", len(items), limit)
+		}
+		res = append(res, items...)
+		if next == "" {
+			return res
+		}
+		cursor = next
+	}
+}
+
+//check_scan checks the items are the keys key0 to key(count-1) in ring order
+func check_scan(t *testing.T, items []chord.ScanItem, count int) {
+	t.Helper()
+	seen := make(map[string]bool)
+	for i, item := range items {
+		if seen[item.Key] {
+			t.Errorf("%s is scanned twice", item.Key)
+		}
+		seen[item.Key] = true
+		if item.Hash.Cmp(chord.ConsistentHash(item.Key)) != 0 {
+			t.Errorf("%s has the hash %x", item.Key, item.Hash)
+		}
+		if i > 0 && item.Hash.Cmp(items[i-1].Hash) < 0 {
+			t.Errorf("%s comes after %s, which is later in the ring", item.Key, items[i-1].Key)
+		}
+	}
+	for i := 0; i < count; i++ {
+		if !seen[fmt.Sprint("key", i)] {
+			t.Errorf("key%d is not scanned", i)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	const count = 200
+	for _, size := range []int{1, 5} {
+		t.Run(fmt.Sprint(size, "Nodes"), func(t *testing.T) {
+			ring := start_ring(t, memory_config(), 21500, size)
+			for i := 0; i < count; i++ {
+				tmp_err := ring[i%size].Put(fmt.Sprint("key", i), "value")
+				if tmp_err != nil {
+					t.Fatalf("put key%d: %v", i, tmp_err)
+				}
+			}
+			//the keys after the last node are kept by the first one
+			for _, limit := range []int{7, count, 2 * count} {
+				items := scan_all(t, ring[size-1], limit)
+				if len(items) != count {
+					t.Errorf("limit %d: %d items, want %d", limit, len(items), count)
+				}
+				check_scan(t, items, count)
+			}
+		})
+	}
+}
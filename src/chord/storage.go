@@ -0,0 +1,414 @@
+package chord
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StorageType int
+
+const (
+	MemoryStorage StorageType = iota
+	DiskStorage
+)
+
+//the disk log is rewritten into a snapshot once it has more records than this
+const compactThreshold int = 1024
+
+//DataItem is a stored value. Every write of the key by its owner sets Version to the
+//clock of the owner in nanoseconds, or to one above the old version when the clock is
+//behind it, so versions grow and a key which is not stored has version 0.
+type DataItem struct {
+	Value   string
+	Version uint64
+	//the item is dropped after Expire, zero means it never expires
+	Expire time.Time
+}
+
+func (this DataItem) Expired(now time.Time) bool {
+	return !this.Expire.IsZero() && now.After(this.Expire)
+}
+
+//Storage is where a ChordNode keeps its data pairs and backup pairs.
+type Storage interface {
+	Get(key string) (DataItem, bool)
+	Put(key string, item DataItem) error
+	Delete(key string) (bool, error)
+	//Iterate calls fn for every pair until fn returns false
+	Iterate(fn func(key string, item DataItem) bool)
+	//RangeByHash returns the pairs whose key hash is in (l, r) or (l, r]
+	RangeByHash(l, r *big.Int, isClose bool) map[string]DataItem
+	Size() int
+	Clear() error
+	Close() error
+}
+
+func OpenStorage(kind StorageType, dir string) (Storage, error) {
+	switch kind {
+	case MemoryStorage:
+		return newMemStorage(), nil
+	case DiskStorage:
+		return newDiskStorage(dir)
+	}
+	return nil, errors.New("Unknown storage type")
+}
+
+//copy all pairs of a storage into a map
+func storageCopy(s Storage) map[string]DataItem {
+	res := make(map[string]DataItem)
+	s.Iterate(func(key string, item DataItem) bool {
+		res[key] = item
+		return true
+	})
+	return res
+}
+
+//put all pairs of a map into a storage
+func storagePutAll(s Storage, data map[string]DataItem) error {
+	for key, item := range data {
+		tmp_err := s.Put(key, item)
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	return nil
+}
+
+//put the pairs of a map which are not older than the stored ones into a storage
+func storagePutNewer(s Storage, data map[string]DataItem) error {
+	for key, item := range data {
+		old, ok := s.Get(key)
+		if ok && old.Version > item.Version {
+			continue
+		}
+		tmp_err := s.Put(key, item)
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	return nil
+}
+
+func rangeByHash(data map[string]DataItem, l, r *big.Int, isClose bool) map[string]DataItem {
+	res := make(map[string]DataItem)
+	for key, item := range data {
+		if inDur(ConsistentHash(key), l, r, isClose) {
+			res[key] = item
+		}
+	}
+	return res
+}
+
+//memStorage keeps everything in a map, it is lost when the node quits.
+type memStorage struct {
+	data map[string]DataItem
+	lock sync.RWMutex
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{data: make(map[string]DataItem)}
+}
+
+func (this *memStorage) Get(key string) (DataItem, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	item, ok := this.data[key]
+	return item, ok
+}
+
+func (this *memStorage) Put(key string, item DataItem) error {
+	this.lock.Lock()
+	this.data[key] = item
+	this.lock.Unlock()
+	return nil
+}
+
+func (this *memStorage) Delete(key string) (bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, ok := this.data[key]
+	if ok {
+		delete(this.data, key)
+	}
+	return ok, nil
+}
+
+func (this *memStorage) Iterate(fn func(key string, item DataItem) bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for key, item := range this.data {
+		if !fn(key, item) {
+			return
+		}
+	}
+}
+
+func (this *memStorage) RangeByHash(l, r *big.Int, isClose bool) map[string]DataItem {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return rangeByHash(this.data, l, r, isClose)
+}
+
+func (this *memStorage) Size() int {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.data)
+}
+
+func (this *memStorage) Clear() error {
+	this.lock.Lock()
+	this.data = make(map[string]DataItem)
+	this.lock.Unlock()
+	return nil
+}
+
+func (this *memStorage) Close() error {
+	return nil
+}
+
+//diskStorage keeps a map in memory, every change is appended to a log file
+//and the log is compacted into a snapshot file from time to time.
+//The map is rebuilt from snapshot + log when the storage is opened again.
+//A change is synced to the disk before it returns, so it outlives a crash of the machine.
+type diskStorage struct {
+	dir      string
+	data     map[string]DataItem
+	logFile  *os.File
+	logCount int
+	lock     sync.RWMutex
+}
+
+type logRecord struct {
+	Op      string
+	Key     string
+	Value   string     `json:",omitempty"`
+	Version uint64     `json:",omitempty"`
+	Expire  *time.Time `json:",omitempty"`
+}
+
+func newDiskStorage(dir string) (*diskStorage, error) {
+	tmp_err := os.MkdirAll(dir, 0755)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	res := &diskStorage{dir: dir, data: make(map[string]DataItem)}
+	tmp_err = res.load()
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	res.logFile, tmp_err = os.OpenFile(res.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	return res, nil
+}
+
+func (this *diskStorage) snapshotPath() string {
+	return filepath.Join(this.dir, "snapshot.json")
+}
+
+func (this *diskStorage) logPath() string {
+	return filepath.Join(this.dir, "append.log")
+}
+
+func (this *diskStorage) load() error {
+	snapshot, tmp_err := ioutil.ReadFile(this.snapshotPath())
+	if tmp_err == nil {
+		tmp_err = json.Unmarshal(snapshot, &this.data)
+		if tmp_err != nil {
+			return tmp_err
+		}
+	} else if !os.IsNotExist(tmp_err) {
+		return tmp_err
+	}
+	file, tmp_err := os.OpenFile(this.logPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var valid int64 = 0
+	for {
+		line, read_err := reader.ReadString('\n')
+		if read_err == io.EOF {
+			//the last line may be a torn write
+			break
+		}
+		if read_err != nil {
+			return read_err
+		}
+		var record logRecord
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), &record) != nil {
+			break
+		}
+		this.apply(record)
+		this.logCount++
+		valid += int64(len(line))
+	}
+	//drop everything after the last complete record
+	return file.Truncate(valid)
+}
+
+func (this *diskStorage) apply(record logRecord) {
+	switch record.Op {
+	case "put":
+		item := DataItem{Value: record.Value, Version: record.Version}
+		if record.Expire != nil {
+			item.Expire = *record.Expire
+		}
+		this.data[record.Key] = item
+	case "del":
+		delete(this.data, record.Key)
+	}
+}
+
+//need hold the write lock
+func (this *diskStorage) append(record logRecord) error {
+	if this.logFile == nil {
+		return errors.New("Storage is closed")
+	}
+	line, tmp_err := json.Marshal(record)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	_, tmp_err = this.logFile.Write(append(line, '\n'))
+	if tmp_err == nil {
+		tmp_err = this.logFile.Sync()
+	}
+	if tmp_err != nil {
+		return tmp_err
+	}
+	this.apply(record)
+	this.logCount++
+	if this.logCount > compactThreshold && this.logCount > 2*len(this.data) {
+		return this.compact()
+	}
+	return nil
+}
+
+//need hold the write lock
+func (this *diskStorage) compact() error {
+	snapshot, tmp_err := json.Marshal(this.data)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmpPath := this.snapshotPath() + ".tmp"
+	file, tmp_err := os.Create(tmpPath)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	_, tmp_err = file.Write(snapshot)
+	if tmp_err == nil {
+		tmp_err = file.Sync()
+	}
+	file.Close()
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmp_err = os.Rename(tmpPath, this.snapshotPath())
+	if tmp_err != nil {
+		return tmp_err
+	}
+	//the rename has to reach the disk before the log is emptied
+	tmp_err = sync_dir(this.dir)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmp_err = this.logFile.Truncate(0)
+	if tmp_err == nil {
+		tmp_err = this.logFile.Sync()
+	}
+	if tmp_err != nil {
+		return tmp_err
+	}
+	this.logCount = 0
+	return nil
+}
+
+func sync_dir(dir string) error {
+	file, tmp_err := os.Open(dir)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func (this *diskStorage) Get(key string) (DataItem, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	item, ok := this.data[key]
+	return item, ok
+}
+
+func (this *diskStorage) Put(key string, item DataItem) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	record := logRecord{Op: "put", Key: key, Value: item.Value, Version: item.Version}
+	if !item.Expire.IsZero() {
+		record.Expire = &item.Expire
+	}
+	return this.append(record)
+}
+
+func (this *diskStorage) Delete(key string) (bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, ok := this.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, this.append(logRecord{Op: "del", Key: key})
+}
+
+func (this *diskStorage) Iterate(fn func(key string, item DataItem) bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for key, item := range this.data {
+		if !fn(key, item) {
+			return
+		}
+	}
+}
+
+func (this *diskStorage) RangeByHash(l, r *big.Int, isClose bool) map[string]DataItem {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return rangeByHash(this.data, l, r, isClose)
+}
+
+func (this *diskStorage) Size() int {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.data)
+}
+
+func (this *diskStorage) Clear() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.logFile == nil {
+		return errors.New("Storage is closed")
+	}
+	this.data = make(map[string]DataItem)
+	return this.compact()
+}
+
+func (this *diskStorage) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.logFile == nil {
+		return nil
+	}
+	tmp_err := this.logFile.Close()
+	this.logFile = nil
+	return tmp_err
+}
@@ -0,0 +1,112 @@
+package chord_test
+
+import (
+	"chord"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func open_disk(t *testing.T, dir string) chord.Storage {
+	t.Helper()
+	res, tmp_err := chord.OpenStorage(chord.DiskStorage, dir)
+	if tmp_err != nil {
+		t.Fatalf("open %s: %v", dir, tmp_err)
+	}
+	return res
+}
+
+func TestDiskStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+	expire := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store := open_disk(t, dir)
+	store.Put("gone", chord.DataItem{Value: "a", Version: 1})
+	store.Put("kept", chord.DataItem{Value: "b", Version: 3, Expire: expire})
+	store.Delete("gone")
+	store.Close()
+
+	//a crash in the middle of a write leaves a torn last line
+	file, tmp_err := os.OpenFile(filepath.Join(dir, "append.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	file.WriteString(`{"Op":"put","Key":"torn","Val`)
+	file.Close()
+
+	store = open_disk(t, dir)
+	if _, ok := store.Get("gone"); ok {
+		t.Errorf("a deleted pair is back")
+	}
+	if _, ok := store.Get("torn"); ok {
+		t.Errorf("the torn record is applied")
+	}
+	item, ok := store.Get("kept")
+	if !ok || item.Value != "b" || item.Version != 3 || !item.Expire.Equal(expire) {
+		t.Errorf("kept = %+v, %v", item, ok)
+	}
+	//the torn record is cut off, so a later record is not lost behind it
+	store.Put("after", chord.DataItem{Value: "c", Version: 1})
+	store.Close()
+
+	store = open_disk(t, dir)
+	defer store.Close()
+	if item, ok := store.Get("after"); !ok || item.Value != "c" {
+		t.Errorf("after = %+v, %v", item, ok)
+	}
+	if store.Size() != 2 {
+		t.Errorf("%d pairs after reopening, want 2", store.Size())
+	}
+}
+
+func TestDiskStorageCompaction(t *testing.T) {
+	dir := t.TempDir()
+	store := open_disk(t, dir)
+	//enough records of a few keys for the log to be compacted
+	for i := 0; i < 3000; i++ {
+		store.Put(fmt.Sprint("key", i%10), chord.DataItem{Value: fmt.Sprint(i), Version: uint64(i)})
+	}
+	store.Close()
+	if _, tmp_err := os.Stat(filepath.Join(dir, "snapshot.json")); tmp_err != nil {
+		t.Fatalf("no snapshot: %v", tmp_err)
+	}
+	store = open_disk(t, dir)
+	defer store.Close()
+	for i := 2990; i < 3000; i++ {
+		key := fmt.Sprint("key", i%10)
+		if item, ok := store.Get(key); !ok || item.Value != fmt.Sprint(i) {
+			t.Errorf("%s = %+v, %v, want %d", key, item, ok, i)
+		}
+	}
+}
+
+func TestRestartRecovery(t *testing.T) {
+	conf := memory_config()
+	conf.Storage = chord.DiskStorage
+	conf.DataDir = t.TempDir()
+	node := new(chord.ChordNode)
+	node.InitWithConfig(21100, conf)
+	node.Run()
+	node.Create()
+	for i := 0; i < 20; i++ {
+		tmp_err := node.Put(fmt.Sprint("key", i), fmt.Sprint("value", i))
+		if tmp_err != nil {
+			t.Fatalf("put key%d: %v", i, tmp_err)
+		}
+	}
+	//a crash, nothing is handed over
+	node.ForceQuit()
+
+	restarted := new(chord.ChordNode)
+	restarted.InitWithConfig(21100, conf)
+	restarted.Run()
+	restarted.Create()
+	defer restarted.Quit()
+	for i := 0; i < 20; i++ {
+		value, tmp_err := restarted.Get(fmt.Sprint("key", i))
+		if tmp_err != nil || value != fmt.Sprint("value", i) {
+			t.Errorf("key%d = %q, %v after the restart", i, value, tmp_err)
+		}
+	}
+}
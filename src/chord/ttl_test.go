@@ -0,0 +1,163 @@
+package chord_test
+
+import (
+	"chord"
+	"dht"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//disk_items reads the storage name ("data" or "backup") a node with conf kept at addr,
+//the node should not be running any more
+func disk_items(t *testing.T, conf chord.Config, addr string, name string) map[string]chord.DataItem {
+	t.Helper()
+	dir := filepath.Join(conf.DataDir, strings.Replace(addr, ":", "_", -1), name)
+	store, tmp_err := chord.OpenStorage(chord.DiskStorage, dir)
+	if tmp_err != nil {
+		t.Fatalf("open %s: %v", dir, tmp_err)
+	}
+	defer store.Close()
+	res := make(map[string]chord.DataItem)
+	store.Iterate(func(key string, item chord.DataItem) bool {
+		res[key] = item
+		return true
+	})
+	return res
+}
+
+//check_expire checks that the dataSets of the nodes at addrs hold every key of expire,
+//each copy with its Expire
+func check_expire(t *testing.T, step string, addrs []string, expire map[string]time.Time) {
+	t.Helper()
+	found := make(map[string]bool)
+	for _, addr := range addrs {
+		//SetBackup hands out the whole dataSet, the expired pairs as well
+		var data map[string]chord.DataItem
+		tmp_err := chord.RemoteCall(addr, "WrapNode.SetBackup", 0, &data)
+		if tmp_err != nil {
+			t.Fatalf("%s: data of %s: %v", step, addr, tmp_err)
+		}
+		for key, item := range data {
+			found[key] = true
+			if !item.Expire.Equal(expire[key]) {
+				t.Errorf("%s: %s on %s expires at %v, want %v", step, key, addr, item.Expire, expire[key])
+			}
+		}
+	}
+	for key := range expire {
+		if !found[key] {
+			t.Errorf("%s: %s is not in any dataSet", step, key)
+		}
+	}
+}
+
+func TestExpireSweep(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	conf := memory_config()
+	conf.Storage = chord.DiskStorage
+	conf.DataDir = t.TempDir()
+	ring := start_ring(t, conf, 22700, 2)
+	tmp_err := ring[0].PutWithTTL("short", "value", ttl)
+	if tmp_err == nil {
+		tmp_err = ring[0].Put("kept", "value")
+	}
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	//the sweeper runs once a second
+	time.Sleep(ttl + 2*time.Second)
+	for _, node := range ring {
+		node.ForceQuit()
+	}
+	//an expired pair is not only hidden but removed, from the owner and its replica
+	keptData, keptBackup := 0, 0
+	for i := range ring {
+		addr := dht.JoinAddress(conf.AdvertiseAddress, 22700+i)
+		data := disk_items(t, conf, addr, "data")
+		backup := disk_items(t, conf, addr, "backup")
+		if _, ok := data["short"]; ok {
+			t.Errorf("the expired pair is still in the data of %s", addr)
+		}
+		if _, ok := backup["short"]; ok {
+			t.Errorf("the expired pair is still in the backup of %s", addr)
+		}
+		keptData += len(data)
+		keptBackup += len(backup)
+	}
+	if keptData != 1 || keptBackup != 1 {
+		t.Errorf("the pair without TTL has %d copies in data and %d in backup, want 1 and 1", keptData, keptBackup)
+	}
+}
+
+func TestExpireKept(t *testing.T) {
+	conf := memory_config()
+	conf.Storage = chord.DiskStorage
+	conf.DataDir = t.TempDir()
+	ring := start_ring(t, conf, 22710, 1)
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	expire := make(map[string]time.Time)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprint("key", i)
+		tmp_err := ring[0].PutWithTTL(key, "value", time.Hour)
+		if tmp_err != nil {
+			t.Fatalf("put %s: %v", key, tmp_err)
+		}
+		var item chord.DataItem
+		tmp_err = chord.RemoteCall(dht.JoinAddress(conf.AdvertiseAddress, 22710), "WrapNode.GetItem", key, &item)
+		if tmp_err != nil || item.Expire.IsZero() {
+			t.Fatalf("item of %s = %+v, %v", key, item, tmp_err)
+		}
+		expire[key] = item.Expire
+	}
+	addrs := []string{dht.JoinAddress(conf.AdvertiseAddress, 22710)}
+	var nodes []*chord.ChordNode
+	for i := 1; i < 4; i++ {
+		node := new(chord.ChordNode)
+		node.InitWithConfig(22710+i, conf)
+		node.Run()
+		defer node.Quit()
+		tmp_err := node.Join(addrs[0])
+		if tmp_err != nil {
+			t.Fatalf("join %d: %v", 22710+i, tmp_err)
+		}
+		nodes = append(nodes, node)
+		addrs = append(addrs, dht.JoinAddress(conf.AdvertiseAddress, 22710+i))
+	}
+	time.Sleep(settleWait)
+	check_expire(t, "after transfer_data", addrs, expire)
+
+	//the successor takes the data of the leaving node over
+	tmp_err := nodes[0].Leave()
+	if tmp_err != nil {
+		t.Fatalf("leave: %v", tmp_err)
+	}
+	addrs = append(addrs[:1], addrs[2:]...)
+	time.Sleep(settleWait)
+	check_expire(t, "after the leave", addrs, expire)
+
+	//the successor promotes the backups set by the failed node
+	nodes[1].ForceQuit()
+	addrs = append(addrs[:1], addrs[2:]...)
+	time.Sleep(settleWait)
+	check_expire(t, "after the promotion", addrs, expire)
+
+	//and the backups of the two nodes left keep it as well
+	ring[0].ForceQuit()
+	nodes[2].ForceQuit()
+	found := 0
+	for _, addr := range addrs {
+		for key, item := range disk_items(t, conf, addr, "backup") {
+			found++
+			if !item.Expire.Equal(expire[key]) {
+				t.Errorf("backup of %s on %s expires at %v, want %v", key, addr, item.Expire, expire[key])
+			}
+		}
+	}
+	if found != len(expire) {
+		t.Errorf("%d backups are kept, want %d", found, len(expire))
+	}
+}
@@ -0,0 +1,79 @@
+package chord
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"strings"
+)
+
+//A ChordNode may host some virtual nodes which share its network station.
+//The address of the i-th virtual node is "ip:port#i", and its rpc service
+//is registered as "WrapNode#i" in the station of "ip:port".
+
+const vnodeSeparator = "#"
+
+//A node moved by the balancer takes an identifier which is not the hash of its address,
+//the identifier is put in the address as "ip:port@id" or "ip:port#i@id" in hex,
+//so every node still finds the identifier of another one from its address.
+const idSeparator = "@"
+
+//NodeID is the identifier of the (virtual) node at addr.
+func NodeID(addr string) *big.Int {
+	pos := strings.LastIndex(addr, idSeparator)
+	if pos >= 0 {
+		res, ok := new(big.Int).SetString(addr[pos+1:], 16)
+		if ok {
+			return res
+		}
+	}
+	return ConsistentHash(addr)
+}
+
+//split the address of a (virtual) node into the network address and the rpc service name
+func splitAddress(addr string) (string, string) {
+	pos := strings.IndexAny(addr, vnodeSeparator+idSeparator)
+	if pos < 0 {
+		return addr, "WrapNode"
+	}
+	return addr[:pos], "WrapNode" + addr[pos:]
+}
+
+//the aimFunc of a rpc call is always "WrapNode.xxx", route it to the service of aimNode
+func routeCall(aimNode string, aimFunc string) (string, string) {
+	netAddr, service := splitAddress(aimNode)
+	if service != "WrapNode" {
+		aimFunc = service + strings.TrimPrefix(aimFunc, "WrapNode")
+	}
+	return netAddr, aimFunc
+}
+
+func (this *ChordNode) init_vnodes(conf Config) {
+	this.vnodes = nil
+	for i := 1; i < conf.VirtualNodes; i++ {
+		vnode := new(ChordNode)
+		vnode.init_node(fmt.Sprintf("%s%s%d", this.get_address(), vnodeSeparator, i), conf)
+		this.vnodes = append(this.vnodes, vnode)
+	}
+}
+
+//the node itself and all its virtual nodes
+func (this *ChordNode) all_nodes() []*ChordNode {
+	return append([]*ChordNode{this}, this.vnodes...)
+}
+
+//join all virtual nodes into the network of addr, the error is the one of the
+//first virtual node which can not join, the others still try
+func (this *ChordNode) join_vnodes(addr string) error {
+	var res error
+	for _, vnode := range this.vnodes {
+		tmp_err := vnode.Join(addr)
+		if tmp_err != nil {
+			log.Errorln("In function join_vnodes", vnode.get_address(), "can not join because", tmp_err)
+			if res == nil {
+				res = fmt.Errorf("virtual node %s can not join: %w", vnode.get_address(), tmp_err)
+			}
+		}
+	}
+	return res
+}
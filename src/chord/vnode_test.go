@@ -0,0 +1,97 @@
+package chord_test
+
+import (
+	"chord"
+	"context"
+	"dht"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+//wait_ring waits until the walk from seed finds the nodes at addrs without violations
+func wait_ring(t *testing.T, seed string, addrs []string) *chord.RingReport {
+	t.Helper()
+	var report *chord.RingReport
+	var tmp_err error
+	for try := 0; try < 20; try++ {
+		report, tmp_err = chord.CheckRing(context.Background(), seed, false)
+		if tmp_err == nil && len(report.Nodes) == len(addrs) && len(report.Violations) == 0 {
+			return report
+		}
+		time.Sleep(settleWait / 4)
+	}
+	if tmp_err != nil {
+		t.Fatalf("check ring: %v", tmp_err)
+	}
+	t.Fatalf("the walk found %v with %+v, want the %d nodes", report.Nodes, report.Violations, len(addrs))
+	return nil
+}
+
+func TestVirtualNodes(t *testing.T) {
+	const size = 3
+	const vnodes = 4
+	conf := memory_config()
+	conf.VirtualNodes = vnodes
+	ring := start_ring(t, conf, 21400, size)
+	//every virtual node is in the ring, after the one before it by identifier
+	var addrs []string
+	for i := 0; i < size; i++ {
+		addr := dht.JoinAddress(conf.AdvertiseAddress, 21400+i)
+		addrs = append(addrs, addr)
+		for j := 1; j < vnodes; j++ {
+			addrs = append(addrs, fmt.Sprint(addr, "#", j))
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+	report := wait_ring(t, addrs[0], addrs)
+	for i, addr := range report.Nodes {
+		if addr != addrs[i] {
+			t.Fatalf("the walk found %v, want %v", report.Nodes, addrs)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		tmp_err := ring[i%size].Put(fmt.Sprint("key", i), fmt.Sprint("value", i))
+		if tmp_err != nil {
+			t.Fatalf("put key%d: %v", i, tmp_err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		value, tmp_err := ring[(i+1)%size].Get(fmt.Sprint("key", i))
+		if tmp_err != nil || value != fmt.Sprint("value", i) {
+			t.Errorf("key%d = %q, %v", i, value, tmp_err)
+		}
+	}
+	wait_ring(t, addrs[0], addrs)
+
+	//the calls to a virtual node are served by its WrapNode#i service
+	total := 0
+	for i, addr := range addrs {
+		var pred string
+		tmp_err := chord.RemoteCall(addr, "WrapNode.GetPredecessor", 0, &pred)
+		want := addrs[(i+len(addrs)-1)%len(addrs)]
+		if tmp_err != nil || pred != want {
+			t.Errorf("predecessor of %s = %q, %v, want %s", addr, pred, tmp_err, want)
+		}
+		var stored chord.StoredKeys
+		tmp_err = chord.RemoteCall(addr, "WrapNode.StoredKeys", 0, &stored)
+		if tmp_err != nil {
+			t.Fatalf("stored keys of %s: %v", addr, tmp_err)
+		}
+		for _, key := range stored.Data {
+			if !in_range(chord.ConsistentHash(key), chord.NodeID(want), chord.NodeID(addr)) {
+				t.Errorf("%s keeps %s which is not in its range", addr, key)
+			}
+		}
+		total += len(stored.Data)
+	}
+	if total != 100 {
+		t.Errorf("the (virtual) nodes keep %d keys, want 100", total)
+	}
+}
@@ -1,14 +1,48 @@
 package chord
 
-import "math/big"
+import (
+	"context"
+	"dht"
+	"math/big"
+	"time"
+)
 
 type WrapNode struct {
 	node *ChordNode
 }
 
-func (this *WrapNode) FindSuccessor(aimID *big.Int, res *string) error {
+type FindSuccessorArg struct {
+	ID *big.Int
+	//the lookup is abandoned after Deadline, zero means no deadline
+	Deadline time.Time
+}
+
+type FindSuccessorRes struct {
+	Address string
+	//remote nodes the lookup went through
+	Hops int
+}
+
+func (this *WrapNode) Ping(_ int, _ *string) error {
+	if this.node.retired {
+		return dht.ErrNotJoined
+	}
+	return nil
+}
+
+func (this *WrapNode) FindSuccessor(arg FindSuccessorArg, res *FindSuccessorRes) error {
 	//find aimID's successor
-	return this.node.innner_find_successor(aimID, res)
+	ctx := context.Background()
+	if !arg.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = dht.WithDeadline(this.node.config.Clock, ctx, arg.Deadline)
+		defer cancel()
+	}
+	return this.node.find_successor(ctx, arg.ID, res)
+}
+
+func (this *WrapNode) ClosestPrecedingFinger(aimID *big.Int, res *LookupStep) error {
+	return this.node.closest_preceding_finger(aimID, res)
 }
 
 func (this *WrapNode) GetPredecessor(_ int, res *string) error {
@@ -19,19 +53,31 @@ func (this *WrapNode) GetSuccessorList(_ int, res *[successorListLength]string)
 	return this.node.get_successor_list(res)
 }
 
-func (this *WrapNode) TransferData(preNode string, data *map[string]string) error {
+func (this *WrapNode) TransferData(preNode string, data *map[string]DataItem) error {
 	return this.node.transfer_data(preNode, data)
 }
 
-func (this *WrapNode) SubBackup(data map[string]string, _ *string) error {
+func (this *WrapNode) SubBackup(data map[string]DataItem, _ *string) error {
 	return this.node.sub_backup(data)
 }
 
-func (this *WrapNode) AddBackup(data map[string]string, _ *string) error {
+func (this *WrapNode) AddBackup(data map[string]DataItem, _ *string) error {
 	return this.node.add_backup(data)
 }
 
-func (this *WrapNode) SetBackup(_ int, backup *map[string]string) error {
+func (this *WrapNode) AddData(data map[string]DataItem, _ *string) error {
+	return this.node.add_data(data)
+}
+
+func (this *WrapNode) TakeOver(arg LeaveArg, _ *string) error {
+	return this.node.take_over(arg)
+}
+
+func (this *WrapNode) SuccessorLeave(arg LeaveArg, _ *string) error {
+	return this.node.successor_leave(arg)
+}
+
+func (this *WrapNode) SetBackup(_ int, backup *map[string]DataItem) error {
 	return this.node.set_backup(backup)
 }
 
@@ -60,10 +106,51 @@ func (this *WrapNode) GetValue(key string, res *string) error {
 	return this.node.get_value(key, res)
 }
 
+func (this *WrapNode) GetItem(key string, res *DataItem) error {
+	return this.node.get_item(key, res)
+}
+
+func (this *WrapNode) CompareAndSwap(arg CompareAndSwapArg, res *CompareAndSwapRes) error {
+	return this.node.compare_and_swap(arg, res)
+}
+
 func (this *WrapNode) ErasePairInData(key string, _ *string) error {
 	return this.node.erase_pair_inData(key)
 }
 
+func (this *WrapNode) ScanData(arg ScanArg, res *ScanPage) error {
+	return this.node.scan_data(arg, res)
+}
+
 func (this *WrapNode) ErasePairInBackup(key string, _ *string) error {
 	return this.node.erase_pair_inBackup(key)
 }
+
+func (this *WrapNode) MerkleNodes(arg MerkleArg, res *[][]byte) error {
+	return this.node.merkle_nodes(arg, res)
+}
+
+func (this *WrapNode) SyncBackup(arg SyncBackupArg, _ *string) error {
+	return this.node.sync_backup(arg)
+}
+
+func (this *WrapNode) Load(_ int, res *LoadInfo) error {
+	return this.node.load_info(res)
+}
+
+func (this *WrapNode) ReserveSplit(requester string, res *big.Int) error {
+	return this.node.reserve_split(requester, res)
+}
+
+func (this *WrapNode) DebugState(_ int, res *DebugState) error {
+	*res = this.node.DebugState()
+	return nil
+}
+
+func (this *WrapNode) StoredKeys(_ int, res *StoredKeys) error {
+	return this.node.stored_keys(res)
+}
+
+func (this *WrapNode) Repair(arg RepairArg, _ *string) error {
+	return this.node.repair(arg)
+}
@@ -0,0 +1,307 @@
+//Package conformance runs the scenarios of the test program in src/main as go tests
+//against any implementation of the node interface: a test of the implementation
+//passes a Factory to Run. Unlike the program, a scenario reports every operation
+//which fails with its key and node, and stops after Config.MaxFailures of them.
+package conformance
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//Node is what the scenarios drive, the same as dhtNode of the test program.
+type Node interface {
+	Run()
+	Create()
+	Join(addr string) error
+	Quit()
+	ForceQuit()
+	Ping(addr string) bool
+	Put(key string, value string) error
+	Get(key string) (string, error)
+	//Delete really removes the pair, a missing key is dht.ErrNotFound
+	Delete(key string) error
+}
+
+//Factory makes the node listening on port, and tells the address other nodes join it by.
+type Factory func(port int) (Node, string)
+
+//Config sets the size and the waits of the scenarios.
+type Config struct {
+	//seed of the keys, the values and the choice of nodes, 0 means a seed from the time.
+	//The seed of a scenario is logged, so a failure can be run again
+	Seed int64
+	//the nodes of a scenario listen on the ports from FirstPort, each scenario
+	//takes a range of its own
+	FirstPort int
+	//a scenario stops after so many failed operations
+	MaxFailures int
+	//length of the keys and the values
+	KeyLength int
+
+	//Basic: nodes beside the creator, rounds, and the operations in each round
+	BasicNodes   int
+	BasicRounds  int
+	RoundJoins   int
+	RoundQuits   int
+	RoundPuts    int
+	RoundGets    int
+	RoundDeletes int
+
+	//ForceQuit: nodes beside the creator, pairs put, and rounds of force quits
+	ForceQuitNodes  int
+	ForceQuitPuts   int
+	ForceQuitRounds int
+
+	//QuitAndStabilize: nodes beside the creator, pairs put, and pairs checked after each quit
+	QASNodes int
+	QASPuts  int
+	QASGets  int
+	//QuitAndStabilize stops quitting when so many nodes are left, a node whose Quit hands
+	//nothing over to the others can only keep the pairs while enough of their holders are left
+	QASRemain int
+
+	//wait after all nodes Run
+	AfterRunWait time.Duration
+	//wait after each join and quit
+	JoinWait      time.Duration
+	QuitWait      time.Duration
+	ForceQuitWait time.Duration
+	//wait for the network to settle after the joins and quits of a round
+	SettleWait time.Duration
+}
+
+//DefaultConfig is a smaller network than the test program with shorter waits,
+//which fits the nodes running on a dht.MemoryNetwork.
+func DefaultConfig() Config {
+	return Config{FirstPort: 20000, MaxFailures: 20, KeyLength: 50,
+		BasicNodes: 30, BasicRounds: 3, RoundJoins: 10, RoundQuits: 5, RoundPuts: 60, RoundGets: 50, RoundDeletes: 25,
+		ForceQuitNodes: 20, ForceQuitPuts: 200, ForceQuitRounds: 4,
+		QASNodes: 20, QASPuts: 200, QASGets: 20, QASRemain: 1,
+		AfterRunWait: 200 * time.Millisecond, JoinWait: 100 * time.Millisecond, QuitWait: 80 * time.Millisecond,
+		ForceQuitWait: 500 * time.Millisecond, SettleWait: 2 * time.Second}
+}
+
+//ProgramConfig has the sizes and the waits of the test program.
+func ProgramConfig() Config {
+	return Config{FirstPort: 20000, MaxFailures: 20, KeyLength: 50,
+		BasicNodes: 100, BasicRounds: 5, RoundJoins: 20, RoundQuits: 10, RoundPuts: 150, RoundGets: 120, RoundDeletes: 70,
+		ForceQuitNodes: 50, ForceQuitPuts: 500, ForceQuitRounds: 10,
+		QASNodes: 50, QASPuts: 500, QASGets: 20, QASRemain: 1,
+		AfterRunWait: 200 * time.Millisecond, JoinWait: time.Second, QuitWait: 80 * time.Millisecond,
+		ForceQuitWait: 500 * time.Millisecond, SettleWait: 10 * time.Second}
+}
+
+//Run runs all scenarios as subtests.
+func Run(t *testing.T, factory Factory, conf Config) {
+	t.Run("Basic", func(t *testing.T) {
+		Basic(t, factory, conf)
+	})
+	t.Run("ForceQuit", func(t *testing.T) {
+		ForceQuit(t, factory, conf)
+	})
+	t.Run("QuitAndStabilize", func(t *testing.T) {
+		QuitAndStabilize(t, factory, conf)
+	})
+}
+
+//Basic grows the network round by round: in each round nodes join, pairs are put, got
+//and deleted, nodes quit, and the pairs are checked again.
+func Basic(t *testing.T, factory Factory, conf Config) {
+	s := start(t, factory, conf, conf.FirstPort, conf.BasicNodes)
+	defer s.quit_all()
+	next := 1
+	for round := 1; round <= conf.BasicRounds; round++ {
+		for i := 0; i < conf.RoundJoins && next < len(s.nodes); i++ {
+			s.join(next)
+			next++
+			time.Sleep(conf.JoinWait)
+		}
+		time.Sleep(conf.SettleWait)
+		s.put_some(conf.RoundPuts)
+		s.get_some(conf.RoundGets)
+		s.delete_some(conf.RoundDeletes)
+
+		for i := 0; i < conf.RoundQuits && len(s.live) > 1; i++ {
+			s.quit(s.rand.Intn(len(s.live)), false)
+			time.Sleep(conf.QuitWait)
+		}
+		time.Sleep(conf.SettleWait)
+		s.put_some(conf.RoundPuts)
+		s.get_some(conf.RoundGets)
+		s.delete_some(conf.RoundDeletes)
+	}
+}
+
+//ForceQuit puts pairs into a network, then nodes quit without informing the others
+//round by round, and every pair is checked after each round.
+func ForceQuit(t *testing.T, factory Factory, conf Config) {
+	s := start(t, factory, conf, conf.FirstPort+1000, conf.ForceQuitNodes)
+	defer s.quit_all()
+	s.join_all()
+	s.put_some(conf.ForceQuitPuts)
+	perRound := (conf.ForceQuitNodes + 1) / conf.ForceQuitRounds
+	for round := 1; round < conf.ForceQuitRounds; round++ {
+		for i := 0; i < perRound && len(s.live) > 1; i++ {
+			s.quit(s.rand.Intn(len(s.live)), true)
+			time.Sleep(conf.ForceQuitWait)
+		}
+		s.get_some(len(s.keys))
+	}
+}
+
+//QuitAndStabilize puts pairs into a network, then the nodes quit one by one
+//until Config.QASRemain are left, and some pairs are checked after each quit.
+func QuitAndStabilize(t *testing.T, factory Factory, conf Config) {
+	s := start(t, factory, conf, conf.FirstPort+2000, conf.QASNodes)
+	defer s.quit_all()
+	s.join_all()
+	s.put_some(conf.QASPuts)
+	for len(s.live) > conf.QASRemain && len(s.live) > 1 {
+		s.quit(s.rand.Intn(len(s.live)), false)
+		time.Sleep(conf.QuitWait)
+		s.get_some(conf.QASGets)
+	}
+}
+
+//the state of a running scenario
+type scenario struct {
+	t     *testing.T
+	conf  Config
+	rand  *rand.Rand
+	nodes []Node
+	addrs []string
+	//indexes of the nodes in the network
+	live []int
+	//the pairs which should be found, keys in the order they are put
+	pairs    map[string]string
+	keys     []string
+	failures int
+}
+
+//make and Run the creator and size other nodes, and Create the network on the creator
+func start(t *testing.T, factory Factory, conf Config, firstPort int, size int) *scenario {
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", seed)
+	s := &scenario{t: t, conf: conf, rand: rand.New(rand.NewSource(seed)), pairs: make(map[string]string)}
+	for i := 0; i <= size; i++ {
+		node, addr := factory(firstPort + i)
+		node.Run()
+		s.nodes = append(s.nodes, node)
+		s.addrs = append(s.addrs, addr)
+	}
+	time.Sleep(conf.AfterRunWait)
+	s.nodes[0].Create()
+	s.live = append(s.live, 0)
+	return s
+}
+
+func (this *scenario) fail(format string, args ...interface{}) {
+	this.t.Helper()
+	this.t.Errorf(format, args...)
+	this.failures++
+	if this.failures >= this.conf.MaxFailures {
+		this.t.Fatalf("stop after %d failures", this.failures)
+	}
+}
+
+//a random node in the network
+func (this *scenario) pick() int {
+	return this.live[this.rand.Intn(len(this.live))]
+}
+
+func (this *scenario) join(i int) {
+	through := this.pick()
+	tmp_err := this.nodes[i].Join(this.addrs[through])
+	if tmp_err != nil {
+		this.fail("join %s through %s: %v", this.addrs[i], this.addrs[through], tmp_err)
+	}
+	this.live = append(this.live, i)
+}
+
+//join every node through an earlier one, and wait for the network to settle
+func (this *scenario) join_all() {
+	for i := 1; i < len(this.nodes); i++ {
+		this.join(i)
+		time.Sleep(this.conf.JoinWait)
+	}
+	time.Sleep(this.conf.SettleWait)
+}
+
+//quit the pos-th node of the network
+func (this *scenario) quit(pos int, force bool) {
+	i := this.live[pos]
+	if force {
+		this.nodes[i].ForceQuit()
+	} else {
+		this.nodes[i].Quit()
+	}
+	this.live[pos] = this.live[len(this.live)-1]
+	this.live = this.live[:len(this.live)-1]
+}
+
+func (this *scenario) quit_all() {
+	for _, node := range this.nodes {
+		node.Quit()
+	}
+}
+
+func (this *scenario) random_string() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	res := make([]byte, this.conf.KeyLength)
+	for i := range res {
+		res[i] = letters[this.rand.Intn(len(letters))]
+	}
+	return string(res)
+}
+
+//put count new pairs through random nodes
+func (this *scenario) put_some(count int) {
+	for i := 0; i < count; i++ {
+		key, value := this.random_string(), this.random_string()
+		at := this.pick()
+		tmp_err := this.nodes[at].Put(key, value)
+		if tmp_err != nil {
+			this.fail("put %q on %s: %v", key, this.addrs[at], tmp_err)
+			continue
+		}
+		this.pairs[key] = value
+		this.keys = append(this.keys, key)
+	}
+}
+
+//get the first count pairs through random nodes
+func (this *scenario) get_some(count int) {
+	for _, key := range this.keys {
+		if count == 0 {
+			return
+		}
+		count--
+		i := this.pick()
+		res, tmp_err := this.nodes[i].Get(key)
+		if tmp_err != nil {
+			this.fail("get %q on %s: %v", key, this.addrs[i], tmp_err)
+		} else if res != this.pairs[key] {
+			this.fail("get %q on %s = %q, want %q", key, this.addrs[i], res, this.pairs[key])
+		}
+	}
+}
+
+//delete the first count pairs through random nodes
+func (this *scenario) delete_some(count int) {
+	for count > 0 && len(this.keys) > 0 {
+		count--
+		key := this.keys[0]
+		this.keys = this.keys[1:]
+		delete(this.pairs, key)
+		i := this.pick()
+		tmp_err := this.nodes[i].Delete(key)
+		if tmp_err != nil {
+			this.fail("delete %q on %s: %v", key, this.addrs[i], tmp_err)
+		}
+	}
+}
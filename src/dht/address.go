@@ -0,0 +1,67 @@
+package dht
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var localAddress string
+var localOnce sync.Once
+
+//LocalAddress returns the first IPv4 address of an up non-loopback interface,
+//or the first such IPv6 address, or 127.0.0.1 if there is none.
+//It is only detected when it is first asked for.
+func LocalAddress() string {
+	localOnce.Do(func() {
+		localAddress = detectLocalAddress()
+	})
+	return localAddress
+}
+
+func detectLocalAddress() string {
+	var ip6 string
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, elt := range ifaces {
+		if elt.Flags&net.FlagLoopback != 0 || elt.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := elt.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); len(ip4) == net.IPv4len {
+				return ip4.String()
+			}
+			if ip6 == "" && ipnet.IP.IsGlobalUnicast() {
+				ip6 = ipnet.IP.String()
+			}
+		}
+	}
+	if ip6 != "" {
+		return ip6
+	}
+	return "127.0.0.1"
+}
+
+//JoinAddress makes the "host:port" address of a node. addr may be a host name,
+//an IPv4 or IPv6 address, with or without brackets, and a port in addr is kept.
+//The local address is used when addr is empty.
+func JoinAddress(addr string, port int) string {
+	if addr == "" {
+		addr = LocalAddress()
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), strconv.Itoa(port))
+}
@@ -0,0 +1,52 @@
+package dht_test
+
+import (
+	"dht"
+	"net"
+	"testing"
+)
+
+func TestJoinAddress(t *testing.T) {
+	cases := []struct {
+		addr string
+		port int
+		want string
+	}{
+		{"127.0.0.1", 8000, "127.0.0.1:8000"},
+		{"127.0.0.1:9000", 8000, "127.0.0.1:9000"},
+		{"::1", 8000, "[::1]:8000"},
+		{"2001:db8::1", 8000, "[2001:db8::1]:8000"},
+		{"[::1]", 8000, "[::1]:8000"},
+		{"[2001:db8::1]:9000", 8000, "[2001:db8::1]:9000"},
+		{"localhost", 8000, "localhost:8000"},
+		{"node-1.example.com", 8000, "node-1.example.com:8000"},
+		{"node-1.example.com:9000", 8000, "node-1.example.com:9000"},
+		{"chord", 0, "chord:0"},
+	}
+	for _, c := range cases {
+		res := dht.JoinAddress(c.addr, c.port)
+		if res != c.want {
+			t.Errorf("JoinAddress(%q, %d) = %q, want %q", c.addr, c.port, res, c.want)
+		}
+	}
+	//an empty address is the local one
+	host, port, tmp_err := net.SplitHostPort(dht.JoinAddress("", 8000))
+	if tmp_err != nil || host != dht.LocalAddress() || port != "8000" {
+		t.Errorf("JoinAddress of the local address = %q %q, %v, want %q 8000", host, port, tmp_err, dht.LocalAddress())
+	}
+}
+
+func TestLocalAddress(t *testing.T) {
+	res := dht.LocalAddress()
+	ip := net.ParseIP(res)
+	if ip == nil {
+		t.Fatalf("LocalAddress %q is not an IP address", res)
+	}
+	//the loopback address is only used when no interface has an address
+	if ip.IsLoopback() && res != "127.0.0.1" {
+		t.Errorf("LocalAddress is the loopback address %q", res)
+	}
+	if again := dht.LocalAddress(); again != res {
+		t.Errorf("LocalAddress is %q, then %q", res, again)
+	}
+}
@@ -0,0 +1,211 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+//a call is rejected if its timestamp is further than this from the clock of the server
+const authWindow = 30 * time.Second
+
+//ClusterAuth signs the rpc calls of a cluster with a pre-shared key and checks them.
+//Every request carries a timestamp, a random nonce and a HMAC-SHA256 over the address
+//of the server, the method, the timestamp, the nonce and the encoded arguments.
+//The server rejects a call whose HMAC is wrong, whose timestamp is out of authWindow,
+//or whose nonce has already been seen, before the method is run. A call recorded
+//on the way to one node can not be replayed to another node either.
+type ClusterAuth struct {
+	key []byte
+
+	//nonces seen in the last authWindow and when they can be forgotten
+	seen      map[uint64]time.Time
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+//authFrame follows the header of every signed request, the arguments are encoded in Body.
+type authFrame struct {
+	Time  int64
+	Nonce uint64
+	MAC   []byte
+	Body  []byte
+}
+
+func NewClusterAuth(key []byte) *ClusterAuth {
+	return &ClusterAuth{key: append([]byte(nil), key...), seen: make(map[uint64]time.Time)}
+}
+
+func (this *ClusterAuth) mac(server string, method string, frame *authFrame) []byte {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(frame.Time))
+	binary.BigEndian.PutUint64(buf[8:], frame.Nonce)
+	h := hmac.New(sha256.New, this.key)
+	h.Write([]byte(server))
+	h.Write([]byte{0})
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(buf[:])
+	h.Write(frame.Body)
+	return h.Sum(nil)
+}
+
+func (this *ClusterAuth) sign(server string, method string, body interface{}) (*authFrame, error) {
+	var buf bytes.Buffer
+	tmp_err := gob.NewEncoder(&buf).Encode(body)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	var nonce [8]byte
+	_, tmp_err = rand.Read(nonce[:])
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	frame := &authFrame{Time: time.Now().UnixNano(), Nonce: binary.BigEndian.Uint64(nonce[:]), Body: buf.Bytes()}
+	frame.MAC = this.mac(server, method, frame)
+	return frame, nil
+}
+
+func (this *ClusterAuth) verify(server string, method string, frame *authFrame) error {
+	if !hmac.Equal(frame.MAC, this.mac(server, method, frame)) {
+		return ErrUnauthenticated
+	}
+	now := time.Now()
+	sent := time.Unix(0, frame.Time)
+	if sent.Before(now.Add(-authWindow)) || sent.After(now.Add(authWindow)) {
+		return ErrUnauthenticated
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if now.Sub(this.lastPrune) > authWindow {
+		for nonce, forget := range this.seen {
+			if now.After(forget) {
+				delete(this.seen, nonce)
+			}
+		}
+		this.lastPrune = now
+	}
+	if _, ok := this.seen[frame.Nonce]; ok {
+		return ErrUnauthenticated
+	}
+	this.seen[frame.Nonce] = sent.Add(authWindow)
+	return nil
+}
+
+//NewClientCodec is used as rpc.NewClientWithCodec(auth.NewClientCodec(conn, server)),
+//every call is signed for the node whose address is server.
+func (this *ClusterAuth) NewClientCodec(conn io.ReadWriteCloser, server string) rpc.ClientCodec {
+	buf := bufio.NewWriter(conn)
+	return &authClientCodec{auth: this, server: server, rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+//NewServerCodec is used as rpc.Server.ServeCodec(auth.NewServerCodec(conn, server)) by the node
+//whose address is server, a call not signed for it with the same key is answered with ErrUnauthenticated.
+func (this *ClusterAuth) NewServerCodec(conn io.ReadWriteCloser, server string) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &authServerCodec{auth: this, server: server, rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+type authClientCodec struct {
+	auth   *ClusterAuth
+	server string
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func (this *authClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	frame, tmp_err := this.auth.sign(this.server, r.ServiceMethod, body)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmp_err = this.enc.Encode(r)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmp_err = this.enc.Encode(frame)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	return this.encBuf.Flush()
+}
+
+func (this *authClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return this.dec.Decode(r)
+}
+
+func (this *authClientCodec) ReadResponseBody(body interface{}) error {
+	return this.dec.Decode(body)
+}
+
+func (this *authClientCodec) Close() error {
+	return this.rwc.Close()
+}
+
+type authServerCodec struct {
+	auth   *ClusterAuth
+	server string
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+
+	//method of the request whose body is read next
+	method string
+}
+
+func (this *authServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	tmp_err := this.dec.Decode(r)
+	this.method = r.ServiceMethod
+	return tmp_err
+}
+
+//the error is answered to the caller by net/rpc, and the method is not run
+func (this *authServerCodec) ReadRequestBody(body interface{}) error {
+	var frame authFrame
+	tmp_err := this.dec.Decode(&frame)
+	if tmp_err != nil {
+		//the caller does not sign its calls
+		return ErrUnauthenticated
+	}
+	if body == nil {
+		return nil
+	}
+	tmp_err = this.auth.verify(this.server, this.method, &frame)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	return gob.NewDecoder(bytes.NewReader(frame.Body)).Decode(body)
+}
+
+func (this *authServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	tmp_err := this.enc.Encode(r)
+	if tmp_err == nil {
+		tmp_err = this.enc.Encode(body)
+	}
+	if tmp_err != nil {
+		if this.encBuf.Flush() == nil {
+			this.Close()
+		}
+		return tmp_err
+	}
+	return this.encBuf.Flush()
+}
+
+func (this *authServerCodec) Close() error {
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	return this.rwc.Close()
+}
@@ -0,0 +1,156 @@
+package dht_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dht"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type Echo struct{}
+
+func (this *Echo) Echo(input string, res *string) error {
+	*res = input
+	return nil
+}
+
+//frame is the authFrame a signed request carries after its header
+type frame struct {
+	Time  int64
+	Nonce uint64
+	MAC   []byte
+	Body  []byte
+}
+
+//signed_frame signs input for method of server as a client with key does, at sent with nonce
+func signed_frame(t *testing.T, key []byte, server string, method string, input string, sent time.Time, nonce uint64) *frame {
+	t.Helper()
+	var body bytes.Buffer
+	tmp_err := gob.NewEncoder(&body).Encode(input)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	res := &frame{Time: sent.UnixNano(), Nonce: nonce, Body: body.Bytes()}
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(res.Time))
+	binary.BigEndian.PutUint64(buf[8:], res.Nonce)
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(server))
+	h.Write([]byte{0})
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(buf[:])
+	h.Write(res.Body)
+	res.MAC = h.Sum(nil)
+	return res
+}
+
+//serve_auth serves the Echo service as the node at server over a new pipe,
+//and returns the end of the caller
+func serve_auth(t *testing.T, auth *dht.ClusterAuth, server string) net.Conn {
+	t.Helper()
+	rpcServer := rpc.NewServer()
+	tmp_err := rpcServer.Register(new(Echo))
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	client, conn := net.Pipe()
+	go rpcServer.ServeCodec(auth.NewServerCodec(conn, server))
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+//send_frame sends a request with f on a new connection to server,
+//and returns the error it is answered with
+func send_frame(t *testing.T, auth *dht.ClusterAuth, server string, f *frame) error {
+	t.Helper()
+	conn := serve_auth(t, auth, server)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	enc := gob.NewEncoder(conn)
+	tmp_err := enc.Encode(&rpc.Request{ServiceMethod: "Echo.Echo", Seq: 1})
+	if tmp_err == nil {
+		tmp_err = enc.Encode(f)
+	}
+	if tmp_err != nil {
+		t.Fatalf("send the request: %v", tmp_err)
+	}
+	dec := gob.NewDecoder(conn)
+	var resp rpc.Response
+	var body struct{}
+	tmp_err = dec.Decode(&resp)
+	if tmp_err == nil && resp.Error != "" {
+		//the body of a rejected call is empty
+		tmp_err = dec.Decode(&body)
+	}
+	if tmp_err != nil {
+		t.Fatalf("read the response: %v", tmp_err)
+	}
+	if resp.Error != "" {
+		return dht.FromRemote(rpc.ServerError(resp.Error))
+	}
+	return nil
+}
+
+func TestClusterAuth(t *testing.T) {
+	key := []byte("cluster key")
+	auth := dht.NewClusterAuth(key)
+	const server = "node"
+
+	client := rpc.NewClientWithCodec(auth.NewClientCodec(serve_auth(t, auth, server), server))
+	var res string
+	tmp_err := client.Call("Echo.Echo", "hello", &res)
+	if tmp_err != nil || res != "hello" {
+		t.Fatalf("a signed call = %q, %v", res, tmp_err)
+	}
+
+	t.Run("WrongKey", func(t *testing.T) {
+		other := dht.NewClusterAuth([]byte("another key"))
+		client := rpc.NewClientWithCodec(other.NewClientCodec(serve_auth(t, auth, server), server))
+		tmp_err := dht.FromRemote(client.Call("Echo.Echo", "hello", new(string)))
+		if !errors.Is(tmp_err, dht.ErrUnauthenticated) {
+			t.Errorf("a call signed with another key: %v, want ErrUnauthenticated", tmp_err)
+		}
+		tmp_err = send_frame(t, auth, server, signed_frame(t, []byte("another key"), server, "Echo.Echo", "hello", time.Now(), 1))
+		if !errors.Is(tmp_err, dht.ErrUnauthenticated) {
+			t.Errorf("a frame signed with another key: %v, want ErrUnauthenticated", tmp_err)
+		}
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		//the frames are signed right, only the time tells them apart
+		tmp_err := send_frame(t, auth, server, signed_frame(t, key, server, "Echo.Echo", "hello", time.Now(), 2))
+		if tmp_err != nil {
+			t.Fatalf("a frame signed now: %v", tmp_err)
+		}
+		for _, sent := range []time.Time{time.Now().Add(-time.Minute), time.Now().Add(time.Minute)} {
+			tmp_err = send_frame(t, auth, server, signed_frame(t, key, server, "Echo.Echo", "hello", sent, uint64(sent.UnixNano())))
+			if !errors.Is(tmp_err, dht.ErrUnauthenticated) {
+				t.Errorf("a frame signed %v from now: %v, want ErrUnauthenticated", time.Until(sent).Round(time.Second), tmp_err)
+			}
+		}
+	})
+
+	t.Run("Replayed", func(t *testing.T) {
+		recorded := signed_frame(t, key, server, "Echo.Echo", "hello", time.Now(), 3)
+		tmp_err := send_frame(t, auth, server, recorded)
+		if tmp_err != nil {
+			t.Fatalf("the first time: %v", tmp_err)
+		}
+		tmp_err = send_frame(t, auth, server, recorded)
+		if !errors.Is(tmp_err, dht.ErrUnauthenticated) {
+			t.Errorf("the frame sent again: %v, want ErrUnauthenticated", tmp_err)
+		}
+		//nor is it served by another node of the cluster
+		tmp_err = send_frame(t, dht.NewClusterAuth(key), "another node", signed_frame(t, key, server, "Echo.Echo", "hello", time.Now(), 4))
+		if !errors.Is(tmp_err, dht.ErrUnauthenticated) {
+			t.Errorf("a frame signed for %s sent to another node: %v, want ErrUnauthenticated", server, tmp_err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//Clock is the time a node sees: its background loops are started with Go and wait
+//with Sleep, and the expiry of its pairs is measured with Now. A simulator replaces
+//it to run a network in virtual time, see package sim.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	//Go runs f in a new goroutine.
+	Go(f func())
+}
+
+type realClock struct{}
+
+//RealClock is the clock of the machine, used when no clock is set.
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) Go(f func()) {
+	go f()
+}
+
+//After is time.After measured on c.
+func After(c Clock, d time.Duration) <-chan time.Time {
+	if c == RealClock {
+		return time.After(d)
+	}
+	res := make(chan time.Time, 1)
+	c.Go(func() {
+		c.Sleep(d)
+		res <- c.Now()
+	})
+	return res
+}
+
+//WithTimeout is context.WithTimeout measured on c.
+func WithTimeout(c Clock, parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return WithDeadline(c, parent, c.Now().Add(d))
+}
+
+//WithDeadline is context.WithDeadline measured on c, its Err is
+//context.DeadlineExceeded once c reaches deadline.
+func WithDeadline(c Clock, parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if c == RealClock {
+		return context.WithDeadline(parent, deadline)
+	}
+	if old, ok := parent.Deadline(); ok && old.Before(deadline) {
+		return context.WithCancel(parent)
+	}
+	inner, cancel := context.WithCancel(parent)
+	res := &clockContext{Context: inner, deadline: deadline}
+	c.Go(func() {
+		c.Sleep(deadline.Sub(c.Now()))
+		res.lock.Lock()
+		if inner.Err() == nil {
+			res.err = context.DeadlineExceeded
+		}
+		cancel()
+		res.lock.Unlock()
+	})
+	return res, cancel
+}
+
+//a context whose deadline is measured on a clock other than RealClock
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	lock     sync.Mutex
+	//set when the deadline is reached before the context is done
+	err error
+}
+
+func (this *clockContext) Deadline() (time.Time, bool) {
+	return this.deadline, true
+}
+
+func (this *clockContext) Err() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.err != nil {
+		return this.err
+	}
+	return this.Context.Err()
+}
@@ -0,0 +1,67 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+)
+
+//errors shared by the chord and kademlia nodes, use errors.Is to check them
+var (
+	ErrNotFound  = errors.New("dht: key not found")
+	ErrNotJoined = errors.New("dht: node is not in a network")
+	ErrTimeout   = errors.New("dht: operation timed out")
+	ErrNoRoute   = errors.New("dht: no route to node")
+	//returned by CompareAndSwap when the stored version is not the expected one
+	ErrVersionMismatch = errors.New("dht: version mismatch")
+	//returned for a call which is not signed with the cluster key, or is replayed
+	ErrUnauthenticated = errors.New("dht: unauthenticated call")
+)
+
+var sentinels = []error{ErrNotFound, ErrNotJoined, ErrTimeout, ErrNoRoute, ErrVersionMismatch, ErrUnauthenticated}
+
+//FromRemote turns an error which went across the rpc boundary back into the
+//sentinel error it was made from. Other errors are returned unchanged.
+func FromRemote(err error) error {
+	serverErr, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	msg := string(serverErr)
+	for _, sentinel := range sentinels {
+		if msg == sentinel.Error() {
+			return sentinel
+		}
+		if strings.HasPrefix(msg, sentinel.Error()+":") {
+			return fmt.Errorf("%w%s", sentinel, strings.TrimPrefix(msg, sentinel.Error()))
+		}
+	}
+	return err
+}
+
+//RemoteError classifies the error of a remote call made with ctx:
+//errors returned by the remote method keep their meaning, a passed deadline
+//becomes ErrTimeout and a node which can not be reached becomes ErrNoRoute.
+func RemoteError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	res := FromRemote(err)
+	for _, sentinel := range sentinels {
+		if errors.Is(res, sentinel) {
+			return res
+		}
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrNoRoute, err)
+}
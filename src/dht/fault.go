@@ -0,0 +1,488 @@
+package dht
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+//FaultNetwork wraps a transport, usually a MemoryNetwork, and injects faults into the calls
+//between nodes: it drops or duplicates a share of the calls, delays them, drops or delays
+//a share of the replies, and partitions the nodes into groups which can not reach each
+//other until Heal. The random choices come
+//from a seeded source, so a test scripting the same faults sees the same choices.
+//
+//The faults are applied to the requests on the connections a node dials, which are split
+//into rpc calls by the gob messages. A dropped call fails as if the connection were reset,
+//so a call without deadline never hangs, and like after any reset the pool tries a call
+//on a reused connection once more. A dropped reply fails the connection in the same way,
+//but after the call was executed, as when a node crashes before it answers. A reply
+//is also lost when the nodes are partitioned by the time it comes back. A connection
+//using TLS can not be split, there every write counts as a call, every read as a reply,
+//and nothing is duplicated.
+type FaultNetwork struct {
+	inner Transport
+	lock  sync.Mutex
+	rand  *rand.Rand
+	//shares of the calls which are dropped and duplicated
+	drop      float64
+	duplicate float64
+	latency   Latency
+	//share of the replies which are dropped, and the delay of the others
+	replyDrop    float64
+	replyLatency Latency
+	//group of each partitioned address, an address in no group reaches every node
+	groups map[string]int
+	//the calls are delayed on it
+	clock Clock
+}
+
+//Latency draws the delay of a call from r.
+type Latency func(r *rand.Rand) time.Duration
+
+func FixedLatency(d time.Duration) Latency {
+	return func(_ *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+//UniformLatency draws the delay from [min, max).
+func UniformLatency(min time.Duration, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+//NormalLatency draws the delay from a normal distribution, a negative one becomes 0.
+func NormalLatency(mean time.Duration, stddev time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		res := mean + time.Duration(r.NormFloat64()*float64(stddev))
+		if res < 0 {
+			return 0
+		}
+		return res
+	}
+}
+
+var ErrDropped = errors.New("dht: call dropped")
+var ErrPartitioned = errors.New("dht: node is partitioned away")
+
+//SourceTransport is a Transport which tells the dialing nodes apart, such as a FaultNetwork.
+type SourceTransport interface {
+	Transport
+	//DialFrom connects to address for the node listening on from.
+	DialFrom(from string, address string, timeout time.Duration) (net.Conn, error)
+}
+
+//From returns the transport the node listening on from dials with,
+//which is t itself unless t is a SourceTransport.
+func From(t Transport, from string) Transport {
+	source, ok := t.(SourceTransport)
+	if !ok || from == "" {
+		return t
+	}
+	return sourceTransport{source, from}
+}
+
+type sourceTransport struct {
+	SourceTransport
+	from string
+}
+
+func (this sourceTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return this.DialFrom(this.from, address, timeout)
+}
+
+func NewFaultNetwork(inner Transport, seed int64) *FaultNetwork {
+	return &FaultNetwork{inner: inner, rand: rand.New(rand.NewSource(seed)), groups: make(map[string]int), clock: RealClock}
+}
+
+//SetClock delays the calls on c, such as the virtual time of a simulator, nil means RealClock.
+func (this *FaultNetwork) SetClock(c Clock) {
+	if c == nil {
+		c = RealClock
+	}
+	this.lock.Lock()
+	this.clock = c
+	this.lock.Unlock()
+}
+
+//SetDrop drops the given share of the calls, 0 drops none.
+func (this *FaultNetwork) SetDrop(rate float64) {
+	this.lock.Lock()
+	this.drop = rate
+	this.lock.Unlock()
+}
+
+//SetDuplicate delivers the given share of the calls twice, 0 duplicates none.
+func (this *FaultNetwork) SetDuplicate(rate float64) {
+	this.lock.Lock()
+	this.duplicate = rate
+	this.lock.Unlock()
+}
+
+//SetLatency delays every call by a draw from latency, nil means no delay.
+func (this *FaultNetwork) SetLatency(latency Latency) {
+	this.lock.Lock()
+	this.latency = latency
+	this.lock.Unlock()
+}
+
+//SetReplyDrop drops the given share of the replies after their calls are executed, 0 drops none.
+func (this *FaultNetwork) SetReplyDrop(rate float64) {
+	this.lock.Lock()
+	this.replyDrop = rate
+	this.lock.Unlock()
+}
+
+//SetReplyLatency delays every reply by a draw from latency, nil means no delay.
+func (this *FaultNetwork) SetReplyLatency(latency Latency) {
+	this.lock.Lock()
+	this.replyLatency = latency
+	this.lock.Unlock()
+}
+
+//Partition splits the addresses into groups, a call between two groups fails.
+//It replaces the groups of an earlier Partition.
+func (this *FaultNetwork) Partition(groups ...[]string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			this.groups[address] = i + 1
+		}
+	}
+}
+
+//Heal removes the partitions, and keeps the other faults.
+func (this *FaultNetwork) Heal() {
+	this.Partition()
+}
+
+//Reset removes all faults.
+func (this *FaultNetwork) Reset() {
+	this.Heal()
+	this.SetDrop(0)
+	this.SetDuplicate(0)
+	this.SetLatency(nil)
+	this.SetReplyDrop(0)
+	this.SetReplyLatency(nil)
+}
+
+func (this *FaultNetwork) Listen(address string) (net.Listener, error) {
+	return this.inner.Listen(address)
+}
+
+//Dial connects for a caller which is not a node, it is in no group.
+func (this *FaultNetwork) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return this.DialFrom("", address, timeout)
+}
+
+func (this *FaultNetwork) DialFrom(from string, address string, timeout time.Duration) (net.Conn, error) {
+	if this.partitioned(from, address) {
+		return nil, &net.OpError{Op: "dial", Net: "fault", Addr: memAddr(address), Err: ErrPartitioned}
+	}
+	conn, tmp_err := this.inner.Dial(address, timeout)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	return &faultConn{Conn: conn, network: this, from: from, to: address}, nil
+}
+
+func (this *FaultNetwork) partitioned(from string, to string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fromGroup, toGroup := this.groups[from], this.groups[to]
+	return fromGroup != 0 && toGroup != 0 && fromGroup != toGroup
+}
+
+//what happens to a call or a reply
+type fault struct {
+	err       error
+	delay     time.Duration
+	duplicate bool
+	clock     Clock
+}
+
+func (this *FaultNetwork) next_fault(from string, to string) fault {
+	partitioned := this.partitioned(from, to)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	res := fault{clock: this.clock}
+	if partitioned {
+		res.err = ErrPartitioned
+		return res
+	}
+	if this.drop > 0 && this.rand.Float64() < this.drop {
+		res.err = ErrDropped
+		return res
+	}
+	if this.latency != nil {
+		res.delay = this.latency(this.rand)
+	}
+	res.duplicate = this.duplicate > 0 && this.rand.Float64() < this.duplicate
+	return res
+}
+
+//the fault of a reply from to, which is never duplicated
+func (this *FaultNetwork) reply_fault(from string, to string) fault {
+	partitioned := this.partitioned(from, to)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	res := fault{clock: this.clock}
+	if partitioned {
+		res.err = ErrPartitioned
+		return res
+	}
+	if this.replyDrop > 0 && this.rand.Float64() < this.replyDrop {
+		res.err = ErrDropped
+		return res
+	}
+	if this.replyLatency != nil {
+		res.delay = this.replyLatency(this.rand)
+	}
+	return res
+}
+
+//gobSplitter splits a gob stream into rpc messages, which are a header and a body
+type gobSplitter struct {
+	//bytes of the message being read
+	pending []byte
+	//end of the gob messages of pending which are split already
+	parsed int
+	//the gob values in pending, the type definitions among them
+	//are sent once and never duplicated
+	values [][]byte
+}
+
+//add appends p to the stream and returns the whole rpc messages in it,
+//and for each of them its values without the type definitions
+func (this *gobSplitter) add(p []byte) ([][]byte, [][]byte) {
+	var messages, agains [][]byte
+	this.pending = append(this.pending, p...)
+	for {
+		size, value, ok := gob_message(this.pending[this.parsed:])
+		if !ok {
+			return messages, agains
+		}
+		if value {
+			this.values = append(this.values, this.pending[this.parsed:this.parsed+size])
+		}
+		this.parsed += size
+		if len(this.values) < 2 {
+			continue
+		}
+		var again []byte
+		for _, message := range this.values {
+			again = append(again, message...)
+		}
+		messages = append(messages, this.pending[:this.parsed])
+		agains = append(agains, again)
+		this.pending = append([]byte(nil), this.pending[this.parsed:]...)
+		this.parsed, this.values = 0, nil
+	}
+}
+
+//a call written to a faultConn
+type call struct {
+	data []byte
+	//the part of data which is sent again if it is duplicated
+	again []byte
+	fault fault
+	//when it is written, never before the calls ahead of it
+	at time.Time
+	//closed when the call ahead of it is written, and by it when it is written
+	ahead <-chan struct{}
+	done  chan struct{}
+}
+
+//a connection dialed through a FaultNetwork, the requests written to it are held
+//until a whole call is written, then the call is dropped, delayed or duplicated,
+//and the replies read from it are dropped or delayed in the same way
+type faultConn struct {
+	net.Conn
+	network *FaultNetwork
+	from    string
+	to      string
+
+	lock     sync.Mutex
+	requests gobSplitter
+	//the connection uses TLS, so a write is a call and a read is a reply
+	raw     bool
+	checked bool
+	broken  error
+	//the time the last call is written at, and closed when it is written
+	last     time.Time
+	lastDone chan struct{}
+
+	//the replies are read by one goroutine, as the rpc client does
+	replies gobSplitter
+	//bytes of the replies which are let through
+	ready     []byte
+	readError error
+}
+
+func (this *faultConn) Write(p []byte) (int, error) {
+	calls, res := this.split(p)
+	//every call is let through, so the calls after it get their turn
+	for _, c := range calls {
+		tmp_err := this.deliver(c)
+		if res == nil {
+			res = tmp_err
+		}
+	}
+	if res != nil {
+		return 0, res
+	}
+	return len(p), nil
+}
+
+//split p into the whole calls written so far and give each its fault
+func (this *faultConn) split(p []byte) ([]call, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.broken != nil {
+		return nil, this.broken
+	}
+	if !this.checked && len(p) >= 2 {
+		this.checked = true
+		//a TLS handshake record, never the first message of a gob stream
+		this.raw = p[0] == 0x16 && p[1] == 0x03
+	}
+	var datas, agains [][]byte
+	if this.raw {
+		datas, agains = [][]byte{append([]byte(nil), p...)}, [][]byte{nil}
+	} else {
+		datas, agains = this.requests.add(p)
+	}
+	var res []call
+	for i := range datas {
+		c := call{data: datas[i], again: agains[i], fault: this.network.next_fault(this.from, this.to), ahead: this.lastDone, done: make(chan struct{})}
+		c.at = c.fault.clock.Now().Add(c.fault.delay)
+		if c.at.Before(this.last) {
+			c.at = this.last
+		}
+		this.last, this.lastDone = c.at, c.done
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+//send a call when its delay is over and the call ahead of it is written,
+//the delay is waited without the lock so the calls after it are split meanwhile
+func (this *faultConn) deliver(c call) error {
+	defer close(c.done)
+	wait := c.at.Sub(c.fault.clock.Now())
+	if wait > 0 {
+		c.fault.clock.Sleep(wait)
+	}
+	if c.ahead != nil {
+		<-c.ahead
+	}
+	this.lock.Lock()
+	if this.broken == nil && c.fault.err != nil {
+		this.broken = &net.OpError{Op: "write", Net: "fault", Addr: memAddr(this.to), Err: c.fault.err}
+		this.Conn.Close()
+	}
+	broken := this.broken
+	this.lock.Unlock()
+	if broken != nil {
+		return broken
+	}
+	_, tmp_err := this.Conn.Write(c.data)
+	if tmp_err == nil && c.fault.duplicate && len(c.again) > 0 {
+		_, tmp_err = this.Conn.Write(c.again)
+	}
+	if tmp_err != nil {
+		this.lock.Lock()
+		if this.broken == nil {
+			this.broken = tmp_err
+		}
+		this.lock.Unlock()
+	}
+	return tmp_err
+}
+
+//Read lets the replies through once they are read whole, a dropped reply
+//fails the connection after the call was executed, as if it were reset
+func (this *faultConn) Read(p []byte) (int, error) {
+	for len(this.ready) == 0 {
+		if this.readError != nil {
+			return 0, this.readError
+		}
+		size, tmp_err := this.Conn.Read(p)
+		if size == 0 && tmp_err == nil {
+			continue
+		}
+		this.lock.Lock()
+		raw := this.raw
+		this.lock.Unlock()
+		var replies [][]byte
+		if raw {
+			replies = [][]byte{append([]byte(nil), p[:size]...)}
+		} else {
+			replies, _ = this.replies.add(p[:size])
+		}
+		for _, reply := range replies {
+			fault := this.network.reply_fault(this.to, this.from)
+			if fault.err != nil {
+				this.readError = &net.OpError{Op: "read", Net: "fault", Addr: memAddr(this.to), Err: fault.err}
+				this.Conn.Close()
+				break
+			}
+			if fault.delay > 0 {
+				fault.clock.Sleep(fault.delay)
+			}
+			this.ready = append(this.ready, reply...)
+		}
+		if tmp_err != nil && this.readError == nil {
+			this.readError = tmp_err
+		}
+	}
+	size := copy(p, this.ready)
+	this.ready = this.ready[size:]
+	return size, nil
+}
+
+//gob_message splits the first message of a gob stream off buf: its size with the
+//length prefix, and whether it is a value rather than a type definition.
+//ok is false if buf does not hold a whole message yet.
+func gob_message(buf []byte) (int, bool, bool) {
+	length, prefix, ok := gob_uint(buf)
+	if !ok || uint64(len(buf)-prefix) < length {
+		return 0, false, false
+	}
+	id, _, ok := gob_uint(buf[prefix:])
+	if !ok {
+		return 0, false, false
+	}
+	//the type id is a signed integer with the sign in the lowest bit,
+	//a type definition carries the negated id
+	return prefix + int(length), id&1 == 0, true
+}
+
+//gob_uint decodes an unsigned integer of gob, which is one byte below 0x80,
+//or the negated count of the big-endian bytes following it
+func gob_uint(buf []byte) (uint64, int, bool) {
+	if len(buf) == 0 {
+		return 0, 0, false
+	}
+	if buf[0] < 0x80 {
+		return uint64(buf[0]), 1, true
+	}
+	count := int(-int8(buf[0]))
+	if count > 8 || len(buf) < 1+count {
+		return 0, 0, false
+	}
+	var res uint64
+	for _, b := range buf[1 : 1+count] {
+		res = res<<8 | uint64(b)
+	}
+	return res, 1 + count, true
+}
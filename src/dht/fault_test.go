@@ -0,0 +1,243 @@
+package dht_test
+
+import (
+	"dht"
+	"errors"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+const faultServer = "server:1"
+
+type Counter struct {
+	lock  sync.Mutex
+	calls int
+	//called by Add before it answers
+	onAdd func()
+}
+
+func (this *Counter) Add(n int, res *int) error {
+	this.lock.Lock()
+	this.calls += n
+	*res = this.calls
+	onAdd := this.onAdd
+	this.lock.Unlock()
+	if onAdd != nil {
+		onAdd()
+	}
+	return nil
+}
+
+func (this *Counter) count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.calls
+}
+
+//serve_fault serves a Counter at faultServer behind a new FaultNetwork
+func serve_fault(t *testing.T) (*dht.FaultNetwork, *Counter) {
+	t.Helper()
+	network := dht.NewFaultNetwork(dht.NewMemoryNetwork(), 1)
+	counter := new(Counter)
+	rpcServer := rpc.NewServer()
+	tmp_err := rpcServer.Register(counter)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	listener, tmp_err := network.Listen(faultServer)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	go func() {
+		for {
+			conn, tmp_err := listener.Accept()
+			if tmp_err != nil {
+				return
+			}
+			go rpcServer.ServeConn(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return network, counter
+}
+
+//dial_fault connects to faultServer for the node at from
+func dial_fault(t *testing.T, network *dht.FaultNetwork, from string) (*rpc.Client, error) {
+	t.Helper()
+	conn, tmp_err := network.DialFrom(from, faultServer, time.Second)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	client := rpc.NewClient(conn)
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+func TestFaultNetwork(t *testing.T) {
+	t.Run("Drop", func(t *testing.T) {
+		network, counter := serve_fault(t)
+		network.SetDrop(1)
+		client, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err != nil {
+			t.Fatal(tmp_err)
+		}
+		var res int
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if !errors.Is(tmp_err, dht.ErrDropped) {
+			t.Errorf("a dropped call: %v, want ErrDropped", tmp_err)
+		}
+		if counter.count() != 0 {
+			t.Errorf("a dropped call is executed")
+		}
+
+		network.SetDrop(0)
+		client, tmp_err = dial_fault(t, network, "a:1")
+		if tmp_err == nil {
+			tmp_err = client.Call("Counter.Add", 1, &res)
+		}
+		if tmp_err != nil || res != 1 {
+			t.Errorf("a call after the drops stop = %d, %v", res, tmp_err)
+		}
+	})
+
+	t.Run("ReplyDrop", func(t *testing.T) {
+		network, counter := serve_fault(t)
+		network.SetReplyDrop(1)
+		client, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err != nil {
+			t.Fatal(tmp_err)
+		}
+		var res int
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if !errors.Is(tmp_err, dht.ErrDropped) {
+			t.Errorf("a call whose reply is dropped: %v, want ErrDropped", tmp_err)
+		}
+		//the call was executed all the same
+		if counter.count() != 1 {
+			t.Errorf("%d calls are executed, want 1", counter.count())
+		}
+	})
+
+	t.Run("Partition", func(t *testing.T) {
+		network, counter := serve_fault(t)
+		before, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err != nil {
+			t.Fatal(tmp_err)
+		}
+		network.Partition([]string{"a:1"}, []string{faultServer})
+		_, tmp_err = dial_fault(t, network, "a:1")
+		if !errors.Is(tmp_err, dht.ErrPartitioned) {
+			t.Errorf("dial across the partition: %v, want ErrPartitioned", tmp_err)
+		}
+		var res int
+		tmp_err = before.Call("Counter.Add", 1, &res)
+		if !errors.Is(tmp_err, dht.ErrPartitioned) {
+			t.Errorf("a call across the partition on an open connection: %v, want ErrPartitioned", tmp_err)
+		}
+		if counter.count() != 0 {
+			t.Errorf("a call across the partition is executed")
+		}
+		//a node in no group reaches every node
+		other, tmp_err := dial_fault(t, network, "b:1")
+		if tmp_err == nil {
+			tmp_err = other.Call("Counter.Add", 1, &res)
+		}
+		if tmp_err != nil {
+			t.Errorf("a call from outside the groups: %v", tmp_err)
+		}
+
+		network.Heal()
+		client, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err == nil {
+			tmp_err = client.Call("Counter.Add", 1, &res)
+		}
+		if tmp_err != nil || res != 2 {
+			t.Fatalf("a call after Heal = %d, %v", res, tmp_err)
+		}
+
+		//the partition comes while the call is executed, so its reply is lost
+		counter.lock.Lock()
+		counter.onAdd = func() { network.Partition([]string{"a:1"}, []string{faultServer}) }
+		counter.lock.Unlock()
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if !errors.Is(tmp_err, dht.ErrPartitioned) {
+			t.Errorf("a call whose reply crosses the partition: %v, want ErrPartitioned", tmp_err)
+		}
+		if counter.count() != 3 {
+			t.Errorf("%d calls are executed, want 3", counter.count())
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		network, counter := serve_fault(t)
+		network.SetDuplicate(1)
+		client, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err != nil {
+			t.Fatal(tmp_err)
+		}
+		var res int
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if tmp_err != nil {
+			t.Fatalf("a duplicated call: %v", tmp_err)
+		}
+		//the copy is executed by itself, and its reply is thrown away
+		for wait := 0; wait < 100 && counter.count() < 2; wait++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if counter.count() != 2 {
+			t.Errorf("a duplicated call is executed %d times, want 2", counter.count())
+		}
+		network.SetDuplicate(0)
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if tmp_err != nil || res != 3 {
+			t.Errorf("a call on the same connection = %d, %v, want 3", res, tmp_err)
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		const delay = 30 * time.Millisecond
+		network, counter := serve_fault(t)
+		client, tmp_err := dial_fault(t, network, "a:1")
+		if tmp_err != nil {
+			t.Fatal(tmp_err)
+		}
+		var res int
+		network.SetLatency(dht.FixedLatency(delay))
+		start := time.Now()
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if took := time.Since(start); tmp_err != nil || took < delay {
+			t.Errorf("a delayed call took %v, %v, want %v", took, tmp_err, delay)
+		}
+		network.SetReplyLatency(dht.FixedLatency(delay))
+		start = time.Now()
+		tmp_err = client.Call("Counter.Add", 1, &res)
+		if took := time.Since(start); tmp_err != nil || took < 2*delay {
+			t.Errorf("a call with a delayed reply took %v, %v, want %v", took, tmp_err, 2*delay)
+		}
+
+		//calls delayed differently on one connection keep their order on it
+		network.SetLatency(dht.UniformLatency(0, delay))
+		network.SetReplyLatency(dht.UniformLatency(0, delay))
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var res int
+				errs[i] = client.Call("Counter.Add", 1, &res)
+			}(i)
+		}
+		wg.Wait()
+		for i, tmp_err := range errs {
+			if tmp_err != nil {
+				t.Errorf("concurrent call %d: %v", i, tmp_err)
+			}
+		}
+		if counter.count() != 2+len(errs) {
+			t.Errorf("%d calls are executed, want %d", counter.count(), 2+len(errs))
+		}
+	})
+}
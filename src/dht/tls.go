@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+//a certificate issued by NewCA or CA.Issue is valid for this long
+const certValidFor = 10 * 365 * 24 * time.Hour
+
+//MutualTLS returns a config for both ends of a connection inside a cluster:
+//it presents cert, and only trusts a peer whose certificate is signed by a CA in cas.
+func MutualTLS(cert tls.Certificate, cas *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      cas,
+		ClientCAs:    cas,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+//LoadMutualTLS reads a PEM certificate, its key and the PEM certificates of the cluster CA,
+//and returns the config of MutualTLS.
+func LoadMutualTLS(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	cert, tmp_err := tls.LoadX509KeyPair(certFile, keyFile)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	caPEM, tmp_err := ioutil.ReadFile(caFile)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificate is found in " + caFile)
+	}
+	return MutualTLS(cert, cas), nil
+}
+
+//Listen listens on address over t, TCP if t is nil, the accepted connections use TLS if conf is not nil.
+func Listen(t Transport, address string, conf *tls.Config) (net.Listener, error) {
+	if t == nil {
+		t = TCP
+	}
+	lis, tmp_err := t.Listen(address)
+	if tmp_err != nil || conf == nil {
+		return lis, tmp_err
+	}
+	return tls.NewListener(lis, conf), nil
+}
+
+//Dial connects to address over t, TCP if t is nil, and finishes the TLS handshake if conf is not nil.
+//The server is verified against the host of address unless conf sets ServerName.
+func Dial(t Transport, address string, conf *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if t == nil {
+		t = TCP
+	}
+	conn, tmp_err := t.Dial(address, timeout)
+	if tmp_err != nil || conf == nil {
+		return conn, tmp_err
+	}
+	if conf.ServerName == "" {
+		host, _, tmp_err := net.SplitHostPort(address)
+		if tmp_err != nil {
+			conn.Close()
+			return nil, tmp_err
+		}
+		conf = conf.Clone()
+		conf.ServerName = host
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	tlsConn := tls.Client(conn, conf)
+	tmp_err = tlsConn.Handshake()
+	if tmp_err != nil {
+		conn.Close()
+		return nil, tmp_err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+//CA is a self-signed certificate authority, it is used to issue the certificates
+//of a test cluster without any file.
+type CA struct {
+	Cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+//NewCA creates a CA with a new key.
+func NewCA(name string) (*CA, error) {
+	key, tmp_err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	template, tmp_err := certTemplate(name)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, tmp_err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	cert, tmp_err := x509.ParseCertificate(der)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	return &CA{Cert: cert, key: key}, nil
+}
+
+//Pool returns a pool only holding the certificate of this CA.
+func (this *CA) Pool() *x509.CertPool {
+	res := x509.NewCertPool()
+	res.AddCert(this.Cert)
+	return res
+}
+
+//Issue creates a certificate signed by this CA for both server and client use.
+//Each of hosts is an IP address or a host name the certificate is valid for.
+func (this *CA) Issue(hosts ...string) (tls.Certificate, error) {
+	key, tmp_err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if tmp_err != nil {
+		return tls.Certificate{}, tmp_err
+	}
+	name := ""
+	if len(hosts) > 0 {
+		name = hosts[0]
+	}
+	template, tmp_err := certTemplate(name)
+	if tmp_err != nil {
+		return tls.Certificate{}, tmp_err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, tmp_err := x509.CreateCertificate(rand.Reader, template, this.Cert, &key.PublicKey, this.key)
+	if tmp_err != nil {
+		return tls.Certificate{}, tmp_err
+	}
+	leaf, tmp_err := x509.ParseCertificate(der)
+	if tmp_err != nil {
+		return tls.Certificate{}, tmp_err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func certTemplate(name string) (*x509.Certificate, error) {
+	serial, tmp_err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidFor),
+	}, nil
+}
@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+//Transport carries the connections the rpc calls between nodes run over.
+//Addresses are "host:port" for every transport.
+type Transport interface {
+	//Listen accepts the connections to address.
+	Listen(address string) (net.Listener, error)
+	//Dial connects to address, a timeout of 0 means no timeout.
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+type tcpTransport struct{}
+
+//TCP is the transport over the network, used when no transport is set.
+var TCP Transport = tcpTransport{}
+
+func (tcpTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+func (tcpTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+var ErrConnRefused = errors.New("dht: connection refused")
+var ErrAddrInUse = errors.New("dht: address already in use")
+
+//MemoryNetwork is a transport inside one process, a connection is a net.Pipe handed
+//to the listener through a channel. It lets many nodes run in a test without sockets.
+type MemoryNetwork struct {
+	listeners map[string]*memListener
+	lock      sync.Mutex
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*memListener)}
+}
+
+func (this *MemoryNetwork) Listen(address string) (net.Listener, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.listeners[address]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "memory", Addr: memAddr(address), Err: ErrAddrInUse}
+	}
+	res := &memListener{network: this, address: address, conns: make(chan net.Conn), closed: make(chan struct{})}
+	this.listeners[address] = res
+	return res, nil
+}
+
+func (this *MemoryNetwork) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	this.lock.Lock()
+	lis, ok := this.listeners[address]
+	this.lock.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memAddr(address), Err: ErrConnRefused}
+	}
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+	server, client := net.Pipe()
+	select {
+	case lis.conns <- server:
+		return client, nil
+	case <-lis.closed:
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memAddr(address), Err: ErrConnRefused}
+	case <-expire:
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memAddr(address), Err: ErrTimeout}
+	}
+}
+
+type memListener struct {
+	network   *MemoryNetwork
+	address   string
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (this *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-this.conns:
+		return conn, nil
+	case <-this.closed:
+		return nil, &net.OpError{Op: "accept", Net: "memory", Addr: memAddr(this.address), Err: errors.New("use of closed network connection")}
+	}
+}
+
+//Close frees the address, the accepted connections stay open.
+func (this *memListener) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+		this.network.lock.Lock()
+		if this.network.listeners[this.address] == this {
+			delete(this.network.listeners, this.address)
+		}
+		this.network.lock.Unlock()
+	})
+	return nil
+}
+
+func (this *memListener) Addr() net.Addr {
+	return memAddr(this.address)
+}
+
+type memAddr string
+
+func (memAddr) Network() string {
+	return "memory"
+}
+
+func (this memAddr) String() string {
+	return string(this)
+}
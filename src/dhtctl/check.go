@@ -0,0 +1,56 @@
+package main
+
+import (
+	"chord"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+func checkCommand(args []string) error {
+	var common commonFlags
+	set := flag.NewFlagSet("check", flag.ExitOnError)
+	common.register(set)
+	repair := set.Bool("repair", false, "ask the offending nodes to repair themselves")
+	jsonPath := set.String("json", "", "file for the report in JSON, - for stdout")
+	total := set.Duration("total", time.Minute, "how long the whole check may take")
+	set.Parse(args)
+	tmp_err := common.check()
+	if tmp_err != nil {
+		return tmp_err
+	}
+	if common.protocol != "chord" {
+		return fmt.Errorf("check only knows the chord ring")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *total)
+	defer cancel()
+	report, tmp_err := chord.CheckRing(ctx, common.seed, *repair)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	fmt.Printf("%d nodes in the ring from %s\n", len(report.Nodes), common.seed)
+	for _, violation := range report.Violations {
+		fmt.Printf("[%s] %s: %s\n", violation.Kind, violation.Node, violation.Detail)
+	}
+	for _, repair := range report.Repairs {
+		fmt.Println(repair)
+	}
+	if *jsonPath != "" {
+		tmp_err = writeOutput(*jsonPath, func(w io.Writer) error {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		})
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	if len(report.Violations) > 0 {
+		return fmt.Errorf("%d violations found", len(report.Violations))
+	}
+	fmt.Println("ring is consistent")
+	return nil
+}
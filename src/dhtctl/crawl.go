@@ -0,0 +1,115 @@
+package main
+
+import (
+	"chord"
+	"context"
+	"kademlia"
+	"sync"
+	"time"
+)
+
+//number of nodes asked at the same time
+const crawlWorkers = 16
+
+//crawl asks every node reachable from seed for its state, next returns the
+//addresses a node points to. The result has an entry for every address met,
+//with a nil state if the node did not answer.
+func crawl(seed string, maxNodes int, fetch func(addr string) (interface{}, error), next func(state interface{}) []string) map[string]interface{} {
+	res := make(map[string]interface{})
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan bool, crawlWorkers)
+	var visit func(addr string)
+	visit = func(addr string) {
+		defer wg.Done()
+		slots <- true
+		state, tmp_err := fetch(addr)
+		<-slots
+		if tmp_err != nil {
+			return
+		}
+		lock.Lock()
+		res[addr] = state
+		var todo []string
+		for _, aim := range next(state) {
+			if _, ok := res[aim]; ok || aim == "" || len(res) >= maxNodes {
+				continue
+			}
+			res[aim] = nil
+			todo = append(todo, aim)
+		}
+		lock.Unlock()
+		for _, aim := range todo {
+			wg.Add(1)
+			go visit(aim)
+		}
+	}
+	res[seed] = nil
+	wg.Add(1)
+	visit(seed)
+	wg.Wait()
+	return res
+}
+
+func fetchChord(timeout time.Duration) func(addr string) (interface{}, error) {
+	return func(addr string) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return chord.FetchDebugState(ctx, addr)
+	}
+}
+
+func nextChord(state interface{}) []string {
+	st := state.(chord.DebugState)
+	res := []string{st.Predecessor}
+	res = append(res, st.SuccessorList[:]...)
+	for _, finger := range st.Fingers {
+		res = append(res, finger.Address)
+	}
+	return res
+}
+
+func fetchKademlia(timeout time.Duration) func(addr string) (interface{}, error) {
+	return func(addr string) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return kademlia.FetchDebugState(ctx, addr)
+	}
+}
+
+func nextKademlia(state interface{}) []string {
+	var res []string
+	for _, bucket := range state.(kademlia.DebugState).Buckets {
+		for _, contact := range bucket.Contacts {
+			res = append(res, contact.Addr.Ip)
+		}
+	}
+	return res
+}
+
+//crawlChord returns the states of the chord nodes which answered and the addresses which did not
+func crawlChord(seed string, maxNodes int, timeout time.Duration) (map[string]chord.DebugState, []string) {
+	states := make(map[string]chord.DebugState)
+	var dead []string
+	for addr, state := range crawl(seed, maxNodes, fetchChord(timeout), nextChord) {
+		if state == nil {
+			dead = append(dead, addr)
+		} else {
+			states[addr] = state.(chord.DebugState)
+		}
+	}
+	return states, dead
+}
+
+func crawlKademlia(seed string, maxNodes int, timeout time.Duration) (map[string]kademlia.DebugState, []string) {
+	states := make(map[string]kademlia.DebugState)
+	var dead []string
+	for addr, state := range crawl(seed, maxNodes, fetchKademlia(timeout), nextKademlia) {
+		if state == nil {
+			dead = append(dead, addr)
+		} else {
+			states[addr] = state.(kademlia.DebugState)
+		}
+	}
+	return states, dead
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"chord"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"kademlia"
+	"os"
+	"sort"
+	"strings"
+)
+
+type TopologyNode struct {
+	Address     string
+	ID          string
+	Alive       bool
+	Predecessor string   `json:",omitempty"`
+	Successors  []string `json:",omitempty"`
+	DataSize    int
+	BackupSize  int      `json:",omitempty"`
+	Problems    []string `json:",omitempty"`
+}
+
+type TopologyEdge struct {
+	From string
+	To   string
+	//"successor" or "finger" for chord, "contact" for kademlia
+	Kind string
+	//index of the k-bucket holding the contact
+	Bucket int `json:",omitempty"`
+	//a successor which is not the next node in the ring, or a node which does not answer
+	Broken bool `json:",omitempty"`
+}
+
+//Topology is what the crawler saw, the nodes are in ring order for chord
+type Topology struct {
+	Protocol string
+	Seed     string
+	Nodes    []TopologyNode
+	Edges    []TopologyEdge
+}
+
+func graphCommand(args []string) error {
+	var common commonFlags
+	set := flag.NewFlagSet("graph", flag.ExitOnError)
+	common.register(set)
+	dotPath := set.String("dot", "topology.dot", "file for the DOT graph, - for stdout, empty to skip")
+	jsonPath := set.String("json", "topology.json", "file for the JSON topology, - for stdout, empty to skip")
+	maxNodes := set.Int("max", 10000, "stop crawling after this many nodes")
+	fingers := set.Bool("fingers", true, "draw the finger pointers of chord nodes")
+	set.Parse(args)
+	tmp_err := common.check()
+	if tmp_err != nil {
+		return tmp_err
+	}
+	var topo Topology
+	if common.protocol == "chord" {
+		states, dead := crawlChord(common.seed, *maxNodes, common.timeout)
+		topo = chordTopology(common.seed, states, dead, *fingers)
+	} else {
+		states, dead := crawlKademlia(common.seed, *maxNodes, common.timeout)
+		topo = kademliaTopology(common.seed, states, dead)
+	}
+	if *dotPath != "" {
+		tmp_err = writeOutput(*dotPath, func(w io.Writer) error {
+			return writeDot(w, topo)
+		})
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	if *jsonPath != "" {
+		tmp_err = writeOutput(*jsonPath, func(w io.Writer) error {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(topo)
+		})
+		if tmp_err != nil {
+			return tmp_err
+		}
+	}
+	broken := 0
+	for _, node := range topo.Nodes {
+		if len(node.Problems) > 0 {
+			broken++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d nodes, %d edges, %d nodes with problems\n", len(topo.Nodes), len(topo.Edges), broken)
+	return nil
+}
+
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	var builder strings.Builder
+	tmp_err := write(&builder)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	return ioutil.WriteFile(path, []byte(builder.String()), 0644)
+}
+
+//sort the answered chord nodes by ID, so the successor of each one should be the next one
+func chordRing(states map[string]chord.DebugState) []string {
+	var ring []string
+	for addr := range states {
+		ring = append(ring, addr)
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return states[ring[i]].ID.Cmp(states[ring[j]].ID) < 0
+	})
+	return ring
+}
+
+func chordTopology(seed string, states map[string]chord.DebugState, dead []string, fingers bool) Topology {
+	topo := Topology{Protocol: "chord", Seed: seed}
+	isDead := make(map[string]bool)
+	for _, addr := range dead {
+		isDead[addr] = true
+	}
+	ring := chordRing(states)
+	for i, addr := range ring {
+		state := states[addr]
+		node := TopologyNode{Address: addr, ID: fmt.Sprintf("%040x", state.ID), Alive: true, Predecessor: state.Predecessor,
+			DataSize: state.DataSize, BackupSize: state.BackupSize}
+		for _, succ := range state.SuccessorList {
+			if succ != "" {
+				node.Successors = append(node.Successors, succ)
+			}
+		}
+		expectSucc := ring[(i+1)%len(ring)]
+		expectPre := ring[(i+len(ring)-1)%len(ring)]
+		succ := state.SuccessorList[0]
+		switch {
+		case !state.Running:
+			node.Problems = append(node.Problems, "node is not running")
+		case succ == "":
+			node.Problems = append(node.Problems, "node has no successor")
+		case isDead[succ]:
+			node.Problems = append(node.Problems, fmt.Sprintf("successor %s does not answer", succ))
+		case succ != expectSucc:
+			node.Problems = append(node.Problems, fmt.Sprintf("successor is %s but the next node in the ring is %s", succ, expectSucc))
+		}
+		if state.Predecessor != expectPre {
+			node.Problems = append(node.Problems, fmt.Sprintf("predecessor is %q but the previous node in the ring is %s", state.Predecessor, expectPre))
+		}
+		topo.Nodes = append(topo.Nodes, node)
+		if succ != "" {
+			topo.Edges = append(topo.Edges, TopologyEdge{From: addr, To: succ, Kind: "successor", Broken: succ != expectSucc})
+		}
+		if !fingers {
+			continue
+		}
+		drawn := map[string]bool{succ: true, addr: true}
+		for _, finger := range state.Fingers {
+			if drawn[finger.Address] {
+				continue
+			}
+			drawn[finger.Address] = true
+			topo.Edges = append(topo.Edges, TopologyEdge{From: addr, To: finger.Address, Kind: "finger", Broken: isDead[finger.Address]})
+		}
+	}
+	sort.Strings(dead)
+	for _, addr := range dead {
+		topo.Nodes = append(topo.Nodes, TopologyNode{Address: addr, Problems: []string{"node does not answer"}})
+	}
+	return topo
+}
+
+func kademliaTopology(seed string, states map[string]kademlia.DebugState, dead []string) Topology {
+	topo := Topology{Protocol: "kademlia", Seed: seed}
+	isDead := make(map[string]bool)
+	for _, addr := range dead {
+		isDead[addr] = true
+	}
+	var addrs []string
+	for addr := range states {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		state := states[addr]
+		node := TopologyNode{Address: addr, ID: state.Addr.Id.Text(16), Alive: true, DataSize: state.DataSize}
+		if !state.Running {
+			node.Problems = append(node.Problems, "node is not running")
+		}
+		if len(state.Buckets) == 0 {
+			node.Problems = append(node.Problems, "node has no contact")
+		}
+		for _, bucket := range state.Buckets {
+			for _, contact := range bucket.Contacts {
+				topo.Edges = append(topo.Edges, TopologyEdge{From: addr, To: contact.Addr.Ip, Kind: "contact", Bucket: bucket.Index, Broken: isDead[contact.Addr.Ip]})
+			}
+		}
+		topo.Nodes = append(topo.Nodes, node)
+	}
+	sort.Strings(dead)
+	for _, addr := range dead {
+		topo.Nodes = append(topo.Nodes, TopologyNode{Address: addr, Problems: []string{"node does not answer"}})
+	}
+	return topo
+}
+
+func writeDot(w io.Writer, topo Topology) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "digraph %s {\n", topo.Protocol)
+	if topo.Protocol == "chord" {
+		builder.WriteString("\tlayout=circo;\n")
+	}
+	builder.WriteString("\tnode [shape=box, fontsize=10];\n")
+	for _, node := range topo.Nodes {
+		label := node.Address
+		if node.Alive {
+			id := node.ID
+			if len(id) > 8 {
+				id = id[:8]
+			}
+			label += fmt.Sprintf("\\nid %s\\nkeys %d", id, node.DataSize)
+			if topo.Protocol == "chord" {
+				label += fmt.Sprintf(" backup %d", node.BackupSize)
+			}
+		}
+		//the label keeps its \\n line breaks for dot
+		attrs := fmt.Sprintf("label=\"%s\"", label)
+		if !node.Alive {
+			attrs += ", color=red, style=dashed"
+		} else if len(node.Problems) > 0 {
+			attrs += fmt.Sprintf(", color=red, penwidth=2, tooltip=%q", strings.Join(node.Problems, "; "))
+		}
+		fmt.Fprintf(&builder, "\t%q [%s];\n", node.Address, attrs)
+	}
+	for _, edge := range topo.Edges {
+		var attrs []string
+		switch edge.Kind {
+		case "finger":
+			attrs = append(attrs, "style=dashed", "constraint=false")
+			if !edge.Broken {
+				attrs = append(attrs, "color=gray")
+			}
+		case "contact":
+			attrs = append(attrs, fmt.Sprintf("label=%d", edge.Bucket), "fontsize=8")
+		}
+		if edge.Broken {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(&builder, "\t%q -> %q;\n", edge.From, edge.To)
+		} else {
+			fmt.Fprintf(&builder, "\t%q -> %q [%s];\n", edge.From, edge.To, strings.Join(attrs, ", "))
+		}
+	}
+	builder.WriteString("}\n")
+	_, tmp_err := io.WriteString(w, builder.String())
+	return tmp_err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"chord"
+	"dht"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"kademlia"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"sim"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const graphSize = 6
+
+//graph_addrs are the addresses of the nodes of a test network
+func graph_addrs(host string) []string {
+	var res []string
+	for i := 0; i < graphSize; i++ {
+		res = append(res, dht.JoinAddress(host, 20000+i))
+	}
+	return res
+}
+
+//run_graph writes the DOT and JSON output of graph for the network at seed,
+//and returns the DOT graph and the decoded topology
+func run_graph(t *testing.T, protocol string, seed string) (string, Topology) {
+	t.Helper()
+	dir := t.TempDir()
+	dotPath := filepath.Join(dir, "topology.dot")
+	jsonPath := filepath.Join(dir, "topology.json")
+	tmp_err := graphCommand([]string{"-proto", protocol, "-seed", seed, "-dot", dotPath, "-json", jsonPath})
+	if tmp_err != nil {
+		t.Fatalf("graph: %v", tmp_err)
+	}
+	dot, tmp_err := ioutil.ReadFile(dotPath)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	data, tmp_err := ioutil.ReadFile(jsonPath)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	var topo Topology
+	tmp_err = json.Unmarshal(data, &topo)
+	if tmp_err != nil {
+		t.Fatalf("decode the JSON topology: %v", tmp_err)
+	}
+	return string(dot), topo
+}
+
+func sort_edges(edges []TopologyEdge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+}
+
+func TestGraphChord(t *testing.T) {
+	addrs := graph_addrs("chord")
+	s := sim.New(1)
+	conf := chord.DefaultConfig()
+	conf.AdvertiseAddress = "chord"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var joinErr error
+	var ring []*chord.ChordNode
+	s.Run(func() {
+		for i := range addrs {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(20000+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			if tmp_err := node.Join(addrs[0]); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		//time for fix_fingerTable to go over every finger twice
+		s.Sleep(80 * time.Second)
+	})
+	//the clock stands still out of Run, so the nodes answer the crawl as they are now
+	defer s.Run(func() {
+		for _, node := range ring {
+			node.ForceQuit()
+		}
+	})
+	if joinErr != nil {
+		t.Fatalf("join: %v", joinErr)
+	}
+	chord.SetTransport(conf.Transport)
+	defer chord.SetTransport(nil)
+
+	sort.Slice(addrs, func(i, j int) bool {
+		return chord.NodeID(addrs[i]).Cmp(chord.NodeID(addrs[j])) < 0
+	})
+	mod := new(big.Int).Lsh(big.NewInt(1), 160)
+	want := Topology{Protocol: "chord", Seed: addrs[0]}
+	for i, addr := range addrs {
+		node := TopologyNode{Address: addr, ID: fmt.Sprintf("%040x", chord.NodeID(addr)), Alive: true,
+			Predecessor: addrs[(i+graphSize-1)%graphSize]}
+		for j := 1; j < graphSize; j++ {
+			node.Successors = append(node.Successors, addrs[(i+j)%graphSize])
+		}
+		want.Nodes = append(want.Nodes, node)
+		succ := addrs[(i+1)%graphSize]
+		want.Edges = append(want.Edges, TopologyEdge{From: addr, To: succ, Kind: "successor"})
+		//a finger edge for each other node a finger points to, in the order of the fingers
+		drawn := map[string]bool{addr: true, succ: true}
+		for j := 0; j < 160; j++ {
+			start := new(big.Int).Add(chord.NodeID(addr), new(big.Int).Lsh(big.NewInt(1), uint(j)))
+			start.Mod(start, mod)
+			finger := addrs[0]
+			for _, other := range addrs {
+				if chord.NodeID(other).Cmp(start) >= 0 {
+					finger = other
+					break
+				}
+			}
+			if !drawn[finger] {
+				drawn[finger] = true
+				want.Edges = append(want.Edges, TopologyEdge{From: addr, To: finger, Kind: "finger"})
+			}
+		}
+	}
+
+	dot, topo := run_graph(t, "chord", addrs[0])
+	if !reflect.DeepEqual(topo, want) {
+		t.Errorf("JSON topology:\n%+v\nwant\n%+v", topo, want)
+	}
+	if strings.Contains(dot, "color=red") {
+		t.Errorf("the DOT graph of a consistent ring marks a problem:\n%s", dot)
+	}
+	for _, edge := range want.Edges {
+		line := fmt.Sprintf("\t%q -> %q;\n", edge.From, edge.To)
+		if edge.Kind == "finger" {
+			line = fmt.Sprintf("\t%q -> %q [style=dashed, constraint=false, color=gray];\n", edge.From, edge.To)
+		}
+		if !strings.Contains(dot, line) {
+			t.Errorf("the DOT graph has no %s edge %s -> %s", edge.Kind, edge.From, edge.To)
+		}
+	}
+	if lines := strings.Count(dot, " -> "); lines != len(want.Edges) {
+		t.Errorf("the DOT graph has %d edges, want %d", lines, len(want.Edges))
+	}
+	for _, node := range want.Nodes {
+		if !strings.Contains(dot, fmt.Sprintf("\t%q [label=\"%s\\nid %s\\nkeys 0 backup 0\"];\n", node.Address, node.Address, node.ID[:8])) {
+			t.Errorf("the DOT graph has no node %s", node.Address)
+		}
+	}
+
+	//check walks the same ring and finds it consistent
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	tmp_err := checkCommand([]string{"-seed", addrs[0], "-json", reportPath})
+	if tmp_err != nil {
+		t.Fatalf("check: %v", tmp_err)
+	}
+	data, tmp_err := ioutil.ReadFile(reportPath)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	var report chord.RingReport
+	tmp_err = json.Unmarshal(data, &report)
+	if tmp_err != nil {
+		t.Fatalf("decode the report: %v", tmp_err)
+	}
+	if !reflect.DeepEqual(report.Nodes, addrs) || len(report.Violations) > 0 || len(report.Repairs) > 0 {
+		t.Errorf("report of check = %+v, want the nodes %v and nothing else", report, addrs)
+	}
+}
+
+func TestGraphKademlia(t *testing.T) {
+	addrs := graph_addrs("kademlia")
+	s := sim.New(1)
+	conf := kademlia.DefaultConfig()
+	conf.AdvertiseAddress = "kademlia"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var joinErr error
+	var nodes []*kademlia.KadNode
+	s.Run(func() {
+		for i := range addrs {
+			node := new(kademlia.KadNode)
+			node.InitWithConfig(20000+i, conf)
+			node.Run()
+			nodes = append(nodes, node)
+		}
+		nodes[0].Create()
+		for _, node := range nodes[1:] {
+			if tmp_err := node.Join(addrs[0]); tmp_err != nil && joinErr == nil {
+				joinErr = tmp_err
+			}
+		}
+		s.Sleep(time.Second)
+	})
+	defer s.Run(func() {
+		for _, node := range nodes {
+			node.ForceQuit()
+		}
+	})
+	if joinErr != nil {
+		t.Fatalf("join: %v", joinErr)
+	}
+	kademlia.SetTransport(conf.Transport)
+	defer kademlia.SetTransport(nil)
+
+	//fewer nodes than K, so every node has every other one in the bucket of its distance
+	sort.Strings(addrs)
+	want := Topology{Protocol: "kademlia", Seed: addrs[0]}
+	for _, addr := range addrs {
+		id := kademlia.Hash(addr)
+		want.Nodes = append(want.Nodes, TopologyNode{Address: addr, ID: id.Text(16), Alive: true})
+		for _, other := range addrs {
+			if other == addr {
+				continue
+			}
+			otherID := kademlia.Hash(other)
+			bucket := new(big.Int).Xor(&id, &otherID).BitLen() - 1
+			want.Edges = append(want.Edges, TopologyEdge{From: addr, To: other, Kind: "contact", Bucket: bucket})
+		}
+	}
+
+	dot, topo := run_graph(t, "kademlia", addrs[0])
+	//the contacts of a bucket are in the order they were met
+	sort_edges(topo.Edges)
+	sort_edges(want.Edges)
+	if !reflect.DeepEqual(topo, want) {
+		t.Errorf("JSON topology:\n%+v\nwant\n%+v", topo, want)
+	}
+	if strings.Contains(dot, "color=red") {
+		t.Errorf("the DOT graph of a sound network marks a problem:\n%s", dot)
+	}
+	for _, edge := range want.Edges {
+		if !strings.Contains(dot, fmt.Sprintf("\t%q -> %q [label=%d, fontsize=8];\n", edge.From, edge.To, edge.Bucket)) {
+			t.Errorf("the DOT graph has no contact %s -> %s in bucket %d", edge.From, edge.To, edge.Bucket)
+		}
+	}
+	if lines := strings.Count(dot, " -> "); lines != len(want.Edges) {
+		t.Errorf("the DOT graph has %d edges, want %d", lines, len(want.Edges))
+	}
+}
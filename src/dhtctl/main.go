@@ -0,0 +1,98 @@
+package main
+
+import (
+	"chord"
+	"dht"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"kademlia"
+	"os"
+	"time"
+)
+
+//dhtctl is a tool to look into a running network, it talks to the nodes with
+//the same rpc as the nodes use between themselves.
+
+var commands = map[string]func(args []string) error{
+	"graph": graphCommand,
+	"check": checkCommand,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dhtctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  graph    crawl a network from a seed and write a DOT graph and a JSON topology")
+	fmt.Fprintln(os.Stderr, "  check    check the invariants of a chord ring and repair it if asked")
+	fmt.Fprintln(os.Stderr, "run \"dhtctl <command> -h\" for the flags of a command")
+}
+
+//flags every command has
+type commonFlags struct {
+	protocol string
+	seed     string
+	timeout  time.Duration
+	verbose  bool
+	certFile string
+	keyFile  string
+	caFile   string
+	keyPath  string
+}
+
+func (this *commonFlags) register(set *flag.FlagSet) {
+	set.StringVar(&this.protocol, "proto", "chord", "protocol of the network: chord or kademlia")
+	set.StringVar(&this.seed, "seed", "", "address of a node in the network, such as 192.168.1.2:20000")
+	set.DurationVar(&this.timeout, "timeout", 2*time.Second, "how long to wait for a node")
+	set.BoolVar(&this.verbose, "v", false, "print the logs of the rpc layer")
+	set.StringVar(&this.certFile, "cert", "", "PEM certificate to call a network using TLS, signed by the cluster CA")
+	set.StringVar(&this.keyFile, "key", "", "PEM key of -cert")
+	set.StringVar(&this.caFile, "ca", "", "PEM certificate of the cluster CA")
+	set.StringVar(&this.keyPath, "cluster-key", "", "file whose whole content is the cluster key to sign the calls with")
+}
+
+func (this *commonFlags) check() error {
+	if this.seed == "" {
+		return fmt.Errorf("-seed is needed")
+	}
+	if this.protocol != "chord" && this.protocol != "kademlia" {
+		return fmt.Errorf("unknown protocol %q", this.protocol)
+	}
+	if !this.verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	if this.certFile != "" || this.keyFile != "" || this.caFile != "" {
+		conf, tmp_err := dht.LoadMutualTLS(this.certFile, this.keyFile, this.caFile)
+		if tmp_err != nil {
+			return fmt.Errorf("load TLS config: %v", tmp_err)
+		}
+		chord.SetClientTLS(conf)
+		kademlia.SetClientTLS(conf)
+	}
+	if this.keyPath != "" {
+		key, tmp_err := ioutil.ReadFile(this.keyPath)
+		if tmp_err != nil {
+			return fmt.Errorf("read cluster key: %v", tmp_err)
+		}
+		chord.SetClusterKey(key)
+		kademlia.SetClusterKey(key)
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	tmp_err := command(os.Args[2:])
+	if tmp_err != nil {
+		fmt.Fprintln(os.Stderr, "dhtctl:", tmp_err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,150 @@
+package kademlia
+
+import (
+	"dht"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"merkle"
+)
+
+//the peers which can compare their pairs with a node at once
+const merkleRounds = 64
+
+//returned by MerkleNodes when the tree of a round is forgotten before its last round trip
+var errUnknownRound = errors.New("merkle tree of the round is not kept")
+
+//MerkleArg asks for hashes of the Merkle tree over the pairs of Keys a node holds.
+//Keys are only sent in the first round trip of a comparison, at level 0, and the
+//later ones with the same Round are answered from the tree built then.
+type MerkleArg struct {
+	Round   uint64
+	Keys    []string
+	Depth   int
+	Level   int
+	Indexes []int
+	Sender  AddrType
+}
+
+type StoreBatchArg struct {
+	Pairs  map[string]string
+	Sender AddrType
+}
+
+type RefreshArg struct {
+	Keys   []string
+	Sender AddrType
+}
+
+//KeyTree builds the Merkle tree over the pairs of keys held here, a missing key is left out.
+func (this *DataType) KeyTree(keys []string, depth int) *merkle.Tree {
+	this.lock.RLock()
+	digests := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if value, ok := this.hashMap[key]; ok {
+			digests[key] = merkle.Digest(value)
+		}
+	}
+	this.lock.RUnlock()
+	return merkle.Build(depth, digests)
+}
+
+//the tree of the comparison arg belongs to, built from the keys of its first round trip
+func (this *KadNode) merkle_tree(arg *MerkleArg) (*merkle.Tree, error) {
+	id := fmt.Sprint(arg.Sender.Ip, ":", arg.Round)
+	if arg.Level == 0 {
+		tree := this.data.KeyTree(arg.Keys, arg.Depth)
+		this.merkleTrees.Put(id, tree)
+		return tree, nil
+	}
+	tree := this.merkleTrees.Get(id)
+	if tree == nil {
+		return nil, errUnknownRound
+	}
+	return tree, nil
+}
+
+//Refresh restarts the expiry of the pairs of keys held here, as AddPair with the same value does.
+func (this *DataType) Refresh(keys []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.clock.Now()
+	for _, key := range keys {
+		if _, ok := this.hashMap[key]; ok {
+			this.validTime[key] = now.Add(ExpiredTime)
+			this.republishTime[key] = now.Add(NeedRepublicTime)
+		}
+	}
+}
+
+//store the pairs of keys again on the K closest nodes of each key. The keys are grouped
+//by node and compared with a Merkle tree, so only the pairs a node lacks or holds
+//differently are sent, and the others are only refreshed.
+func (this *KadNode) republish(keys []string, data map[string]string) {
+	peers := make(map[string][]string)
+	var own []string
+	for _, key := range keys {
+		keyID := Hash(key)
+		closestList := this.NodeLookup(&keyID)
+		closestList.Insert(this.address)
+		for i := 0; i < closestList.Size; i++ {
+			if closestList.List[i].Ip == this.address.Ip {
+				own = append(own, key)
+			} else {
+				peers[closestList.List[i].Ip] = append(peers[closestList.List[i].Ip], key)
+			}
+		}
+	}
+	//this node is still one of the closest, keep its own pairs alive
+	this.data.Refresh(own)
+	for addr, peerKeys := range peers {
+		tmp_err := this.sync_peer(addr, peerKeys, data)
+		if tmp_err != nil {
+			log.Errorln("[Error] in function republish can not sync with", addr, "because", tmp_err)
+		}
+	}
+}
+
+func (this *KadNode) sync_peer(addr string, keys []string, data map[string]string) error {
+	digests := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		digests[key] = merkle.Digest(data[key])
+	}
+	tree := merkle.Build(merkle.DefaultDepth, digests)
+	round := merkle.NewRound()
+	leaves, tmp_err := tree.Diff(func(level int, indexes []int) ([][]byte, error) {
+		var res [][]byte
+		arg := MerkleArg{Round: round, Depth: tree.Depth(), Level: level, Indexes: indexes, Sender: this.address}
+		if level == 0 {
+			arg.Keys = keys
+		}
+		tmp_err := this.call(addr, "WrapNode.MerkleNodes", &arg, &res)
+		return res, tmp_err
+	})
+	if tmp_err != nil {
+		return dht.FromRemote(tmp_err)
+	}
+	store := StoreBatchArg{Pairs: make(map[string]string), Sender: this.address}
+	refresh := RefreshArg{Sender: this.address}
+	for _, key := range keys {
+		if merkle.Contains(leaves, key, tree.Depth()) {
+			store.Pairs[key] = data[key]
+		} else {
+			refresh.Keys = append(refresh.Keys, key)
+		}
+	}
+	var o string
+	if len(store.Pairs) > 0 {
+		tmp_err = this.call(addr, "WrapNode.AddPairs", &store, &o)
+		if tmp_err != nil {
+			return dht.FromRemote(tmp_err)
+		}
+	}
+	if len(refresh.Keys) > 0 {
+		tmp_err = this.call(addr, "WrapNode.Refresh", &refresh, &o)
+		if tmp_err != nil {
+			return dht.FromRemote(tmp_err)
+		}
+	}
+	return nil
+}
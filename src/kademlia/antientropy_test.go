@@ -0,0 +1,52 @@
+package kademlia_test
+
+import (
+	"bytes"
+	"dht"
+	"kademlia"
+	"merkle"
+	"testing"
+)
+
+func TestMerkleRounds(t *testing.T) {
+	conf := memory_config()
+	nodes := start_network(t, conf, 21300, 2)
+	tmp_err := nodes[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	kademlia.SetTransport(conf.Transport)
+	defer kademlia.SetTransport(nil)
+	addr := dht.JoinAddress(conf.AdvertiseAddress, 21300)
+	sender := dht.JoinAddress(conf.AdvertiseAddress, 21301)
+	want := merkle.Build(merkle.DefaultDepth, map[string][]byte{"key": merkle.Digest("value")})
+	leaves := []int{merkle.Bucket("key", merkle.DefaultDepth), merkle.Bucket("other", merkle.DefaultDepth)}
+
+	//the keys come with the first round trip only
+	arg := kademlia.MerkleArg{Round: 1, Keys: []string{"key", "missing"}, Depth: merkle.DefaultDepth, Level: 0, Indexes: []int{0},
+		Sender: kademlia.AddrType{Ip: sender, Id: kademlia.Hash(sender)}}
+	var res [][]byte
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.MerkleNodes", &arg, &res)
+	if tmp_err != nil || len(res) != 1 || !bytes.Equal(res[0], want.Root()) {
+		t.Fatalf("root = %x, %v, want %x", res, tmp_err, want.Root())
+	}
+	arg.Keys = nil
+	arg.Level = merkle.DefaultDepth
+	arg.Indexes = leaves
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.MerkleNodes", &arg, &res)
+	if tmp_err != nil {
+		t.Fatalf("leaves of the round: %v", tmp_err)
+	}
+	for i, hash := range want.Nodes(merkle.DefaultDepth, leaves) {
+		if !bytes.Equal(res[i], hash) {
+			t.Errorf("leaf %d = %x, want %x", leaves[i], res[i], hash)
+		}
+	}
+
+	//a round which did not start has no keys to build a tree of
+	arg.Round = 2
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.MerkleNodes", &arg, &res)
+	if tmp_err == nil {
+		t.Errorf("leaves of a round which did not start are answered")
+	}
+}
@@ -0,0 +1,37 @@
+package kademlia
+
+import (
+	"crypto/tls"
+	"dht"
+)
+
+//Config is used to set up a KadNode in InitWithConfig.
+type Config struct {
+	//address other nodes reach this node by, the ID is hashed from it. It is a host name,
+	//an IPv4 or IPv6 address, the port of InitWithConfig is added if it has no port,
+	//and the local address is detected if it is empty
+	AdvertiseAddress string
+	//address the listener binds, in the same form, AdvertiseAddress is used if it is empty
+	BindAddress string
+	//serve the metrics in the Prometheus text format at http://MetricsAddress/metrics,
+	//such as "127.0.0.1:9100", they are not served if it is empty
+	MetricsAddress string
+	//carries the rpc calls, such as a dht.MemoryNetwork for a test in one process,
+	//dht.TCP if it is nil. The node calls other nodes over it as well
+	Transport dht.Transport
+	//serve and call rpc over TLS with mutual authentication, such as the config of
+	//dht.LoadMutualTLS. The node calls other nodes with it as well, so nodes of
+	//different clusters can run in one process
+	TLS *tls.Config
+	//pre-shared key of the cluster, every call is signed with it and a call
+	//not signed with it is rejected
+	ClusterKey []byte
+	//time of RePublish and of the expiry of the pairs, such as a sim.Simulator running
+	//the network in virtual time, dht.RealClock if it is nil. It is also used to wait
+	//between the tries to reach another node
+	Clock dht.Clock
+}
+
+func DefaultConfig() Config {
+	return Config{}
+}
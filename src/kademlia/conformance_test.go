@@ -0,0 +1,28 @@
+package kademlia_test
+
+import (
+	"conformance"
+	"dht"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"kademlia"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	conf := kademlia.DefaultConfig()
+	conf.AdvertiseAddress = "kademlia"
+	conf.Transport = dht.NewMemoryNetwork()
+	scenarios := conformance.DefaultConfig()
+	//a pair is kept by the K closest nodes and Quit hands nothing over, so a pair
+	//is only sure to outlive the quits while K nodes are left until RePublish;
+	//the network grows by K, so as many nodes quit as in the scenario of chord
+	scenarios.QASRemain = kademlia.K
+	scenarios.QASNodes = conformance.DefaultConfig().QASNodes + kademlia.K
+	conformance.Run(t, func(port int) (conformance.Node, string) {
+		node := new(kademlia.KadNode)
+		node.InitWithConfig(port, conf)
+		return node, dht.JoinAddress(conf.AdvertiseAddress, port)
+	}, scenarios)
+}
@@ -0,0 +1,70 @@
+package kademlia_test
+
+import (
+	"context"
+	"dht"
+	"errors"
+	"kademlia"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	conf := memory_config()
+	network := dht.NewFaultNetwork(conf.Transport, 1)
+	conf.Transport = network
+	nodes := start_network(t, conf, 21400, 4)
+	tmp_err := nodes[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tmp_err := nodes[1].PutContext(ctx, "other", "value")
+		if !errors.Is(tmp_err, context.Canceled) {
+			t.Errorf("put with a cancelled context: %v, want context.Canceled", tmp_err)
+		}
+		_, tmp_err = nodes[1].GetContext(ctx, "missing")
+		if !errors.Is(tmp_err, context.Canceled) {
+			t.Errorf("get with a cancelled context: %v, want context.Canceled", tmp_err)
+		}
+		id := kademlia.Hash("missing")
+		if found := nodes[1].NodeLookupContext(ctx, &id); found.Size != 0 {
+			t.Errorf("a lookup with a cancelled context found %d nodes", found.Size)
+		}
+	})
+
+	//every reply takes longer than the deadline, so the first call of the lookup
+	//already runs out of time and nothing else is tried
+	t.Run("Deadline", func(t *testing.T) {
+		const deadline = 100 * time.Millisecond
+		network.SetReplyLatency(dht.FixedLatency(3 * deadline))
+		defer network.Reset()
+		for _, op := range []struct {
+			name string
+			run  func(ctx context.Context) error
+		}{
+			{"get", func(ctx context.Context) error {
+				_, tmp_err := nodes[1].GetContext(ctx, "missing")
+				return tmp_err
+			}},
+			{"put", func(ctx context.Context) error {
+				return nodes[1].PutContext(ctx, "other", "value")
+			}},
+		} {
+			ctx, cancel := context.WithTimeout(context.Background(), deadline)
+			start := time.Now()
+			tmp_err := op.run(ctx)
+			elapsed := time.Since(start)
+			cancel()
+			if !errors.Is(tmp_err, dht.ErrTimeout) {
+				t.Errorf("%s past the deadline: %v, want ErrTimeout", op.name, tmp_err)
+			}
+			if elapsed > 2*deadline {
+				t.Errorf("%s took %v with a deadline of %v", op.name, elapsed, deadline)
+			}
+		}
+	})
+}
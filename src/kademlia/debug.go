@@ -0,0 +1,54 @@
+package kademlia
+
+import (
+	"context"
+	"dht"
+	"time"
+)
+
+type ContactState struct {
+	Addr     AddrType
+	LastSeen time.Time
+}
+
+type BucketState struct {
+	//contacts in the bucket are at a distance in [2^Index, 2^(Index+1)) from this node
+	Index    int
+	Contacts []ContactState
+}
+
+//DebugState is the routing state of a KadNode, only the non-empty buckets are in it.
+type DebugState struct {
+	Addr     AddrType
+	Running  bool
+	Buckets  []BucketState
+	DataSize int
+}
+
+//DebugState returns the routing state of this node.
+func (this *KadNode) DebugState() DebugState {
+	res := DebugState{Addr: this.address, Running: this.conRoutineFlag}
+	for i := 0; i < M; i++ {
+		bucket := &this.routeTable[i]
+		bucket.mux.Lock()
+		if bucket.size > 0 {
+			state := BucketState{Index: i}
+			for j := 0; j < bucket.size; j++ {
+				state.Contacts = append(state.Contacts, ContactState{bucket.bucket[j], bucket.lastSeen[j]})
+			}
+			res.Buckets = append(res.Buckets, state)
+		}
+		bucket.mux.Unlock()
+	}
+	this.data.lock.RLock()
+	res.DataSize = len(this.data.hashMap)
+	this.data.lock.RUnlock()
+	return res
+}
+
+//FetchDebugState asks the node addr for its routing state.
+func FetchDebugState(ctx context.Context, addr string) (DebugState, error) {
+	var res DebugState
+	tmp_err := RemoteCallContext(ctx, addr, "WrapNode.DebugState", 0, &res)
+	return res, dht.RemoteError(ctx, tmp_err)
+}
@@ -0,0 +1,59 @@
+package kademlia_test
+
+import (
+	"dht"
+	"kademlia"
+	"math/big"
+	"testing"
+)
+
+func TestDebugState(t *testing.T) {
+	//fewer nodes than K, so every node keeps every other one
+	const size = 6
+	conf := memory_config()
+	nodes := start_network(t, conf, 21600, size)
+	tmp_err := nodes[1].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	addrs := make(map[string]bool)
+	for i := 0; i < size; i++ {
+		addrs[dht.JoinAddress(conf.AdvertiseAddress, 21600+i)] = true
+	}
+	for i, node := range nodes {
+		state := node.DebugState()
+		addr := dht.JoinAddress(conf.AdvertiseAddress, 21600+i)
+		id := kademlia.Hash(addr)
+		if state.Addr.Ip != addr || state.Addr.Id.Cmp(&id) != 0 || !state.Running {
+			t.Errorf("state of %s is %s %s, running %v", addr, state.Addr.Ip, state.Addr.Id.Text(16), state.Running)
+		}
+		if state.DataSize != 1 {
+			t.Errorf("%s holds %d pairs, want 1", addr, state.DataSize)
+		}
+		known := make(map[string]bool)
+		last := -1
+		for _, bucket := range state.Buckets {
+			if bucket.Index <= last || bucket.Index >= kademlia.M || len(bucket.Contacts) == 0 {
+				t.Errorf("bucket %d of %s after bucket %d with %d contacts", bucket.Index, addr, last, len(bucket.Contacts))
+			}
+			last = bucket.Index
+			for _, contact := range bucket.Contacts {
+				//the distance of a contact in bucket i is in [2^i, 2^(i+1))
+				distance := new(big.Int).Xor(&id, &contact.Addr.Id)
+				if distance.BitLen()-1 != bucket.Index {
+					t.Errorf("%s is in bucket %d of %s at distance %s", contact.Addr.Ip, bucket.Index, addr, distance.Text(2))
+				}
+				if known[contact.Addr.Ip] || !addrs[contact.Addr.Ip] || contact.Addr.Ip == addr {
+					t.Errorf("%s has the contact %s twice, or it is not another node", addr, contact.Addr.Ip)
+				}
+				known[contact.Addr.Ip] = true
+				if contact.LastSeen.IsZero() {
+					t.Errorf("%s has never seen its contact %s", addr, contact.Addr.Ip)
+				}
+			}
+		}
+		if len(known) != size-1 {
+			t.Errorf("%s knows %d nodes, want %d", addr, len(known), size-1)
+		}
+	}
+}
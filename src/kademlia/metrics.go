@@ -0,0 +1,67 @@
+package kademlia
+
+import (
+	log "github.com/sirupsen/logrus"
+	"metrics"
+	"time"
+)
+
+//the metrics of the kademlia nodes in this process are in metrics.Default,
+//a node serves them over http when Config.MetricsAddress is set
+
+var lookupRoundBuckets = []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 15}
+
+func count_sent(method string, failed bool) {
+	metrics.Default.Counter("kademlia_rpc_sent_total", "Rpc calls sent by method.", "method", method).Inc()
+	if failed {
+		metrics.Default.Counter("kademlia_rpc_sent_errors_total", "Rpc calls sent which failed by method.", "method", method).Inc()
+	}
+}
+
+func served_func(address string) metrics.ServedFunc {
+	return func(method string, duration time.Duration, failed bool) {
+		metrics.Default.Counter("kademlia_rpc_served_total", "Rpc calls served by method.", "node", address, "method", method).Inc()
+		if failed {
+			metrics.Default.Counter("kademlia_rpc_served_errors_total", "Rpc calls served with an error by method.", "node", address, "method", method).Inc()
+		}
+		metrics.Default.Histogram("kademlia_rpc_served_seconds", "Time to serve a rpc call.", metrics.DefaultBuckets, "node", address, "method", method).Observe(duration.Seconds())
+	}
+}
+
+func count_dial_failure() {
+	metrics.Default.Counter("kademlia_dial_failures_total", "Connections which could not be dialed in Diag.").Inc()
+}
+
+func observe_lookup(rounds int, duration time.Duration) {
+	metrics.Default.Histogram("kademlia_lookup_rounds", "Rounds of FindNode calls in a node lookup.", lookupRoundBuckets).Observe(float64(rounds))
+	metrics.Default.Histogram("kademlia_lookup_seconds", "Time of a node lookup.", metrics.DefaultBuckets).Observe(duration.Seconds())
+}
+
+func (this *KadNode) count_maintenance(task string) {
+	metrics.Default.Counter("kademlia_maintenance_total", "Iterations of background maintenance tasks.", "node", this.address.Ip, "task", task).Inc()
+}
+
+func (this *KadNode) start_metrics() {
+	metrics.Default.Gauge("kademlia_keys", "Pairs stored in the node.", func() float64 {
+		this.data.lock.RLock()
+		defer this.data.lock.RUnlock()
+		return float64(len(this.data.hashMap))
+	}, "node", this.address.Ip)
+	if this.config.MetricsAddress == "" {
+		return
+	}
+	var tmp_err error
+	this.metricsServer, tmp_err = metrics.Default.Serve(this.config.MetricsAddress)
+	if tmp_err != nil {
+		log.Errorln("In function start_metrics can not serve metrics in", this.config.MetricsAddress, tmp_err)
+	}
+}
+
+func (this *KadNode) stop_metrics() {
+	//the gauge reads the node, so it goes away with it
+	metrics.Default.Unregister("kademlia_keys", "node", this.address.Ip)
+	if this.metricsServer != nil {
+		this.metricsServer.Close()
+		this.metricsServer = nil
+	}
+}
@@ -1,18 +1,73 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/tls"
+	"dht"
 	"errors"
 	log "github.com/sirupsen/logrus"
+	"metrics"
 	"net"
 	"net/rpc"
-	"time"
+	"rpcpool"
+	"sync"
 )
 
+//caller makes the calls of the nodes with the same Config settings, see rpcpool.Callers
+type caller struct {
+	*rpcpool.Caller
+}
+
+//the callers of kademlia, the one of RemoteCall and Ping is set by SetTransport,
+//SetClientTLS, SetClusterKey and SetClock
+var callers = rpcpool.NewCallers(func(c *rpcpool.Caller) rpcpool.DialFunc {
+	return caller{c}.diag
+})
+
+//the caller of a node with conf
+func config_caller(conf Config) caller {
+	return caller{callers.For(rpcpool.Settings{Transport: conf.Transport, TLS: conf.TLS, Key: string(conf.ClusterKey), Clock: conf.Clock})}
+}
+
+func default_caller() caller {
+	return caller{callers.Default()}
+}
+
+//SetClientTLS sets the TLS config RemoteCall and Ping use, nil means plaintext.
+//A node calls others with its own Config, so it is only needed by a tool calling nodes.
+func SetClientTLS(conf *tls.Config) {
+	callers.SetClientTLS(conf)
+}
+
+//SetTransport sets the transport RemoteCall and Ping use, nil means TCP.
+func SetTransport(t dht.Transport) {
+	callers.SetTransport(t)
+}
+
+//SetClock sets the clock of the waits between the tries of RemoteCall and Ping
+//to reach another node, nil means dht.RealClock.
+func SetClock(c dht.Clock) {
+	callers.SetClock(c)
+}
+
+//SetClusterKey sets the key RemoteCall and Ping sign the calls with,
+//nil means they are not signed.
+func SetClusterKey(key []byte) {
+	callers.SetClusterKey(key)
+}
+
 type network struct {
 	serv       *rpc.Server
 	lis        net.Listener
 	nodePtr    *WrapNode
 	QuitSignal chan bool
+	//checks the calls if Config.ClusterKey is set
+	auth *dht.ClusterAuth
+
+	//accepted connections, they are closed in ShutDown so that
+	//the pooled connections of other nodes can not reach a quited node
+	conns    map[net.Conn]bool
+	connLock sync.Mutex
 }
 
 func Accept(ser *rpc.Server, lis net.Listener, ptr *KadNode) {
@@ -27,16 +82,36 @@ func Accept(ser *rpc.Server, lis net.Listener, ptr *KadNode) {
 				log.Print("rpc.Serve: accept:", tmp_err.Error())
 				return
 			}
-			go ser.ServeConn(conn)
+			go ptr.station.serve(conn)
 		}
 	}
 }
 
+func (this *network) serve(conn net.Conn) {
+	this.connLock.Lock()
+	this.conns[conn] = true
+	this.connLock.Unlock()
+	var codec rpc.ServerCodec
+	if this.auth != nil {
+		codec = metrics.WrapServerCodec(this.auth.NewServerCodec(conn, this.nodePtr.node.address.Ip), served_func(this.nodePtr.node.address.Ip))
+	} else {
+		codec = metrics.NewServerCodec(conn, served_func(this.nodePtr.node.address.Ip))
+	}
+	this.serv.ServeCodec(codec)
+	this.connLock.Lock()
+	delete(this.conns, conn)
+	this.connLock.Unlock()
+}
+
 func (this *network) Init(address string, ptr *KadNode) error {
 	this.serv = rpc.NewServer()
 	this.nodePtr = new(WrapNode)
 	this.nodePtr.node = ptr
 	this.QuitSignal = make(chan bool, 2)
+	this.conns = make(map[net.Conn]bool)
+	if ptr.config.ClusterKey != nil {
+		this.auth = dht.NewClusterAuth(ptr.config.ClusterKey)
+	}
 	//register rpc service
 	tmp_err := this.serv.Register(this.nodePtr)
 	if tmp_err != nil {
@@ -44,7 +119,7 @@ func (this *network) Init(address string, ptr *KadNode) error {
 		return tmp_err
 	}
 	//for tcp listen
-	this.lis, tmp_err = net.Listen("tcp", address)
+	this.lis, tmp_err = dht.Listen(this.nodePtr.node.config.Transport, address, this.nodePtr.node.config.TLS)
 	if tmp_err != nil {
 		log.Errorf("[error] tcp error!")
 		return tmp_err
@@ -53,28 +128,17 @@ func (this *network) Init(address string, ptr *KadNode) error {
 	return nil
 }
 
+//GetClient dials addr with the settings of RemoteCall, waiting waitTime for each try.
 func GetClient(addr string) (*rpc.Client, error) {
-	var res *rpc.Client
-	var err error
-	errCh := make(chan error)
-	for i := 0; i < 5; i++ {
-		go func() {
-			res, err = rpc.Dial("tcp", addr)
-			errCh <- err
-		}()
-		select {
-		case <-errCh:
-			if err == nil {
-				return res, err
-			} else {
-				return nil, err
-			}
-		case <-time.After(waitTime):
-			log.Errorln("In function GetClient time out in" + addr)
-			err = errors.New("Time out!")
-		}
+	return default_caller().get_client(addr)
+}
+
+func (this caller) get_client(addr string) (*rpc.Client, error) {
+	client, tmp_err := this.DialWait(addr, 5, waitTime)
+	if errors.Is(tmp_err, rpcpool.ErrDialTimeout) {
+		log.Errorln("In function GetClient time out in" + addr)
 	}
-	return nil, err
+	return client, tmp_err
 }
 
 func CheckOnline(addr string) bool {
@@ -82,13 +146,41 @@ func CheckOnline(addr string) bool {
 		log.Warningln("In checkonline the addr is nil")
 		return false
 	}
-	cli, _ := GetClient(addr)
-	if cli != nil {
-		defer cli.Close()
-		return true
-	} else {
-		return false
+	return Ping(addr) == nil
+}
+
+//call aimFunc in node addr with a pooled connection
+func RemoteCall(addr string, aimFunc string, input interface{}, res interface{}) error {
+	return RemoteCallContext(context.Background(), addr, aimFunc, input, res)
+}
+
+//RemoteCallContext gives up when ctx is done, res is only written if the call succeeds.
+func RemoteCallContext(ctx context.Context, addr string, aimFunc string, input interface{}, res interface{}) error {
+	return default_caller().remote_call(ctx, "", addr, aimFunc, input, res)
+}
+
+//call is RemoteCall made by this node
+func (this *KadNode) call(addr string, aimFunc string, input interface{}, res interface{}) error {
+	return this.caller.remote_call(context.Background(), this.address.Ip, addr, aimFunc, input, res)
+}
+
+func (this *KadNode) call_context(ctx context.Context, addr string, aimFunc string, input interface{}, res interface{}) error {
+	return this.caller.remote_call(ctx, this.address.Ip, addr, aimFunc, input, res)
+}
+
+//ping is Ping made by this node
+func (this *KadNode) ping(ctx context.Context, addr string) error {
+	return this.caller.ping(ctx, this.address.Ip, addr)
+}
+
+//from is the address of the calling node
+func (this caller) remote_call(ctx context.Context, from string, addr string, aimFunc string, input interface{}, res interface{}) error {
+	if addr == "" {
+		return errors.New("[error] Empty IP addr")
 	}
+	tmp_err := this.Pool.CallContext(ctx, this.PoolKey(from, addr), aimFunc, input, res)
+	count_sent(aimFunc, tmp_err != nil)
+	return tmp_err
 }
 
 func (this *network) ShutDown() error {
@@ -97,5 +189,10 @@ func (this *network) ShutDown() error {
 	if tmp_err != nil {
 		log.Errorln("ShutDown error")
 	}
+	this.connLock.Lock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.connLock.Unlock()
 	return tmp_err
 }
@@ -0,0 +1,130 @@
+package kademlia_test
+
+import (
+	"dht"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"kademlia"
+	"testing"
+	"time"
+)
+
+//how long a small network needs to settle after the joins
+const settleWait = 500 * time.Millisecond
+
+//memory_config is the default config on a new MemoryNetwork
+func memory_config() kademlia.Config {
+	log.SetOutput(ioutil.Discard)
+	conf := kademlia.DefaultConfig()
+	conf.AdvertiseAddress = "kademlia"
+	conf.Transport = dht.NewMemoryNetwork()
+	return conf
+}
+
+//start_network runs size nodes with conf on the ports from firstPort, they join
+//through the first one, and the nodes quit when the test ends
+func start_network(t *testing.T, conf kademlia.Config, firstPort int, size int) []*kademlia.KadNode {
+	t.Helper()
+	var nodes []*kademlia.KadNode
+	for i := 0; i < size; i++ {
+		node := new(kademlia.KadNode)
+		node.InitWithConfig(firstPort+i, conf)
+		node.Run()
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.Quit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		tmp_err := nodes[i].Join(dht.JoinAddress(conf.AdvertiseAddress, firstPort))
+		if tmp_err != nil {
+			t.Fatalf("join %d: %v", firstPort+i, tmp_err)
+		}
+	}
+	time.Sleep(settleWait)
+	return nodes
+}
+
+func TestTwoNetworksInOneProcess(t *testing.T) {
+	//the same addresses on two transports are two networks
+	first := start_network(t, memory_config(), 21000, 3)
+	second := start_network(t, memory_config(), 21000, 3)
+	tmp_err := first[1].Put("key", "first")
+	if tmp_err != nil {
+		t.Fatalf("put in the first network: %v", tmp_err)
+	}
+	value, tmp_err := first[2].Get("key")
+	if tmp_err != nil || value != "first" {
+		t.Fatalf("get in the first network = %q, %v", value, tmp_err)
+	}
+	_, tmp_err = second[2].Get("key")
+	if !errors.Is(tmp_err, dht.ErrNotFound) {
+		t.Fatalf("get in the second network: %v, want ErrNotFound", tmp_err)
+	}
+}
+
+func TestUnreachablePeers(t *testing.T) {
+	//the node still knows the others, so it can not tell the key is missing
+	t.Run("Get", func(t *testing.T) {
+		nodes := start_network(t, memory_config(), 21100, 3)
+		nodes[1].ForceQuit()
+		nodes[2].ForceQuit()
+		_, tmp_err := nodes[0].Get("missing")
+		if !errors.Is(tmp_err, dht.ErrNoRoute) && !errors.Is(tmp_err, dht.ErrTimeout) {
+			t.Errorf("get with no peer answering: %v, want ErrNoRoute or ErrTimeout", tmp_err)
+		}
+	})
+	//and a pair only stored locally is lost with the node
+	t.Run("Put", func(t *testing.T) {
+		nodes := start_network(t, memory_config(), 21100, 3)
+		nodes[1].ForceQuit()
+		nodes[2].ForceQuit()
+		tmp_err := nodes[0].Put("key", "value")
+		if !errors.Is(tmp_err, dht.ErrNoRoute) && !errors.Is(tmp_err, dht.ErrTimeout) {
+			t.Errorf("put with no peer answering: %v, want ErrNoRoute or ErrTimeout", tmp_err)
+		}
+	})
+}
+
+func TestSingleNode(t *testing.T) {
+	nodes := start_network(t, memory_config(), 21200, 1)
+	tmp_err := nodes[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put on a single node: %v", tmp_err)
+	}
+	value, tmp_err := nodes[0].Get("key")
+	if tmp_err != nil || value != "value" {
+		t.Errorf("get = %q, %v", value, tmp_err)
+	}
+	_, tmp_err = nodes[0].Get("missing")
+	if !errors.Is(tmp_err, dht.ErrNotFound) {
+		t.Errorf("get of a missing key on a single node: %v, want ErrNotFound", tmp_err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	nodes := start_network(t, memory_config(), 21500, 5)
+	tmp_err := nodes[1].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put: %v", tmp_err)
+	}
+	tmp_err = nodes[3].Delete("key")
+	if tmp_err != nil {
+		t.Fatalf("delete: %v", tmp_err)
+	}
+	//no copy is left on the closest nodes, so none of them finds it
+	for i, node := range nodes {
+		_, tmp_err = node.Get("key")
+		if !errors.Is(tmp_err, dht.ErrNotFound) {
+			t.Errorf("get on node %d after the delete: %v, want ErrNotFound", i, tmp_err)
+		}
+	}
+	tmp_err = nodes[2].Delete("key")
+	if !errors.Is(tmp_err, dht.ErrNotFound) {
+		t.Errorf("delete of a deleted key: %v, want ErrNotFound", tmp_err)
+	}
+}
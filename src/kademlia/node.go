@@ -1,9 +1,12 @@
 package kademlia
 
 import (
-	"fmt"
+	"context"
+	"dht"
 	log "github.com/sirupsen/logrus"
 	"math/big"
+	"merkle"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -20,8 +23,8 @@ type AddrType struct {
 	Id big.Int
 }
 
-func (this *AddrType) addr_init(port int) {
-	this.Ip = fmt.Sprintf("%s:%d", localAddress, port)
+func (this *AddrType) addr_init(address string) {
+	this.Ip = address
 	this.Id = Hash(this.Ip)
 }
 
@@ -30,6 +33,7 @@ type DataType struct {
 	validTime     map[string]time.Time
 	republishTime map[string]time.Time
 	lock          sync.RWMutex
+	clock         dht.Clock
 }
 
 func (this *DataType) data_init() {
@@ -42,21 +46,33 @@ type ClosestList struct {
 	Size     int
 	Standard big.Int
 	List     [K]AddrType
+	//the node which checks the inserted contacts, it is not sent
+	from *KadNode
 }
 
 type KBucketType struct {
-	size   int
-	bucket [K]AddrType
-	mux    sync.Mutex
+	size     int
+	bucket   [K]AddrType
+	lastSeen [K]time.Time
+	mux      sync.Mutex
+	//the node the bucket belongs to, it checks the contacts
+	owner *KadNode
+	clock dht.Clock
 }
 
 type KadNode struct {
 	address        AddrType
+	bindAddress    string
+	config         Config
+	metricsServer  *http.Server
 	data           DataType
 	station        *network
+	caller         caller
 	conRoutineFlag bool
 	routeTable     [M]KBucketType
 	mux            sync.RWMutex
+	//the trees built for the comparisons of the peers, see antientropy.go
+	merkleTrees *merkle.Cache
 }
 
 type FindNodeArg struct {
@@ -76,257 +92,342 @@ type StoreArg struct {
 	Sender AddrType
 }
 
+type DeleteArg struct {
+	Key    string
+	Sender AddrType
+}
+
 type FindValueRet struct {
 	First  ClosestList
 	Second string
 }
 
 func (this *KadNode) Init(port int) {
-	this.address.addr_init(port)
+	this.InitWithConfig(port, DefaultConfig())
+}
+
+func (this *KadNode) InitWithConfig(port int, conf Config) {
+	if conf.Clock == nil {
+		conf.Clock = dht.RealClock
+	}
+	this.config = conf
+	this.address.addr_init(dht.JoinAddress(conf.AdvertiseAddress, port))
+	this.bindAddress = this.address.Ip
+	if conf.BindAddress != "" {
+		this.bindAddress = dht.JoinAddress(conf.BindAddress, port)
+	}
+	this.caller = config_caller(conf)
+	for i := range this.routeTable {
+		this.routeTable[i].owner = this
+		this.routeTable[i].clock = conf.Clock
+	}
+	this.data.clock = conf.Clock
+	this.merkleTrees = merkle.NewCache(merkleRounds)
 	this.reset()
 }
 
 func (this *KadNode) Run() {
 	this.station = new(network)
-	tmp_err := this.station.Init(this.address.Ip, this)
+	tmp_err := this.station.Init(this.bindAddress, this)
 	if tmp_err != nil {
 		log.Errorln("[Run error] can not init station, the node IP is : ", this.address.Ip)
 		return
 	} else {
 		log.Infoln("[Run success] in : ", this.address.Ip)
 		this.conRoutineFlag = true
-		go this.RePublish()
+		this.start_metrics()
+		this.config.Clock.Go(this.RePublish)
 	}
 }
 
-func (this *KadNode) Join(ip string) bool {
+func (this *KadNode) Join(ip string) error {
 	tmpAddr := AddrType{ip, Hash(ip)}
 	this.kBucketUpdate(tmpAddr)
-	client, tmp_err := Diag(ip)
+	var res ClosestList
+	tmp_err := this.call(ip, "WrapNode.FindNode", &FindNodeArg{this.address.Id, this.address}, &res)
 	if tmp_err != nil {
 		log.Errorln("[Diag error] in ", ip)
-	} else {
-		var res ClosestList
-		tmp_err = client.Call("WrapNode.FindNode", &FindNodeArg{this.address.Id, this.address}, &res)
-		for i := 0; i < res.Size; i++ {
-			this.kBucketUpdate(res.List[i])
-		}
-		client.Close()
+		return dht.RemoteError(context.Background(), tmp_err)
+	}
+	for i := 0; i < res.Size; i++ {
+		this.kBucketUpdate(res.List[i])
 	}
 
 	closestlist := this.NodeLookup(&this.address.Id)
 	for i := 0; i < closestlist.Size; i++ {
 		this.kBucketUpdate(closestlist.List[i])
-		client, tmp_err = Diag(closestlist.List[i].Ip)
+		var res ClosestList
+		tmp_err = this.call(closestlist.List[i].Ip, "WrapNode.FindNode", &FindNodeArg{this.address.Id, this.address}, &res)
 		if tmp_err != nil {
-			log.Errorln("[Diag error] in function Join diag error in", closestlist.List[i].Ip)
+			log.Errorln("[Error] remotecall FindNode in Join error", this.address.Ip, "because", tmp_err)
 		} else {
-			var res ClosestList
-			tmp_err = client.Call("WrapNode.FindNode", &FindNodeArg{this.address.Id, this.address}, &res)
-			if tmp_err != nil {
-				log.Errorln("[Error] remotecall FindNode in Join error", this.address.Ip, "because", tmp_err)
-			}
 			for j := 0; j < res.Size; j++ {
 				this.kBucketUpdate(res.List[j])
 			}
-			client.Close()
 		}
 	}
-	return true
+	return nil
 }
 
 func (this *KadNode) Ping(addr string) bool {
-	isOnline := CheckOnline(addr)
+	isOnline := this.ping(context.Background(), addr) == nil
 	return isOnline
 }
 
-func (this *KadNode) Put(key string, value string) bool {
+//Put stores the pair in the K closest nodes to the key, including this node when
+//it is one of them. It succeeds when another node stored the pair, a node which
+//knows no other node only stores it locally.
+func (this *KadNode) Put(key string, value string) error {
+	return this.PutContext(context.Background(), key, value)
+}
+
+//PutContext is like Put, but it stops waiting when ctx is done.
+func (this *KadNode) PutContext(ctx context.Context, key string, value string) error {
+	if !this.conRoutineFlag {
+		return dht.ErrNotJoined
+	}
 	log.Infoln("In Put begin the time is", time.Now())
+	//before the lookup, which only keeps the reachable nodes
+	knowsPeers := this.knows_peers()
 	keyID := Hash(key)
-	closestList := this.NodeLookup(&keyID)
-	closestList.Insert(this.address)
+	closestList := this.NodeLookupContext(ctx, &keyID)
+	if ctx.Err() != nil {
+		return dht.RemoteError(ctx, ctx.Err())
+	}
+	closestList.InsertContext(ctx, this.address)
+	var last_err error = dht.ErrNoRoute
+	stored := 0
+	remote := 0
+	storedLocally := false
 	for i := 0; i < closestList.Size; i++ {
-		client, tmp_err := Diag(closestList.List[i].Ip)
+		if ctx.Err() != nil {
+			log.Errorln("[Error] in function Put", ctx.Err())
+			return dht.RemoteError(ctx, ctx.Err())
+		}
+		var o string
+		isRemote := closestList.List[i].Ip != this.address.Ip
+		if isRemote {
+			remote++
+		}
+		tmp_err := this.call_context(ctx, closestList.List[i].Ip, "WrapNode.AddPair", &StoreArg{key, value, this.address}, &o)
 		if tmp_err != nil {
-			log.Errorln("[Error] in function Put can not diag node aimIp", closestList.List[i].Ip, "because", tmp_err)
+			log.Errorln("[Error] in function Put can not call addpair in", closestList.List[i].Ip, "because", tmp_err)
+			last_err = dht.RemoteError(ctx, tmp_err)
+		} else if isRemote {
+			stored++
 		} else {
-			var o string
-			tmp_err = client.Call("WrapNode.AddPair", &StoreArg{key, value, this.address}, &o)
-			if tmp_err != nil {
-				log.Errorln("[Error] can not call addpair because", tmp_err)
-			}
-			client.Close()
+			storedLocally = true
 		}
 	}
 	log.Infoln("In Put end the time is", time.Now())
-	return true
+	//the local copy alone is lost with this node
+	if stored == 0 && (remote > 0 || !storedLocally) {
+		return last_err
+	}
+	if stored == 0 && knowsPeers {
+		//the known nodes are all unreachable, so none of them is in the closest list
+		return dht.ErrNoRoute
+	}
+	return nil
 }
 
-func (this *KadNode) aPut(key string, value string) bool {
-	//log.Infoln("In RePut begin the time is", time.Now())
-	keyID := Hash(key)
-	closestList := this.NodeLookup(&keyID)
-	closestList.Insert(this.address)
-	for i := 0; i < closestList.Size; i++ {
-		client, tmp_err := Diag(closestList.List[i].Ip)
-		if tmp_err != nil {
-			log.Errorln("[Error] in function Put can not diag node aimIp", closestList.List[i].Ip)
-		} else {
-			var o string
-			tmp_err = client.Call("WrapNode.AddPair", &StoreArg{key, value, this.address}, &o)
-			if tmp_err != nil {
-				log.Errorln("[Error] can not call addpair because", tmp_err)
-			}
-			client.Close()
-		}
-	}
-	//log.Infoln("In RePut end the time is", time.Now())
-	return true
+func (this *KadNode) Get(key string) (string, error) {
+	return this.GetContext(context.Background(), key)
 }
 
-func (this *KadNode) Get(key string) (bool, string) {
+//GetContext is like Get, but it stops waiting when ctx is done.
+//The key is only reported missing when another node answered, if none of the
+//nodes tried answered the error of the last one is returned.
+func (this *KadNode) GetContext(ctx context.Context, key string) (string, error) {
+	if !this.conRoutineFlag {
+		return "", dht.ErrNotJoined
+	}
 	log.Infoln("Function Get begin in", time.Now())
 	defer log.Infoln("Function Get end in", time.Now())
+	knowsPeers := this.knows_peers()
 	keyID := Hash(key)
 	isDiaged := make(map[string]bool)
-	finfValueRes := this.FindValue(key, &keyID)
+	finfValueRes := this.FindValueContext(ctx, key, &keyID)
 	if finfValueRes.Second != "" {
-		return true, finfValueRes.Second
+		return finfValueRes.Second, nil
 	}
 	closestlist := finfValueRes.First
+	answered := false
+	var last_err error
 	isUpdated := true
 	for isUpdated {
 		isUpdated = false
-		var tmp ClosestList
+		tmp := ClosestList{from: this}
 		var removeList []AddrType
 		for i := 0; i < closestlist.Size; i++ {
+			if ctx.Err() != nil {
+				return "", dht.RemoteError(ctx, ctx.Err())
+			}
 			if closestlist.List[i].Ip == "" || isDiaged[closestlist.List[i].Ip] == true {
 				continue
 			}
-			client, tmp_err := Diag(closestlist.List[i].Ip)
-			isDiaged[closestlist.List[i].Ip] = true
 			var res FindValueRet
+			tmp_err := this.call_context(ctx, closestlist.List[i].Ip, "WrapNode.FindValue", &FindValueArg{Key: key, Sender: this.address}, &res)
+			isDiaged[closestlist.List[i].Ip] = true
 			if tmp_err != nil {
 				log.Errorln("[Error] in function get can not diag", closestlist.List[i].Ip, "because", tmp_err)
+				last_err = dht.RemoteError(ctx, tmp_err)
 				removeList = append(removeList, closestlist.List[i])
 			} else {
-				tmp_err = client.Call("WrapNode.FindValue", &FindValueArg{Key: key, Sender: this.address}, &res)
+				answered = answered || closestlist.List[i].Ip != this.address.Ip
 				if res.Second != "" {
-					return true, res.Second
+					return res.Second, nil
 				} else {
 					for j := 0; j < res.First.Size; j++ {
-						tmp.Insert(res.First.List[j])
+						tmp.InsertContext(ctx, res.First.List[j])
 					}
 				}
-				client.Close()
 			}
 		}
 		for _, rmKey := range removeList {
 			closestlist.Remove(rmKey)
 		}
 		for i := 0; i < tmp.Size; i++ {
-			isUpdated = isUpdated || closestlist.Insert(tmp.List[i])
+			isUpdated = isUpdated || closestlist.InsertContext(ctx, tmp.List[i])
 		}
 	}
-	secList := this.NodeLookup(&keyID)
+	secList := this.NodeLookupContext(ctx, &keyID)
 	for _, aimAddr := range secList.List {
-		client, tmp_err := Diag(aimAddr.Ip)
+		if ctx.Err() != nil {
+			return "", dht.RemoteError(ctx, ctx.Err())
+		}
+		if aimAddr.Ip == "" {
+			continue
+		}
+		var res FindValueRet
+		tmp_err := this.call_context(ctx, aimAddr.Ip, "WrapNode.FindValue", &FindValueArg{Key: key, Sender: this.address}, &res)
 		if tmp_err != nil {
 			log.Errorln("[Error] in function Get can not diag", aimAddr.Ip, "because", tmp_err)
+			last_err = dht.RemoteError(ctx, tmp_err)
 			continue
 		}
-		defer client.Close()
-		var res FindValueRet
-		client.Call("WrapNode.FindValue", &FindValueArg{Key: key, Sender: this.address}, &res)
+		answered = answered || aimAddr.Ip != this.address.Ip
 		if res.Second != "" {
-			return true, res.Second
+			return res.Second, nil
 		}
 	}
-	return false, ""
+	if !answered && last_err != nil {
+		return "", last_err
+	}
+	if !answered && knowsPeers {
+		return "", dht.ErrNoRoute
+	}
+	return "", dht.ErrNotFound
+}
+
+func (this *KadNode) FindNode(tarID *big.Int) ClosestList {
+	return this.FindNodeContext(context.Background(), tarID)
 }
 
-func (this *KadNode) FindNode(tarID *big.Int) (closestList ClosestList) {
+//FindNodeContext is like FindNode, it returns the closest contacts found so far when ctx is done.
+//The contacts are pinged without the locks of the routing table.
+func (this *KadNode) FindNodeContext(ctx context.Context, tarID *big.Int) (closestList ClosestList) {
 	if tarID == nil {
 		log.Errorln("[Error] in function FindNode tarID is nil")
 		return
 	}
-	this.mux.RLock()
-	defer this.mux.RUnlock()
 	closestList.Standard = *tarID
-	for i := 0; i < M; i++ {
-		for j := 0; j < this.routeTable[i].size; j++ {
-			if Ping(this.routeTable[i].bucket[j].Ip) == nil { // if online
-				closestList.Insert(this.routeTable[i].bucket[j])
-			}
+	closestList.from = this
+	for _, contact := range this.contacts() {
+		if ctx.Err() != nil {
+			return
 		}
+		closestList.InsertContext(ctx, contact)
 	}
 	return
 }
 
 func (this *KadNode) FindValue(key string, hash *big.Int) FindValueRet {
+	return this.FindValueContext(context.Background(), key, hash)
+}
+
+//FindValueContext is like FindValue, the closest contacts are looked for as in FindNodeContext.
+func (this *KadNode) FindValueContext(ctx context.Context, key string, hash *big.Int) FindValueRet {
 	//firstly find in node "this" then find it in other nodes
-	this.mux.RLock()
-	defer this.mux.RUnlock()
 	founded, value := this.data.GetValue(key)
 	if founded {
 		return FindValueRet{ClosestList{}, value}
 	}
 	var retClosest ClosestList
 	if hash != nil {
-		retClosest = ClosestList{Standard: *hash}
-		for i := 0; i < M; i++ {
-			for j := 0; j < this.routeTable[i].size; j++ {
-				if Ping(this.routeTable[i].bucket[j].Ip) == nil { //if online
-					retClosest.Insert(this.routeTable[i].bucket[j])
-				}
-			}
-		}
+		retClosest = this.FindNodeContext(ctx, hash)
 	}
 	return FindValueRet{retClosest, ""}
 }
 
 func (this *KadNode) NodeLookup(tarID *big.Int) (closestList ClosestList) {
+	return this.NodeLookupContext(context.Background(), tarID)
+}
+
+//NodeLookupContext is like NodeLookup, it returns the closest nodes found so far when ctx is done.
+func (this *KadNode) NodeLookupContext(ctx context.Context, tarID *big.Int) (closestList ClosestList) {
 	if tarID == nil {
 		log.Errorln("[Error] the bigInt is nil")
 		return
 	}
-	closestList = this.FindNode(tarID)
-	closestList.Insert(this.address)
+	start := this.config.Clock.Now()
+	rounds := 0
+	defer func() {
+		observe_lookup(rounds, this.config.Clock.Now().Sub(start))
+	}()
+	closestList = this.FindNodeContext(ctx, tarID)
+	closestList.InsertContext(ctx, this.address)
 	isUpdate := true
 	diaged := make(map[string]bool)
 	for isUpdate {
 		isUpdate = false
-		var tmp ClosestList
+		rounds++
+		tmp := ClosestList{from: this}
 		var removeList []AddrType
 		for i := 0; i < closestList.Size; i++ {
+			if ctx.Err() != nil {
+				return
+			}
 			if diaged[closestList.List[i].Ip] == true {
 				continue
 			}
-			this.kBucketUpdate(closestList.List[i])
-			client, tmp_err := Diag(closestList.List[i].Ip)
-			diaged[closestList.List[i].Ip] = true
+			this.kBucketUpdateContext(ctx, closestList.List[i])
 			var res ClosestList
+			tmp_err := this.call_context(ctx, closestList.List[i].Ip, "WrapNode.FindNode", &FindNodeArg{TarID: *tarID, Sender: this.address}, &res)
+			diaged[closestList.List[i].Ip] = true
 			//remove the offline node
 			if tmp_err != nil {
 				removeList = append(removeList, closestList.List[i])
 			} else {
-				tmp_err = client.Call("WrapNode.FindNode", &FindNodeArg{TarID: *tarID, Sender: this.address}, &res)
 				for j := 0; j < res.Size; j++ {
-					tmp.Insert(res.List[j])
+					tmp.InsertContext(ctx, res.List[j])
 				}
-				client.Close()
 			}
 		}
 		for _, key := range removeList {
 			closestList.Remove(key)
 		}
 		for i := 0; i < tmp.Size; i++ {
-			isUpdate = isUpdate || closestList.Insert(tmp.List[i])
+			isUpdate = isUpdate || closestList.InsertContext(ctx, tmp.List[i])
 		}
 	}
 	return
 }
 
+//knows_peers tells if the routing table holds another node, reachable or not
+func (this *KadNode) knows_peers() bool {
+	return len(this.contacts()) > 0
+}
+
+//the contacts of the routing table, each bucket is copied under its lock
+func (this *KadNode) contacts() []AddrType {
+	var res []AddrType
+	for i := 0; i < M; i++ {
+		res = append(res, this.routeTable[i].contacts()...)
+	}
+	return res
+}
+
 func (this *KadNode) RePublish() {
 	for this.conRoutineFlag {
 		//log.Infoln("Begin Republish", time.Now())
@@ -337,12 +438,11 @@ func (this *KadNode) RePublish() {
 		copyData := this.data.CopyData()
 		republishList := this.data.GetRePublishList()
 		this.mux.Unlock()
-		for _, key := range republishList {
-			this.aPut(key, copyData[key])
-		}
+		this.republish(republishList, copyData)
 		this.data.DeleteExpiredData()
+		this.count_maintenance("RePublish")
 		//log.Infoln("End Republish", time.Now())
-		time.Sleep(RepublishINterval)
+		this.config.Clock.Sleep(RepublishINterval)
 	}
 }
 
@@ -353,12 +453,15 @@ func (this *KadNode) reset() {
 }
 
 func (this *KadNode) kBucketUpdate(addr AddrType) {
-	this.mux.Lock()
-	defer this.mux.Unlock()
+	this.kBucketUpdateContext(context.Background(), addr)
+}
+
+//each bucket has a lock of its own, none is held during the ping of a full bucket
+func (this *KadNode) kBucketUpdateContext(ctx context.Context, addr AddrType) {
 	if addr.Ip == "" || addr.Ip == this.address.Ip {
 		return
 	}
-	this.routeTable[cpl(&this.address.Id, &addr.Id)].Update(addr)
+	this.routeTable[cpl(&this.address.Id, &addr.Id)].UpdateContext(ctx, addr)
 }
 
 //none used function
@@ -367,13 +470,55 @@ func (this *KadNode) Create() {
 }
 func (this *KadNode) Quit() {
 	this.station.ShutDown()
+	this.stop_metrics()
 	this.reset()
 }
 func (this *KadNode) ForceQuit() {
 	this.station.ShutDown()
+	this.stop_metrics()
 	this.reset()
 }
 
-func (this *KadNode) Delete(key string) bool {
-	return true
+func (this *KadNode) Delete(key string) error {
+	return this.DeleteContext(context.Background(), key)
+}
+
+//DeleteContext removes the pair from the closest nodes to the key, where Put stored it.
+//The key is only reported missing when a node answered and none of them held it.
+//A node out of the closest list which still holds the pair puts it back when it republishes.
+func (this *KadNode) DeleteContext(ctx context.Context, key string) error {
+	if !this.conRoutineFlag {
+		return dht.ErrNotJoined
+	}
+	keyID := Hash(key)
+	closestList := this.NodeLookupContext(ctx, &keyID)
+	if ctx.Err() != nil {
+		return dht.RemoteError(ctx, ctx.Err())
+	}
+	closestList.InsertContext(ctx, this.address)
+	var last_err error = dht.ErrNoRoute
+	answered := false
+	deleted := false
+	for i := 0; i < closestList.Size; i++ {
+		if ctx.Err() != nil {
+			log.Errorln("[Error] in function Delete", ctx.Err())
+			return dht.RemoteError(ctx, ctx.Err())
+		}
+		var founded bool
+		tmp_err := this.call_context(ctx, closestList.List[i].Ip, "WrapNode.DeletePair", &DeleteArg{key, this.address}, &founded)
+		if tmp_err != nil {
+			log.Errorln("[Error] in function Delete can not call deletepair in", closestList.List[i].Ip, "because", tmp_err)
+			last_err = dht.RemoteError(ctx, tmp_err)
+			continue
+		}
+		answered = true
+		deleted = deleted || founded
+	}
+	if deleted {
+		return nil
+	}
+	if !answered {
+		return last_err
+	}
+	return dht.ErrNotFound
 }
@@ -0,0 +1,79 @@
+package kademlia_test
+
+import (
+	"crypto/tls"
+	"dht"
+	"kademlia"
+	"testing"
+)
+
+//tls_config is a config of memory_config with a certificate of ca
+func tls_config(t *testing.T, ca *dht.CA) kademlia.Config {
+	t.Helper()
+	conf := memory_config()
+	cert, tmp_err := ca.Issue(conf.AdvertiseAddress)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	conf.TLS = dht.MutualTLS(cert, ca.Pool())
+	return conf
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, tmp_err := dht.NewCA("cluster")
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	conf := tls_config(t, ca)
+	nodes := start_network(t, conf, 22100, 3)
+	tmp_err = nodes[0].Put("key", "value")
+	if tmp_err != nil {
+		t.Fatalf("put over TLS: %v", tmp_err)
+	}
+	value, tmp_err := nodes[2].Get("key")
+	if tmp_err != nil || value != "value" {
+		t.Fatalf("get over TLS = %q, %v", value, tmp_err)
+	}
+
+	addr := dht.JoinAddress(conf.AdvertiseAddress, 22100)
+	kademlia.SetTransport(conf.Transport)
+	defer kademlia.SetTransport(nil)
+	defer kademlia.SetClientTLS(nil)
+	var o string
+	kademlia.SetClientTLS(conf.TLS)
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.Ping", 0, &o)
+	if tmp_err != nil {
+		t.Fatalf("ping with a certificate of the cluster: %v", tmp_err)
+	}
+	//trusting the server is not enough, the client has to present a certificate
+	kademlia.SetClientTLS(&tls.Config{RootCAs: ca.Pool()})
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.Ping", 0, &o)
+	if tmp_err == nil {
+		t.Errorf("a client without a certificate is served")
+	}
+	foreign, tmp_err := dht.NewCA("foreign")
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	cert, tmp_err := foreign.Issue(conf.AdvertiseAddress)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	kademlia.SetClientTLS(dht.MutualTLS(cert, ca.Pool()))
+	tmp_err = kademlia.RemoteCall(addr, "WrapNode.Ping", 0, &o)
+	if tmp_err == nil {
+		t.Errorf("a client with a certificate of a foreign CA is served")
+	}
+
+	//nor can a node of another CA join the network
+	other := tls_config(t, foreign)
+	other.Transport = conf.Transport
+	node := new(kademlia.KadNode)
+	node.InitWithConfig(22103, other)
+	node.Run()
+	defer node.ForceQuit()
+	tmp_err = node.Join(addr)
+	if tmp_err == nil {
+		t.Errorf("a node with a certificate of a foreign CA joins")
+	}
+}
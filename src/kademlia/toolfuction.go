@@ -1,10 +1,11 @@
 package kademlia
 
 import (
+	"context"
 	"crypto/sha1"
+	"dht"
 	"errors"
 	"math/big"
-	"net"
 	"net/rpc"
 	"time"
 )
@@ -21,44 +22,10 @@ const RemoteTryTime = 3
 const RemoteTryInterval = 25 * time.Millisecond
 
 var Mod = big.NewInt(0).Exp(big.NewInt(2), big.NewInt(int64(M)), nil)
-var localAddress string
-
-func init() {
-	localAddress = GetLocalAddress()
-}
 
+//GetLocalAddress returns the address used when Config.AdvertiseAddress is empty.
 func GetLocalAddress() string {
-	var localaddress string
-
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		panic("init: failed to find network interfaces")
-	}
-
-	// find the first non-loopback interface with an IP address
-	for _, elt := range ifaces {
-		if elt.Flags&net.FlagLoopback == 0 && elt.Flags&net.FlagUp != 0 {
-			addrs, err := elt.Addrs()
-			if err != nil {
-				panic("init: failed to get addresses for network interface")
-			}
-
-			for _, addr := range addrs {
-				ipnet, ok := addr.(*net.IPNet)
-				if ok {
-					if ip4 := ipnet.IP.To4(); len(ip4) == net.IPv4len {
-						localaddress = ip4.String()
-						break
-					}
-				}
-			}
-		}
-	}
-	if localaddress == "" {
-		panic("init: failed to find non-loopback interface with valid address on this node")
-	}
-
-	return localaddress
+	return dht.LocalAddress()
 }
 
 func Hash(str string) big.Int {
@@ -71,66 +38,111 @@ func Hash(str string) big.Int {
 }
 
 //for kBucketTYpe
+//Reflesh pings the contacts from the least recently seen one, and removes the first
+//one which does not answer. The contacts are pinged without the lock of the bucket.
 func (this *KBucketType) Reflesh() {
-	for i := 0; i < this.size; i++ {
-		if Ping(this.bucket[i].Ip) != nil {
-			for j := i + 1; j < this.size; j++ {
-				this.bucket[j-1] = this.bucket[j]
-			}
-			this.size--
+	for _, contact := range this.contacts() {
+		online := ping_from(context.Background(), this.owner, contact.Ip) == nil
+		this.mux.Lock()
+		pos := this.find(contact.Ip)
+		if pos >= 0 && online {
+			this.lastSeen[pos] = this.clock.Now()
+		} else if pos >= 0 {
+			this.remove(pos)
+		}
+		this.mux.Unlock()
+		if !online {
 			return
 		}
 	}
 }
 
-func (this *KBucketType) Update(addr AddrType) {
+//a copy of the contacts, the least recently seen first
+func (this *KBucketType) contacts() []AddrType {
 	this.mux.Lock()
 	defer this.mux.Unlock()
+	return append([]AddrType(nil), this.bucket[:this.size]...)
+}
+
+//position of the contact at ip, -1 if it is not in the bucket
+//need hold mux
+func (this *KBucketType) find(ip string) int {
+	for i := 0; i < this.size; i++ {
+		if this.bucket[i].Ip == ip {
+			return i
+		}
+	}
+	return -1
+}
+
+//remove the i-th contact, the later ones move forward
+func (this *KBucketType) remove(i int) {
+	for j := i + 1; j < this.size; j++ {
+		this.bucket[j-1] = this.bucket[j]
+		this.lastSeen[j-1] = this.lastSeen[j]
+	}
+	this.size--
+}
+
+//put addr at the tail as the most recently seen contact
+func (this *KBucketType) push(addr AddrType) {
+	this.bucket[this.size] = addr
+	this.lastSeen[this.size] = this.clock.Now()
+	this.size++
+}
+
+func (this *KBucketType) Update(addr AddrType) {
+	this.UpdateContext(context.Background(), addr)
+}
+
+//UpdateContext is like Update, the ping of the least recently seen contact of a full
+//bucket gives up when ctx is done. The ping is made without the lock of the bucket.
+func (this *KBucketType) UpdateContext(ctx context.Context, addr AddrType) {
 	if addr.Ip == "" {
 		return
 	}
-	pos := -1
-	for i := 0; i < this.size; i++ {
-		if this.bucket[i].Ip == addr.Ip {
-			pos = i
-			break
+	this.mux.Lock()
+	defer this.mux.Unlock()
+	for {
+		pos := this.find(addr.Ip)
+		if pos >= 0 {
+			this.remove(pos)
+			this.push(addr)
+			return
 		}
-	}
-	if pos == -1 {
 		if this.size < K {
-			this.size++
-			this.bucket[this.size-1] = addr
+			this.push(addr)
 			return
-		} else {
-			if Ping(this.bucket[0].Ip) == nil {
-				//bucket[0] is online
-				head := this.bucket[0]
-				for i := 1; i < K; i++ {
-					this.bucket[i-1] = this.bucket[i]
-				}
-				this.bucket[K-1] = head
-				return
-			} else {
-				//bucket[0] is offline
-				for i := 1; i < K; i++ {
-					this.bucket[i-1] = this.bucket[i]
-				}
-				this.bucket[K-1] = addr
-				return
-			}
 		}
-	} else {
-		for i := pos + 1; i < this.size; i++ {
-			this.bucket[i-1] = this.bucket[i]
+		head := this.bucket[0]
+		this.mux.Unlock()
+		online := ping_from(ctx, this.owner, head.Ip) == nil
+		this.mux.Lock()
+		if this.size == 0 || this.bucket[0].Ip != head.Ip {
+			//the bucket changed during the ping, look at it again
+			continue
+		}
+		this.remove(0)
+		if online {
+			//bucket[0] is online
+			this.push(head)
+		} else {
+			//bucket[0] is offline
+			this.push(addr)
 		}
-		this.bucket[this.size-1] = addr
+		return
 	}
 }
 
 //for closetlist:
 func (this *ClosestList) Insert(addr AddrType) bool {
+	return this.InsertContext(context.Background(), addr)
+}
+
+//InsertContext is like Insert, the ping of addr gives up when ctx is done.
+func (this *ClosestList) InsertContext(ctx context.Context, addr AddrType) bool {
 	res := false
-	if Ping(addr.Ip) != nil {
+	if ping_from(ctx, this.from, addr.Ip) != nil {
 		return res
 	}
 	for i := 0; i < this.Size; i++ {
@@ -185,35 +197,42 @@ func (this *ClosestList) Remove(addr AddrType) bool {
 }
 
 func Ping(addr string) error {
-	var ret *rpc.Client
-	var err error
-	if addr == "" {
-		return errors.New("[error] Empty IP addr")
-	}
-	for i := 0; i < RemoteTryTime; i++ {
-		ret, err = rpc.Dial("tcp", addr)
-		if err == nil {
-			ret.Close()
-			return err
-		}
-		time.Sleep(RemoteTryInterval)
+	return default_caller().ping(context.Background(), "", addr)
+}
+
+//ping addr for node, a nil node is a caller which is not a node
+func ping_from(ctx context.Context, node *KadNode, addr string) error {
+	if node == nil {
+		return default_caller().ping(ctx, "", addr)
 	}
-	return err
+	return node.ping(ctx, addr)
+}
+
+//ping addr for the node at from
+func (this caller) ping(ctx context.Context, from string, addr string) error {
+	var o string
+	return this.remote_call(ctx, from, addr, "WrapNode.Ping", 0, &o)
 }
 
+//Diag dials addr with the settings of RemoteCall, trying RemoteTryTime times.
 func Diag(addr string) (*rpc.Client, error) {
+	return default_caller().diag(addr)
+}
+
+func (this caller) diag(addr string) (*rpc.Client, error) {
 	var ret *rpc.Client
 	var err error
 	if addr == "" {
 		return nil, errors.New("ERROR: empty IP addr")
 	}
 	for i := 0; i < RemoteTryTime; i++ {
-		ret, err = rpc.Dial("tcp", addr)
+		ret, err = this.Dial(addr)
 		if err == nil {
 			return ret, err
 		}
-		time.Sleep(RemoteTryInterval)
+		this.Clock.Sleep(RemoteTryInterval)
 	}
+	count_dial_failure()
 	return nil, err
 }
 
@@ -232,10 +251,11 @@ func cpl(x, y *big.Int) int {
 func (this *DataType) GetRePublishList() (republishList []string) {
 	this.lock.RLock()
 	defer this.lock.RUnlock()
+	now := this.clock.Now()
 	for key, tim := range this.republishTime {
-		if time.Now().After(tim) {
+		if now.After(tim) {
 			republishList = append(republishList, key)
-			this.republishTime[key] = time.Now().Add(NeedRepublicTime)
+			this.republishTime[key] = now.Add(NeedRepublicTime)
 		}
 	}
 	return republishList
@@ -244,8 +264,9 @@ func (this *DataType) GetRePublishList() (republishList []string) {
 func (this *DataType) DeleteExpiredData() {
 	var expiredList []string
 	this.lock.RLock()
+	now := this.clock.Now()
 	for key, tim := range this.validTime {
-		if time.Now().After(tim) {
+		if now.After(tim) {
 			expiredList = append(expiredList, key)
 		}
 	}
@@ -263,8 +284,9 @@ func (this *DataType) AddPair(key string, value string) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 	this.hashMap[key] = value
-	this.validTime[key] = time.Now().Add(ExpiredTime)
-	this.republishTime[key] = time.Now().Add(NeedRepublicTime)
+	now := this.clock.Now()
+	this.validTime[key] = now.Add(ExpiredTime)
+	this.republishTime[key] = now.Add(NeedRepublicTime)
 }
 
 func (this *DataType) GetValue(key string) (founded bool, res string) {
@@ -276,7 +298,7 @@ func (this *DataType) GetValue(key string) (founded bool, res string) {
 
 func (this *DataType) SubPair(key string) (founded bool) {
 	this.lock.Lock()
-	this.lock.Unlock()
+	defer this.lock.Unlock()
 	_, founded = this.hashMap[key]
 	if founded {
 		delete(this.hashMap, key)
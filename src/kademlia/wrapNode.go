@@ -4,6 +4,10 @@ type WrapNode struct {
 	node *KadNode
 }
 
+func (this *WrapNode) Ping(_ int, _ *string) error {
+	return nil
+}
+
 func (this *WrapNode) FindNode(input *FindNodeArg, res *ClosestList) error {
 	*res = this.node.FindNode(&input.TarID)
 	this.node.kBucketUpdate(input.Sender)
@@ -16,8 +20,43 @@ func (this *WrapNode) AddPair(input *StoreArg, _ *string) error {
 	return nil
 }
 
+func (this *WrapNode) DeletePair(input *DeleteArg, res *bool) error {
+	*res = this.node.data.SubPair(input.Key)
+	this.node.kBucketUpdate(input.Sender)
+	return nil
+}
+
+func (this *WrapNode) AddPairs(input *StoreBatchArg, _ *string) error {
+	for key, value := range input.Pairs {
+		this.node.data.AddPair(key, value)
+	}
+	this.node.kBucketUpdate(input.Sender)
+	return nil
+}
+
+func (this *WrapNode) Refresh(input *RefreshArg, _ *string) error {
+	this.node.data.Refresh(input.Keys)
+	this.node.kBucketUpdate(input.Sender)
+	return nil
+}
+
+func (this *WrapNode) MerkleNodes(input *MerkleArg, res *[][]byte) error {
+	tree, tmp_err := this.node.merkle_tree(input)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	*res = tree.Nodes(input.Level, input.Indexes)
+	this.node.kBucketUpdate(input.Sender)
+	return nil
+}
+
 func (this *WrapNode) FindValue(input *FindValueArg, res *FindValueRet) error {
 	*res = this.node.FindValue(input.Key, &input.Hash)
 	this.node.kBucketUpdate(input.Sender)
 	return nil
 }
+
+func (this *WrapNode) DebugState(_ int, res *DebugState) error {
+	*res = this.node.DebugState()
+	return nil
+}
@@ -46,7 +46,7 @@ func forceQuitTest() (bool, int, int) {
 	_, _ = cyan.Printf("Start joining\n")
 	for i := 1; i <= forceQuitNodeSize; i++ {
 		addr := nodeAddresses[rand.Intn(i)]
-		if !nodes[i].Join(addr) {
+		if nodes[i].Join(addr) != nil {
 			joinInfo.fail()
 		} else {
 			joinInfo.success()
@@ -71,7 +71,7 @@ func forceQuitTest() (bool, int, int) {
 		value := randString(lengthOfKeyValue)
 		kvMap[key] = value
 
-		if !nodes[rand.Intn(forceQuitNodeSize+1)].Put(key, value) {
+		if nodes[rand.Intn(forceQuitNodeSize+1)].Put(key, value) != nil {
 			putInfo.fail()
 		} else {
 			putInfo.success()
@@ -102,8 +102,8 @@ func forceQuitTest() (bool, int, int) {
 		}
 		_, _ = cyan.Printf("Start getting (round %d)\n", t)
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err != nil || res != value {
 				getInfo.fail()
 			} else {
 				getInfo.success()
@@ -159,7 +159,7 @@ func quitAndStabilizeTest() (bool, int, int) {
 	_, _ = cyan.Printf("Start joining\n")
 	for i := 1; i <= QASNodeSize; i++ {
 		addr := nodeAddresses[rand.Intn(i)]
-		if !nodes[i].Join(addr) {
+		if nodes[i].Join(addr) != nil {
 			joinInfo.fail()
 		} else {
 			joinInfo.success()
@@ -184,7 +184,7 @@ func quitAndStabilizeTest() (bool, int, int) {
 		value := randString(lengthOfKeyValue)
 		kvMap[key] = value
 
-		if !nodes[rand.Intn(QASNodeSize+1)].Put(key, value) {
+		if nodes[rand.Intn(QASNodeSize+1)].Put(key, value) != nil {
 			putInfo.fail()
 		} else {
 			putInfo.success()
@@ -210,8 +210,8 @@ func quitAndStabilizeTest() (bool, int, int) {
 		/* Get some data. */
 		getCnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err != nil || res != value {
 				getInfo.fail()
 			} else {
 				getInfo.success()
@@ -53,7 +53,7 @@ func basicTest() (bool, int, int) {
 		_, _ = cyan.Printf("Start joining (round %d)\n", t)
 		for j := 1; j <= basicTestRoundJoinNodeSize; j++ {
 			addr := nodeAddresses[nodesInNetwork[rand.Intn(len(nodesInNetwork))]]
-			if !nodes[nextJoinNode].Join(addr) {
+			if nodes[nextJoinNode].Join(addr) != nil {
 				joinInfo.fail()
 			} else {
 				joinInfo.success()
@@ -79,7 +79,7 @@ func basicTest() (bool, int, int) {
 			value := randString(lengthOfKeyValue)
 			kvMap[key] = value
 
-			if !nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) {
+			if nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) != nil {
 				put1Info.fail()
 			} else {
 				put1Info.success()
@@ -96,8 +96,8 @@ func basicTest() (bool, int, int) {
 		_, _ = cyan.Printf("Start getting (round %d, part 1)\n", t)
 		get1Cnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err != nil || res != value {
 				get1Info.fail()
 			} else {
 				get1Info.success()
@@ -120,8 +120,8 @@ func basicTest() (bool, int, int) {
 		for i := 1; i <= basicTestRoundDeleteSize; i++ {
 			for key := range kvMap {
 				delete(kvMap, key)
-				success := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
-				if !success {
+				err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
+				if err != nil {
 					delete1Info.fail()
 				} else {
 					delete1Info.success()
@@ -157,7 +157,7 @@ func basicTest() (bool, int, int) {
 			value := randString(lengthOfKeyValue)
 			kvMap[key] = value
 
-			if !nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) {
+			if nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) != nil {
 				put2Info.fail()
 			} else {
 				put2Info.success()
@@ -174,8 +174,8 @@ func basicTest() (bool, int, int) {
 		_, _ = cyan.Printf("Start getting (round %d, part 2)\n", t)
 		get2Cnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err != nil || res != value {
 				get2Info.fail()
 			} else {
 				get2Info.success()
@@ -198,8 +198,8 @@ func basicTest() (bool, int, int) {
 		for i := 1; i <= basicTestRoundDeleteSize; i++ {
 			for key := range kvMap {
 				delete(kvMap, key)
-				success := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
-				if !success {
+				err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
+				if err != nil {
 					delete2Info.fail()
 				} else {
 					delete2Info.success()
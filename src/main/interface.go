@@ -6,8 +6,8 @@ type dhtNode interface {
 
 	/* "Create" and "Join" are called after calling "Run". */
 	/* For a dhtNode, either "Create" or "Join" will be called, but not both. */
-	Create()               /* Create a new network. */
-	Join(addr string) bool /* Join an existing network. Return "true" if join succeeded and "false" if not. */
+	Create()                /* Create a new network. */
+	Join(addr string) error /* Join an existing network. Return nil if join succeeded and the reason if not. */
 
 	/* Quit from the network it is currently in.*/
 	/* "Quit" will not be called before "Create" or "Join". */
@@ -26,9 +26,13 @@ type dhtNode interface {
 	/* Put a key-value pair into the network (if KEY is already in the network, cover it), or
 	 * get a key-value pair from the network, or
 	 * remove a key-value pair from the network.
+	 * The errors can be checked with errors.Is against dht.ErrNotFound, dht.ErrNotJoined,
+	 * dht.ErrTimeout and dht.ErrNoRoute.
 	 */
-	Put(key string, value string) bool /* Return "true" if success, "false" otherwise. */
-	Get(key string) (bool, string)     /* Return "true" and the value if success, "false" otherwise. */
-	Delete(key string) bool            /* Remove the key-value pair represented by KEY from the network. */
-	/* Return "true" if remove successfully, "false" otherwise. */
+	Put(key string, value string) error /* Return nil if success, the reason otherwise. */
+	Get(key string) (string, error)     /* Return the value and nil if success, the reason otherwise. */
+	Delete(key string) error            /* Remove the key-value pair represented by KEY from the network. */
+	/* Return nil if remove successfully, the reason otherwise. */
+	/* Both protocols delete: Chord from the successor of KEY and its backups, Kademlia from the */
+	/* closest nodes to KEY. Deleting a missing KEY returns dht.ErrNotFound. */
 }
@@ -0,0 +1,205 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+)
+
+//DefaultDepth gives 256 leaves, so a leaf holds a few keys for a node with a few thousand pairs.
+const DefaultDepth = 8
+
+//Diff descends this many levels in one round trip
+const diffStride = 4
+
+var ErrBadAnswer = errors.New("merkle: remote answered a different number of hashes")
+
+//Tree is a complete binary hash tree over a set of pairs. A pair goes to one of the
+//2^depth leaves by the leading bits of the SHA-1 of its key, so two trees of the same
+//depth over the same key range can be compared leaf by leaf, and a leaf is a key range.
+type Tree struct {
+	depth int
+	//levels[0] holds the root and levels[depth] holds the leaves
+	levels [][][]byte
+}
+
+//Remote returns the hashes at level of the tree on another node, in the order of indexes.
+type Remote func(level int, indexes []int) ([][]byte, error)
+
+//Bucket is the leaf key goes to in a tree of depth.
+func Bucket(key string, depth int) int {
+	sum := sha1.Sum([]byte(key))
+	return int(binary.BigEndian.Uint32(sum[:4]) >> (32 - uint(depth)))
+}
+
+//Digest hashes the fields which make up a value, two values are the same if their digests are.
+func Digest(fields ...string) []byte {
+	hasher := sha1.New()
+	var size [8]byte
+	for _, field := range fields {
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		hasher.Write(size[:])
+		hasher.Write([]byte(field))
+	}
+	return hasher.Sum(nil)
+}
+
+//Build makes a tree of depth over digests, which maps each key to the Digest of its value.
+//depth is at most 16.
+func Build(depth int, digests map[string][]byte) *Tree {
+	if depth < 0 {
+		depth = 0
+	}
+	if depth > 16 {
+		depth = 16
+	}
+	leaves := make([][]string, 1<<uint(depth))
+	for key := range digests {
+		b := Bucket(key, depth)
+		leaves[b] = append(leaves[b], key)
+	}
+	res := &Tree{depth: depth, levels: make([][][]byte, depth+1)}
+	res.levels[depth] = make([][]byte, len(leaves))
+	for i, keys := range leaves {
+		sort.Strings(keys)
+		fields := make([]string, 0, 2*len(keys))
+		for _, key := range keys {
+			fields = append(fields, key, string(digests[key]))
+		}
+		res.levels[depth][i] = Digest(fields...)
+	}
+	for level := depth - 1; level >= 0; level-- {
+		below := res.levels[level+1]
+		res.levels[level] = make([][]byte, len(below)/2)
+		for i := range res.levels[level] {
+			res.levels[level][i] = Digest(string(below[2*i]), string(below[2*i+1]))
+		}
+	}
+	return res
+}
+
+func (this *Tree) Depth() int {
+	return this.depth
+}
+
+func (this *Tree) Root() []byte {
+	return this.levels[0][0]
+}
+
+//Nodes returns the hashes at level in the order of indexes, nil for an index out of the level.
+func (this *Tree) Nodes(level int, indexes []int) [][]byte {
+	res := make([][]byte, len(indexes))
+	if level < 0 || level > this.depth {
+		return res
+	}
+	for i, index := range indexes {
+		if index >= 0 && index < len(this.levels[level]) {
+			res[i] = this.levels[level][index]
+		}
+	}
+	return res
+}
+
+//Diff compares this tree with a tree of the same depth on another node, and returns
+//the leaves where they differ. It only asks for the subtrees whose roots differ.
+func (this *Tree) Diff(remote Remote) ([]int, error) {
+	level := 0
+	candidates := []int{0}
+	for {
+		hashes, tmp_err := remote(level, candidates)
+		if tmp_err != nil {
+			return nil, tmp_err
+		}
+		if len(hashes) != len(candidates) {
+			return nil, ErrBadAnswer
+		}
+		var differing []int
+		for i, index := range candidates {
+			if !bytes.Equal(this.levels[level][index], hashes[i]) {
+				differing = append(differing, index)
+			}
+		}
+		if level == this.depth || len(differing) == 0 {
+			return differing, nil
+		}
+		next := level + diffStride
+		if next > this.depth {
+			next = this.depth
+		}
+		width := 1 << uint(next-level)
+		candidates = candidates[:0]
+		for _, index := range differing {
+			for i := 0; i < width; i++ {
+				candidates = append(candidates, index*width+i)
+			}
+		}
+		level = next
+	}
+}
+
+//Contains tells whether key goes to one of leaves in a tree of depth.
+func Contains(leaves []int, key string, depth int) bool {
+	bucket := Bucket(key, depth)
+	for _, leaf := range leaves {
+		if leaf == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+//Cache keeps the trees a node built for the comparisons in progress, so the later round
+//trips of a Diff are answered from the tree built for the first one instead of building
+//it again. A comparison is told apart by the round its caller made with NewRound.
+//It holds at most size trees and forgets the oldest first, a nil Cache keeps nothing.
+type Cache struct {
+	size  int
+	trees map[string]*Tree
+	//ids in the order they are put
+	order []string
+	lock  sync.Mutex
+}
+
+func NewCache(size int) *Cache {
+	if size < 1 {
+		size = 1
+	}
+	return &Cache{size: size, trees: make(map[string]*Tree)}
+}
+
+//NewRound gives a random identifier to the round trips of one Diff.
+func NewRound() uint64 {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+//Get returns the tree kept for id, nil if there is none.
+func (this *Cache) Get(id string) *Tree {
+	if this == nil {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.trees[id]
+}
+
+func (this *Cache) Put(id string, tree *Tree) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.trees[id]; !ok {
+		this.order = append(this.order, id)
+	}
+	this.trees[id] = tree
+	for len(this.order) > this.size {
+		delete(this.trees, this.order[0])
+		this.order = this.order[1:]
+	}
+}
@@ -0,0 +1,185 @@
+package merkle_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"merkle"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//pairs makes the digests of count keys with the value "value"
+func pairs(count int) map[string][]byte {
+	res := make(map[string][]byte)
+	for i := 0; i < count; i++ {
+		res[fmt.Sprint("key", i)] = merkle.Digest("value")
+	}
+	return res
+}
+
+//local answers a Diff from tree as another node would, and counts the hashes asked for
+func local(tree *merkle.Tree, asked *int) merkle.Remote {
+	return func(level int, indexes []int) ([][]byte, error) {
+		*asked += len(indexes)
+		return tree.Nodes(level, indexes), nil
+	}
+}
+
+func TestBuild(t *testing.T) {
+	digests := pairs(1000)
+	tree := merkle.Build(merkle.DefaultDepth, digests)
+	if tree.Depth() != merkle.DefaultDepth {
+		t.Errorf("depth = %d, want %d", tree.Depth(), merkle.DefaultDepth)
+	}
+	leaves := make([]int, 1<<merkle.DefaultDepth)
+	for i := range leaves {
+		leaves[i] = i
+	}
+	if hashes := tree.Nodes(merkle.DefaultDepth, leaves); len(hashes) != len(leaves) || hashes[len(leaves)-1] == nil {
+		t.Errorf("the tree has no %d leaves", len(leaves))
+	}
+	if hashes := tree.Nodes(merkle.DefaultDepth+1, []int{0}); hashes[0] != nil {
+		t.Errorf("a level below the leaves has a hash")
+	}
+	if hashes := tree.Nodes(0, []int{0, 1}); !bytes.Equal(hashes[0], tree.Root()) || hashes[1] != nil {
+		t.Errorf("level 0 is not the root alone")
+	}
+
+	//the same pairs give the same tree, one more value changes the root and one leaf
+	same := merkle.Build(merkle.DefaultDepth, pairs(1000))
+	if !bytes.Equal(tree.Root(), same.Root()) {
+		t.Errorf("two trees of the same pairs differ")
+	}
+	digests["key7"] = merkle.Digest("other")
+	changed := merkle.Build(merkle.DefaultDepth, digests)
+	if bytes.Equal(tree.Root(), changed.Root()) {
+		t.Errorf("a changed value keeps the root")
+	}
+	before := tree.Nodes(merkle.DefaultDepth, leaves)
+	after := changed.Nodes(merkle.DefaultDepth, leaves)
+	for i := range leaves {
+		if bytes.Equal(before[i], after[i]) != (i != merkle.Bucket("key7", merkle.DefaultDepth)) {
+			t.Errorf("leaf %d changed: %t, but key7 is in leaf %d", i, !bytes.Equal(before[i], after[i]), merkle.Bucket("key7", merkle.DefaultDepth))
+		}
+	}
+
+	//the depth is kept in [0, 16]
+	if depth := merkle.Build(20, digests).Depth(); depth != 16 {
+		t.Errorf("depth of a tree built with 20 = %d, want 16", depth)
+	}
+	if depth := merkle.Build(-1, digests).Depth(); depth != 0 {
+		t.Errorf("depth of a tree built with -1 = %d, want 0", depth)
+	}
+	if merkle.Build(0, nil).Root() == nil {
+		t.Errorf("an empty tree has no root")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	mine := pairs(1000)
+	theirs := pairs(1000)
+	asked := 0
+	tree := merkle.Build(merkle.DefaultDepth, mine)
+	leaves, tmp_err := tree.Diff(local(merkle.Build(merkle.DefaultDepth, theirs), &asked))
+	if tmp_err != nil || len(leaves) != 0 || asked != 1 {
+		t.Errorf("diff of the same pairs = %v, %v after %d hashes, want only the root", leaves, tmp_err, asked)
+	}
+
+	//a changed value, a missing key and an extra key
+	theirs["key1"] = merkle.Digest("other")
+	delete(theirs, "key2")
+	theirs["extra"] = merkle.Digest("value")
+	var want []int
+	for _, key := range []string{"key1", "key2", "extra"} {
+		want = append(want, merkle.Bucket(key, merkle.DefaultDepth))
+	}
+	sort.Ints(want)
+	asked = 0
+	leaves, tmp_err = tree.Diff(local(merkle.Build(merkle.DefaultDepth, theirs), &asked))
+	sort.Ints(leaves)
+	if tmp_err != nil || !reflect.DeepEqual(leaves, want) {
+		t.Errorf("diff = %v, %v, want the leaves %v", leaves, tmp_err, want)
+	}
+	for _, key := range []string{"key1", "key2", "extra"} {
+		if !merkle.Contains(leaves, key, merkle.DefaultDepth) {
+			t.Errorf("the differing leaves do not contain %s", key)
+		}
+	}
+	//the root, its 16 children 4 levels down, and the 16 leaves under each differing child
+	children := make(map[int]bool)
+	for _, leaf := range want {
+		children[leaf>>4] = true
+	}
+	if asked != 1+16+16*len(children) {
+		t.Errorf("diff asked for %d hashes, want %d", asked, 1+16+16*len(children))
+	}
+
+	fail := errors.New("unreachable")
+	_, tmp_err = tree.Diff(func(level int, indexes []int) ([][]byte, error) {
+		return nil, fail
+	})
+	if !errors.Is(tmp_err, fail) {
+		t.Errorf("diff with a failing remote: %v, want its error", tmp_err)
+	}
+	_, tmp_err = tree.Diff(func(level int, indexes []int) ([][]byte, error) {
+		return make([][]byte, len(indexes)+1), nil
+	})
+	if !errors.Is(tmp_err, merkle.ErrBadAnswer) {
+		t.Errorf("diff with a wrong answer: %v, want ErrBadAnswer", tmp_err)
+	}
+}
+
+func TestContains(t *testing.T) {
+	bucket := merkle.Bucket("key", merkle.DefaultDepth)
+	if bucket < 0 || bucket >= 1<<merkle.DefaultDepth {
+		t.Fatalf("bucket of key = %d, out of the leaves", bucket)
+	}
+	if !merkle.Contains([]int{bucket + 1, bucket}, "key", merkle.DefaultDepth) {
+		t.Errorf("the leaves %v do not contain key", []int{bucket + 1, bucket})
+	}
+	if merkle.Contains([]int{bucket + 1}, "key", merkle.DefaultDepth) || merkle.Contains(nil, "key", merkle.DefaultDepth) {
+		t.Errorf("leaves without the bucket %d contain key", bucket)
+	}
+	//a leaf of a shallower tree is the prefix of the deeper bucket
+	if merkle.Bucket("key", 4) != bucket>>(merkle.DefaultDepth-4) {
+		t.Errorf("bucket at depth 4 = %d, want %d", merkle.Bucket("key", 4), bucket>>(merkle.DefaultDepth-4))
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := merkle.NewCache(2)
+	first := merkle.Build(0, nil)
+	second := merkle.Build(1, nil)
+	third := merkle.Build(2, nil)
+	cache.Put("a", first)
+	cache.Put("b", second)
+	if cache.Get("a") != first || cache.Get("b") != second || cache.Get("c") != nil {
+		t.Errorf("the cache does not give back what is put")
+	}
+	//putting a again does not make it newer, so it is the first forgotten
+	cache.Put("a", third)
+	if cache.Get("a") != third {
+		t.Errorf("the tree of a is not replaced")
+	}
+	cache.Put("c", first)
+	if cache.Get("a") != nil || cache.Get("b") != second || cache.Get("c") != first {
+		t.Errorf("the cache does not forget the oldest tree")
+	}
+
+	small := merkle.NewCache(0)
+	small.Put("a", first)
+	small.Put("b", second)
+	if small.Get("a") != nil || small.Get("b") != second {
+		t.Errorf("a cache made with size 0 does not keep the last tree")
+	}
+	var none *merkle.Cache
+	none.Put("a", first)
+	if none.Get("a") != nil {
+		t.Errorf("a nil cache keeps a tree")
+	}
+	if merkle.NewRound() == merkle.NewRound() {
+		t.Errorf("two rounds have the same identifier")
+	}
+}
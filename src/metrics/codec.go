@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+//ServedFunc is called when a rpc call is answered.
+type ServedFunc func(method string, duration time.Duration, failed bool)
+
+//servedCodec wraps a server codec of net/rpc and reports every answered call.
+type servedCodec struct {
+	rpc.ServerCodec
+
+	served   ServedFunc
+	lock     sync.Mutex
+	received map[uint64]time.Time
+}
+
+//NewServerCodec is used as rpc.Server.ServeCodec(NewServerCodec(conn, served)) in place of ServeConn.
+func NewServerCodec(conn io.ReadWriteCloser, served ServedFunc) rpc.ServerCodec {
+	return WrapServerCodec(newGobServerCodec(conn), served)
+}
+
+//WrapServerCodec reports the calls answered through codec to served.
+func WrapServerCodec(codec rpc.ServerCodec, served ServedFunc) rpc.ServerCodec {
+	return &servedCodec{
+		ServerCodec: codec,
+		served:      served,
+		received:    make(map[uint64]time.Time),
+	}
+}
+
+func (this *servedCodec) ReadRequestHeader(r *rpc.Request) error {
+	tmp_err := this.ServerCodec.ReadRequestHeader(r)
+	if tmp_err == nil {
+		this.lock.Lock()
+		this.received[r.Seq] = time.Now()
+		this.lock.Unlock()
+	}
+	return tmp_err
+}
+
+func (this *servedCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	this.lock.Lock()
+	start, ok := this.received[r.Seq]
+	delete(this.received, r.Seq)
+	this.lock.Unlock()
+	if ok {
+		this.served(r.ServiceMethod, time.Since(start), r.Error != "")
+	}
+	return this.ServerCodec.WriteResponse(r, body)
+}
+
+//gobServerCodec is the gob codec of net/rpc.
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+//newGobServerCodec returns the codec rpc.ServeConn uses.
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (this *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return this.dec.Decode(r)
+}
+
+func (this *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return this.dec.Decode(body)
+}
+
+func (this *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	tmp_err := this.enc.Encode(r)
+	if tmp_err == nil {
+		tmp_err = this.enc.Encode(body)
+	}
+	if tmp_err != nil {
+		if this.encBuf.Flush() == nil {
+			this.Close()
+		}
+		return tmp_err
+	}
+	return this.encBuf.Flush()
+}
+
+func (this *gobServerCodec) Close() error {
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	return this.rwc.Close()
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Default is the registry the chord and kademlia nodes of this process report to,
+//every node puts its address in the "node" label.
+var Default = NewRegistry()
+
+//DefaultBuckets are used for latencies in seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+//Registry keeps counters, histograms and gauges by name and labels,
+//and writes them in the Prometheus text format.
+type Registry struct {
+	lock     sync.RWMutex
+	families map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	metrics map[string]interface{}
+}
+
+//Counter only goes up.
+type Counter struct {
+	labels string
+	lock   sync.Mutex
+	value  float64
+}
+
+//Histogram counts the observed values in buckets.
+type Histogram struct {
+	labels  string
+	buckets []float64
+	lock    sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+//Gauge is read by calling its function when the registry is written.
+type Gauge struct {
+	labels string
+	lock   sync.Mutex
+	fn     func() float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+//escapes a label value, built once since every call to a metric formats its labels
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//labels are given as key, value, key, value ...
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := labelEscaper.Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (this *Registry) get(name string, help string, kind string, labels []string, create func(string) interface{}) interface{} {
+	key := formatLabels(labels)
+	this.lock.RLock()
+	fam, ok := this.families[name]
+	if ok {
+		if res, ok := fam.metrics[key]; ok {
+			this.lock.RUnlock()
+			return res
+		}
+	}
+	this.lock.RUnlock()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fam, ok = this.families[name]
+	if !ok {
+		fam = &family{name: name, help: help, kind: kind, metrics: make(map[string]interface{})}
+		this.families[name] = fam
+	}
+	if fam.kind != kind {
+		panic("metrics: " + name + " is registered as a " + fam.kind)
+	}
+	res, ok := fam.metrics[key]
+	if !ok {
+		res = create(key)
+		fam.metrics[key] = res
+	}
+	return res
+}
+
+//Counter returns the counter of name with the labels, it is created the first time.
+func (this *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return this.get(name, help, "counter", labels, func(key string) interface{} {
+		return &Counter{labels: key}
+	}).(*Counter)
+}
+
+//Histogram returns the histogram of name with the labels, the buckets are only
+//used when it is created.
+func (this *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return this.get(name, help, "histogram", labels, func(key string) interface{} {
+		res := &Histogram{labels: key, buckets: append([]float64(nil), buckets...)}
+		sort.Float64s(res.buckets)
+		res.counts = make([]uint64, len(res.buckets))
+		return res
+	}).(*Histogram)
+}
+
+//Unregister removes the metric of name with the labels, such as the gauge of a node which quits.
+func (this *Registry) Unregister(name string, labels ...string) {
+	key := formatLabels(labels)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	fam, ok := this.families[name]
+	if !ok {
+		return
+	}
+	delete(fam.metrics, key)
+	if len(fam.metrics) == 0 {
+		delete(this.families, name)
+	}
+}
+
+//Gauge sets the function read for name with the labels, it replaces the old one.
+func (this *Registry) Gauge(name string, help string, fn func() float64, labels ...string) {
+	gauge := this.get(name, help, "gauge", labels, func(key string) interface{} {
+		return &Gauge{labels: key}
+	}).(*Gauge)
+	gauge.lock.Lock()
+	gauge.fn = fn
+	gauge.lock.Unlock()
+}
+
+func (this *Counter) Inc() {
+	this.Add(1)
+}
+
+func (this *Counter) Add(delta float64) {
+	this.lock.Lock()
+	this.value += delta
+	this.lock.Unlock()
+}
+
+func (this *Counter) Value() float64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.value
+}
+
+func (this *Histogram) Observe(value float64) {
+	this.lock.Lock()
+	for i, bound := range this.buckets {
+		if value <= bound {
+			this.counts[i]++
+		}
+	}
+	this.count++
+	this.sum += value
+	this.lock.Unlock()
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+//add a label to a formatted label set
+func withLabel(labels string, key string, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, key, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+//WriteText writes all metrics in the Prometheus text exposition format.
+func (this *Registry) WriteText(w io.Writer) error {
+	this.lock.RLock()
+	var names []string
+	for name := range this.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var builder strings.Builder
+	for _, name := range names {
+		fam := this.families[name]
+		fmt.Fprintf(&builder, "# HELP %s %s\n# TYPE %s %s\n", name, fam.help, name, fam.kind)
+		var keys []string
+		for key := range fam.metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			switch metric := fam.metrics[key].(type) {
+			case *Counter:
+				fmt.Fprintf(&builder, "%s%s %s\n", name, key, formatFloat(metric.Value()))
+			case *Gauge:
+				metric.lock.Lock()
+				fn := metric.fn
+				metric.lock.Unlock()
+				fmt.Fprintf(&builder, "%s%s %s\n", name, key, formatFloat(fn()))
+			case *Histogram:
+				metric.lock.Lock()
+				for i, bound := range metric.buckets {
+					fmt.Fprintf(&builder, "%s_bucket%s %d\n", name, withLabel(key, "le", formatFloat(bound)), metric.counts[i])
+				}
+				fmt.Fprintf(&builder, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), metric.count)
+				fmt.Fprintf(&builder, "%s_sum%s %s\n", name, key, formatFloat(metric.sum))
+				fmt.Fprintf(&builder, "%s_count%s %d\n", name, key, metric.count)
+				metric.lock.Unlock()
+			}
+		}
+	}
+	this.lock.RUnlock()
+	_, tmp_err := io.WriteString(w, builder.String())
+	return tmp_err
+}
+
+func (this *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	this.WriteText(w)
+}
+
+//Serve exposes the registry at http://address/metrics until the server is closed.
+func (this *Registry) Serve(address string) (*http.Server, error) {
+	lis, tmp_err := net.Listen("tcp", address)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", this)
+	server := &http.Server{Handler: mux}
+	go server.Serve(lis)
+	return server, nil
+}
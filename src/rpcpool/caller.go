@@ -0,0 +1,169 @@
+package rpcpool
+
+import (
+	"crypto/tls"
+	"dht"
+	"errors"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Settings are what the calls of a node to other nodes are made with.
+type Settings struct {
+	Transport dht.Transport
+	TLS       *tls.Config
+	//the cluster key, as a string so that Settings can be a map key
+	Key   string
+	Clock dht.Clock
+}
+
+//Caller makes the calls to other nodes with its Settings, the nodes with the same
+//settings share one and its pooled connections.
+type Caller struct {
+	Settings
+	//signs the calls if Key is set
+	Auth *dht.ClusterAuth
+	Pool *Pool
+}
+
+//Callers keeps the callers of a protocol by their settings, and the settings of
+//the caller of the tools calling nodes, which is not a node itself.
+type Callers struct {
+	//makes the DialFunc of the pool of a caller, such as Caller.Dial with the tries of the protocol
+	dial     func(c *Caller) DialFunc
+	callers  map[Settings]*Caller
+	defaults Settings
+	lock     sync.Mutex
+}
+
+func NewCallers(dial func(c *Caller) DialFunc) *Callers {
+	return &Callers{dial: dial, callers: make(map[Settings]*Caller)}
+}
+
+//For returns the caller of the settings, it is made on first use.
+func (this *Callers) For(key Settings) *Caller {
+	if key.Clock == nil {
+		key.Clock = dht.RealClock
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	//a transport which can not be a map key gets a caller of its own
+	shared := key.Transport == nil || reflect.TypeOf(key.Transport).Comparable()
+	if shared {
+		if res, ok := this.callers[key]; ok {
+			return res
+		}
+	}
+	res := &Caller{Settings: key}
+	if key.Key != "" {
+		res.Auth = dht.NewClusterAuth([]byte(key.Key))
+	}
+	res.Pool = NewWithClock(this.dial(res), 32, 30*time.Second, res.Clock)
+	if shared {
+		this.callers[key] = res
+	}
+	return res
+}
+
+//Default returns the caller of the tools, set by SetTransport, SetClientTLS, SetClusterKey
+//and SetClock, nil means TCP, plaintext, not signed and dht.RealClock.
+func (this *Callers) Default() *Caller {
+	this.lock.Lock()
+	key := this.defaults
+	this.lock.Unlock()
+	return this.For(key)
+}
+
+func (this *Callers) SetClientTLS(conf *tls.Config) {
+	this.lock.Lock()
+	this.defaults.TLS = conf
+	this.lock.Unlock()
+}
+
+func (this *Callers) SetTransport(t dht.Transport) {
+	this.lock.Lock()
+	this.defaults.Transport = t
+	this.lock.Unlock()
+}
+
+func (this *Callers) SetClock(c dht.Clock) {
+	this.lock.Lock()
+	this.defaults.Clock = c
+	this.lock.Unlock()
+}
+
+func (this *Callers) SetClusterKey(key []byte) {
+	this.lock.Lock()
+	this.defaults.Key = string(key)
+	this.lock.Unlock()
+}
+
+//a transport which tells the callers apart gets connections of its own for each
+//calling node, they are pooled by "from>addr"
+const fromSeparator = ">"
+
+//PoolKey is the key in Pool of the calls to addr made by the node at from,
+//"" for a caller which is not a node.
+func (this *Caller) PoolKey(from string, addr string) string {
+	_, ok := this.Transport.(dht.SourceTransport)
+	if !ok || from == "" {
+		return addr
+	}
+	return from + fromSeparator + addr
+}
+
+//Dial connects to the pool key with the settings of the caller, once.
+func (this *Caller) Dial(key string) (*rpc.Client, error) {
+	transport := this.Transport
+	addr := key
+	if pos := strings.Index(key, fromSeparator); pos >= 0 {
+		transport = dht.From(transport, key[:pos])
+		addr = key[pos+len(fromSeparator):]
+	}
+	conn, tmp_err := dht.Dial(transport, addr, this.TLS, 0)
+	if tmp_err != nil {
+		return nil, tmp_err
+	}
+	if this.Auth != nil {
+		return rpc.NewClientWithCodec(this.Auth.NewClientCodec(conn, addr)), nil
+	}
+	return rpc.NewClient(conn), nil
+}
+
+//ErrDialTimeout is returned by DialWait when no try connects in time.
+var ErrDialTimeout = errors.New("rpcpool: dial timed out")
+
+//the outcome of a try of DialWait
+type dialResult struct {
+	client *rpc.Client
+	err    error
+}
+
+//DialWait is Dial, but a try which takes longer than wait is given up for the next one,
+//up to tries tries. A dial which fails returns at once.
+func (this *Caller) DialWait(key string, tries int, wait time.Duration) (*rpc.Client, error) {
+	for i := 0; i < tries; i++ {
+		//each try has its own buffered channel, so a dial which returns after
+		//the wait neither blocks for ever nor overwrites the result of the next try
+		done := make(chan dialResult, 1)
+		go func() {
+			client, tmp_err := this.Dial(key)
+			done <- dialResult{client, tmp_err}
+		}()
+		select {
+		case res := <-done:
+			return res.client, res.err
+		case <-dht.After(this.Clock, wait):
+			//nobody takes the late connection
+			go func() {
+				if res := <-done; res.client != nil {
+					res.client.Close()
+				}
+			}()
+		}
+	}
+	return nil, ErrDialTimeout
+}
@@ -0,0 +1,120 @@
+package rpcpool_test
+
+import (
+	"dht"
+	"errors"
+	"net"
+	"rpcpool"
+	"testing"
+	"time"
+)
+
+//listen serves a Service at addr of transport until the test ends
+func listen(t *testing.T, transport dht.Transport, addr string) {
+	t.Helper()
+	lis, tmp_err := dht.Listen(transport, addr, nil)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	s := new_server(t)
+	go s.rpc.Accept(lis)
+}
+
+//tagged can not be a map key
+type tagged struct {
+	dht.Transport
+	tags []string
+}
+
+//stalled does not connect until release is closed
+type stalled struct {
+	dht.Transport
+	release chan bool
+}
+
+func (this stalled) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	<-this.release
+	return nil, errors.New("stalled")
+}
+
+func TestCallers(t *testing.T) {
+	callers := rpcpool.NewCallers(func(c *rpcpool.Caller) rpcpool.DialFunc {
+		return c.Dial
+	})
+	network := dht.NewMemoryNetwork()
+	settings := rpcpool.Settings{Transport: network, Key: "key"}
+	first := callers.For(settings)
+	if callers.For(settings) != first {
+		t.Errorf("two callers for the same settings")
+	}
+	if first.Clock != dht.RealClock || first.Auth == nil {
+		t.Errorf("the caller has the clock %v and the auth %v, want dht.RealClock and an auth of the key", first.Clock, first.Auth)
+	}
+	if callers.For(rpcpool.Settings{Transport: network}) == first {
+		t.Errorf("the caller without a key is the one with the key")
+	}
+	odd := rpcpool.Settings{Transport: tagged{network, nil}}
+	if callers.For(odd) == callers.For(odd) {
+		t.Errorf("a transport which can not be a map key shares its caller")
+	}
+
+	//the tools call with the settings set last
+	if callers.Default().Transport != nil {
+		t.Errorf("the default caller has the transport %v, want nil for TCP", callers.Default().Transport)
+	}
+	callers.SetTransport(network)
+	callers.SetClusterKey([]byte("key"))
+	if callers.Default() != first {
+		t.Errorf("the default caller is not the one of its settings")
+	}
+	callers.SetTransport(nil)
+	callers.SetClusterKey(nil)
+	if callers.Default() == first {
+		t.Errorf("the default caller keeps the settings set before")
+	}
+}
+
+func TestCallerDial(t *testing.T) {
+	callers := rpcpool.NewCallers(func(c *rpcpool.Caller) rpcpool.DialFunc {
+		return c.Dial
+	})
+	network := dht.NewMemoryNetwork()
+	listen(t, network, "b:1")
+	caller := callers.For(rpcpool.Settings{Transport: network})
+	if key := caller.PoolKey("a:1", "b:1"); key != "b:1" {
+		t.Errorf("pool key on a memory network = %q, want the address", key)
+	}
+	var res int
+	tmp_err := caller.Pool.Call(caller.PoolKey("a:1", "b:1"), "Service.Echo", 7, &res)
+	if tmp_err != nil || res != 7 {
+		t.Errorf("echo 7 = %d, %v", res, tmp_err)
+	}
+
+	//a transport telling the callers apart dials from the calling node
+	fault := dht.NewFaultNetwork(network, 1)
+	fault.Partition([]string{"a:1"}, []string{"b:1"})
+	caller = callers.For(rpcpool.Settings{Transport: fault})
+	key := caller.PoolKey("a:1", "b:1")
+	if key != "a:1>b:1" || caller.PoolKey("", "b:1") != "b:1" {
+		t.Errorf("pool keys on a fault network are %q and %q", key, caller.PoolKey("", "b:1"))
+	}
+	_, tmp_err = caller.Dial(key)
+	if !errors.Is(tmp_err, dht.ErrPartitioned) {
+		t.Errorf("dial across the partition: %v, want ErrPartitioned", tmp_err)
+	}
+	client, tmp_err := caller.DialWait(caller.PoolKey("", "b:1"), 3, time.Second)
+	if tmp_err != nil {
+		t.Fatalf("dial from a tool: %v", tmp_err)
+	}
+	client.Close()
+
+	release := make(chan bool)
+	defer close(release)
+	caller = callers.For(rpcpool.Settings{Transport: stalled{network, release}})
+	start := time.Now()
+	_, tmp_err = caller.DialWait("b:1", 3, 10*time.Millisecond)
+	if !errors.Is(tmp_err, rpcpool.ErrDialTimeout) || time.Since(start) > time.Second {
+		t.Errorf("dial which never connects: %v after %v, want ErrDialTimeout after 3 tries", tmp_err, time.Since(start))
+	}
+}
@@ -0,0 +1,339 @@
+package rpcpool
+
+import (
+	"context"
+	"dht"
+	"errors"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//how long a call waits for a free slot of its peer
+const acquireTimeout = 5 * time.Second
+
+var ErrPoolClosed = errors.New("rpcpool: pool is closed")
+var ErrBusy = errors.New("rpcpool: too many calls in flight")
+
+//DialFunc creates a new rpc client connected to addr.
+type DialFunc func(addr string) (*rpc.Client, error)
+
+//Pool keeps persistent rpc connections for every peer, so a call only dials
+//when there is no idle connection to its peer.
+//At most maxConns calls to the same peer run at the same time, a connection
+//idle for longer than idleTimeout is closed, and all idle connections of a peer
+//are dropped once a call to it fails in the transport.
+type Pool struct {
+	dial        DialFunc
+	maxConns    int
+	idleTimeout time.Duration
+	//measures the idle time and the wait for a slot
+	clock dht.Clock
+
+	peers map[string]*peer
+	//bumped by EvictAll, a connection dialed before is not put back
+	gen    uint64
+	lock   sync.Mutex
+	closed bool
+}
+
+type peer struct {
+	idle  []*conn
+	slots chan bool
+	//calls holding or waiting for a slot, the peer is kept while there is any,
+	//so that they all share the same slots
+	users int
+}
+
+type conn struct {
+	client   *rpc.Client
+	lastUsed time.Time
+	gen      uint64
+}
+
+func New(dial DialFunc, maxConns int, idleTimeout time.Duration) *Pool {
+	return NewWithClock(dial, maxConns, idleTimeout, dht.RealClock)
+}
+
+//NewWithClock is New measuring time on clock, such as the clock of the nodes using the pool.
+func NewWithClock(dial DialFunc, maxConns int, idleTimeout time.Duration, clock dht.Clock) *Pool {
+	if maxConns < 1 {
+		maxConns = 1
+	}
+	res := &Pool{
+		dial:        dial,
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		clock:       clock,
+		peers:       make(map[string]*peer),
+	}
+	clock.Go(res.janitor)
+	return res
+}
+
+//Call invokes method on addr with a pooled connection.
+func (this *Pool) Call(addr string, method string, args interface{}, reply interface{}) error {
+	return this.CallContext(context.Background(), addr, method, args, reply)
+}
+
+//CallContext is like Call, but it gives up as soon as ctx is done.
+//reply is only written when the call succeeds in time.
+func (this *Pool) CallContext(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	p, tmp_err := this.acquire(ctx, addr)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	defer this.release(p)
+	c, reused, tmp_err := this.get(ctx, addr, p)
+	if tmp_err != nil {
+		return tmp_err
+	}
+	tmp_err = this.invoke(ctx, c, method, args, reply)
+	if tmp_err != nil && reused && isBroken(tmp_err) && ctx.Err() == nil {
+		//the idle connection was closed by the peer, try once with a new one
+		c.client.Close()
+		c, tmp_err = this.newConn(ctx, addr)
+		if tmp_err != nil {
+			this.evict(addr)
+			return tmp_err
+		}
+		tmp_err = this.invoke(ctx, c, method, args, reply)
+	}
+	if tmp_err != nil && isBroken(tmp_err) {
+		c.client.Close()
+		if ctx.Err() == nil {
+			this.evict(addr)
+		}
+		return tmp_err
+	}
+	this.put(addr, p, c)
+	return tmp_err
+}
+
+//Evict closes all idle connections to addr.
+func (this *Pool) Evict(addr string) {
+	this.evict(addr)
+}
+
+//EvictAll closes all idle connections, and the connections in use are closed
+//instead of being put back, so every later call dials again.
+func (this *Pool) EvictAll() {
+	var idle []*conn
+	this.lock.Lock()
+	this.gen++
+	for addr, p := range this.peers {
+		idle = append(idle, p.idle...)
+		p.idle = nil
+		if p.users == 0 {
+			delete(this.peers, addr)
+		}
+	}
+	this.lock.Unlock()
+	for _, c := range idle {
+		c.client.Close()
+	}
+}
+
+//Close closes all idle connections and stops the pool.
+func (this *Pool) Close() {
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		return
+	}
+	this.closed = true
+	peers := this.peers
+	this.peers = make(map[string]*peer)
+	this.lock.Unlock()
+	for _, p := range peers {
+		for _, c := range p.idle {
+			c.client.Close()
+		}
+	}
+}
+
+//private functions:
+
+//an error that is not returned by the remote method means the connection is useless
+func isBroken(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return !ok
+}
+
+func (this *Pool) acquire(ctx context.Context, addr string) (*peer, error) {
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		return nil, ErrPoolClosed
+	}
+	p, ok := this.peers[addr]
+	if !ok {
+		p = &peer{slots: make(chan bool, this.maxConns)}
+		this.peers[addr] = p
+	}
+	p.users++
+	this.lock.Unlock()
+	select {
+	case p.slots <- true:
+		return p, nil
+	default:
+	}
+	var tmp_err error
+	select {
+	case p.slots <- true:
+		return p, nil
+	case <-dht.After(this.clock, acquireTimeout):
+		tmp_err = ErrBusy
+	case <-ctx.Done():
+		tmp_err = ctx.Err()
+	}
+	this.lock.Lock()
+	p.users--
+	this.lock.Unlock()
+	return nil, tmp_err
+}
+
+func (this *Pool) release(p *peer) {
+	<-p.slots
+	this.lock.Lock()
+	p.users--
+	this.lock.Unlock()
+}
+
+func (this *Pool) get(ctx context.Context, addr string, p *peer) (*conn, bool, error) {
+	this.lock.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if this.clock.Now().Sub(c.lastUsed) < this.idleTimeout {
+			this.lock.Unlock()
+			return c, true, nil
+		}
+		c.client.Close()
+	}
+	this.lock.Unlock()
+	c, tmp_err := this.newConn(ctx, addr)
+	return c, false, tmp_err
+}
+
+func (this *Pool) newConn(ctx context.Context, addr string) (*conn, error) {
+	this.lock.Lock()
+	gen := this.gen
+	this.lock.Unlock()
+	if ctx.Done() == nil {
+		client, tmp_err := this.dial(addr)
+		if tmp_err != nil {
+			return nil, tmp_err
+		}
+		return &conn{client: client, gen: gen}, nil
+	}
+	type dialRes struct {
+		client *rpc.Client
+		err    error
+	}
+	resCh := make(chan dialRes, 1)
+	go func() {
+		client, tmp_err := this.dial(addr)
+		resCh <- dialRes{client, tmp_err}
+	}()
+	select {
+	case res := <-resCh:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return &conn{client: res.client, gen: gen}, nil
+	case <-ctx.Done():
+		//close the connection if the dial finishes later
+		go func() {
+			res := <-resCh
+			if res.client != nil {
+				res.client.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (this *Pool) invoke(ctx context.Context, c *conn, method string, args interface{}, reply interface{}) error {
+	if ctx.Done() == nil {
+		return c.client.Call(method, args, reply)
+	}
+	tmp_err := ctx.Err()
+	if tmp_err != nil {
+		return tmp_err
+	}
+	//decode into a new value, so that a late reply can not touch reply
+	replyv := reflect.New(reflect.TypeOf(reply).Elem())
+	call := c.client.Go(method, args, replyv.Interface(), make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			reflect.ValueOf(reply).Elem().Set(replyv.Elem())
+		}
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (this *Pool) put(addr string, p *peer, c *conn) {
+	c.lastUsed = this.clock.Now()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed || c.gen != this.gen || this.peers[addr] != p || len(p.idle) >= this.maxConns {
+		c.client.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (this *Pool) evict(addr string) {
+	this.lock.Lock()
+	p, ok := this.peers[addr]
+	var idle []*conn
+	if ok {
+		idle = p.idle
+		p.idle = nil
+	}
+	this.lock.Unlock()
+	for _, c := range idle {
+		c.client.Close()
+	}
+}
+
+//close the connections which are idle for too long, and forget peers without any connection
+func (this *Pool) janitor() {
+	interval := this.idleTimeout / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		this.clock.Sleep(interval)
+		var expired []*conn
+		this.lock.Lock()
+		if this.closed {
+			this.lock.Unlock()
+			return
+		}
+		now := this.clock.Now()
+		for addr, p := range this.peers {
+			alive := p.idle[:0]
+			for _, c := range p.idle {
+				if now.Sub(c.lastUsed) >= this.idleTimeout {
+					expired = append(expired, c)
+				} else {
+					alive = append(alive, c)
+				}
+			}
+			p.idle = alive
+			if len(p.idle) == 0 && p.users == 0 {
+				delete(this.peers, addr)
+			}
+		}
+		this.lock.Unlock()
+		for _, c := range expired {
+			c.client.Close()
+		}
+	}
+}
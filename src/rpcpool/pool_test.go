@@ -0,0 +1,202 @@
+package rpcpool_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"rpcpool"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Service counts the calls running at the same time
+type Service struct {
+	lock    sync.Mutex
+	running int
+	most    int
+	block   chan bool
+}
+
+func (this *Service) Wait(_ int, res *int) error {
+	this.lock.Lock()
+	this.running++
+	if this.running > this.most {
+		this.most = this.running
+	}
+	this.lock.Unlock()
+	<-this.block
+	this.lock.Lock()
+	this.running--
+	this.lock.Unlock()
+	return nil
+}
+
+func (this *Service) Echo(arg int, res *int) error {
+	*res = arg
+	return nil
+}
+
+func (this *Service) Fail(_ int, _ *int) error {
+	return errors.New("failed")
+}
+
+//server dials in memory, and can close all of its connections or refuse new ones
+type server struct {
+	service *Service
+	rpc     *rpc.Server
+	lock    sync.Mutex
+	conns   []net.Conn
+	dials   int
+	refuse  bool
+}
+
+func new_server(t *testing.T) *server {
+	res := &server{service: &Service{block: make(chan bool)}, rpc: rpc.NewServer()}
+	tmp_err := res.rpc.Register(res.service)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	return res
+}
+
+func (this *server) dial(addr string) (*rpc.Client, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.refuse {
+		return nil, errors.New("connection refused")
+	}
+	this.dials++
+	srv, cli := net.Pipe()
+	this.conns = append(this.conns, srv)
+	go this.rpc.ServeConn(srv)
+	return rpc.NewClient(cli), nil
+}
+
+func (this *server) dial_count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.dials
+}
+
+//crash closes every connection and refuses new ones
+func (this *server) crash() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, c := range this.conns {
+		c.Close()
+	}
+	this.conns = nil
+	this.refuse = true
+}
+
+func (this *server) restart() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.refuse = false
+}
+
+func TestLimit(t *testing.T) {
+	s := new_server(t)
+	//the janitor runs often while calls wait for their slots
+	pool := rpcpool.New(s.dial, 2, 10*time.Millisecond)
+	defer pool.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var res int
+			tmp_err := pool.Call("peer", "Service.Wait", 0, &res)
+			if tmp_err != nil {
+				t.Errorf("wait: %v", tmp_err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	//the slots are taken, so a call gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var res int
+	tmp_err := pool.CallContext(ctx, "peer", "Service.Echo", 1, &res)
+	if !errors.Is(tmp_err, context.DeadlineExceeded) {
+		t.Errorf("call with full slots: %v, want DeadlineExceeded", tmp_err)
+	}
+	for i := 0; i < 6; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.service.block <- true
+	}
+	wg.Wait()
+	if s.service.most != 2 {
+		t.Errorf("%d calls ran at the same time, want 2", s.service.most)
+	}
+}
+
+func TestIdleEviction(t *testing.T) {
+	s := new_server(t)
+	pool := rpcpool.New(s.dial, 2, 50*time.Millisecond)
+	defer pool.Close()
+	var res int
+	for i := 0; i < 3; i++ {
+		tmp_err := pool.Call("peer", "Service.Echo", i, &res)
+		if tmp_err != nil || res != i {
+			t.Fatalf("echo %d = %d, %v", i, res, tmp_err)
+		}
+	}
+	if s.dial_count() != 1 {
+		t.Fatalf("%d dials for sequential calls, want 1", s.dial_count())
+	}
+	time.Sleep(200 * time.Millisecond)
+	tmp_err := pool.Call("peer", "Service.Echo", 0, &res)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	if s.dial_count() != 2 {
+		t.Errorf("%d dials after the connection was idle, want 2", s.dial_count())
+	}
+}
+
+func TestEvictionOnError(t *testing.T) {
+	s := new_server(t)
+	pool := rpcpool.New(s.dial, 2, time.Minute)
+	defer pool.Close()
+	var res int
+	//an error of the remote method keeps the connection
+	tmp_err := pool.Call("peer", "Service.Fail", 0, &res)
+	if _, ok := tmp_err.(rpc.ServerError); !ok {
+		t.Fatalf("fail: %v, want a ServerError", tmp_err)
+	}
+	tmp_err = pool.Call("peer", "Service.Echo", 0, &res)
+	if tmp_err != nil || s.dial_count() != 1 {
+		t.Fatalf("echo: %v with %d dials, want 1", tmp_err, s.dial_count())
+	}
+	//two idle connections
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.Call("peer", "Service.Wait", 0, new(int))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.service.block <- true
+	s.service.block <- true
+	wg.Wait()
+	//the failed call drops both of them, not just the one it used
+	s.crash()
+	tmp_err = pool.Call("peer", "Service.Echo", 0, &res)
+	if tmp_err == nil {
+		t.Fatal("call to a crashed peer succeeded")
+	}
+	s.restart()
+	dials := s.dial_count()
+	tmp_err = pool.Call("peer", "Service.Echo", 0, &res)
+	if tmp_err != nil {
+		t.Fatal(tmp_err)
+	}
+	if s.dial_count() != dials+1 {
+		t.Errorf("%d dials after the failure, want 1", s.dial_count()-dials)
+	}
+}
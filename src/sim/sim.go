@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"container/heap"
+	"dht"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//Simulator runs a network of nodes in virtual time: it is the dht.Clock of the nodes
+//and its Network is their transport, so a test sets
+//
+//	conf.Clock = s
+//	conf.Transport = s.Network()
+//
+//and drives the nodes inside Run. The goroutines started with Go take turns: while one
+//of them runs, the others sleep, and when all of them sleep the clock jumps to the earliest
+//wake up and wakes that goroutine alone. A call to another node runs on behalf of the
+//caller, so the handler may sleep too. An hour of maintenance thus takes only the time
+//the calls need, and the faults, the turns and the random choices of the test follow from
+//the seed, so a failing run replays from its seed.
+//
+//The replay is exact as long as the nodes do not depend on things outside the simulator,
+//such as the order of a map. The nodes of a simulation share their connections and
+//round-trip times only with each other, so simulations can run one after another in a test.
+type Simulator struct {
+	seed    int64
+	rand    *rand.Rand
+	network *dht.FaultNetwork
+
+	lock sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+	//goroutines started by Go or Run which are not sleeping
+	running int
+	timers  timerHeap
+	//orders the timers of the same time by their creation
+	seq uint64
+}
+
+//the virtual time a simulator starts at
+var Epoch = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func New(seed int64) *Simulator {
+	res := &Simulator{seed: seed, rand: rand.New(rand.NewSource(seed)), now: Epoch}
+	res.cond = sync.NewCond(&res.lock)
+	res.network = dht.NewFaultNetwork(dht.NewMemoryNetwork(), res.rand.Int63())
+	res.network.SetClock(res)
+	return res
+}
+
+func (this *Simulator) Seed() int64 {
+	return this.seed
+}
+
+//Rand is the random source of the test, it should only be used inside Run.
+func (this *Simulator) Rand() *rand.Rand {
+	return this.rand
+}
+
+//Network is the transport of the nodes, its faults are delayed in virtual time.
+func (this *Simulator) Network() *dht.FaultNetwork {
+	return this.network
+}
+
+func (this *Simulator) Now() time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.now
+}
+
+//Sleep waits for d of virtual time, it should only be called by f of Run or Go
+//or by a call they make, a d of 0 lets the other goroutines take a turn.
+func (this *Simulator) Sleep(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	wake := make(chan struct{})
+	this.lock.Lock()
+	this.push(&timer{when: this.now.Add(d), wake: wake})
+	this.running--
+	this.cond.Broadcast()
+	this.lock.Unlock()
+	<-wake
+}
+
+//Go starts f when the running goroutines sleep.
+func (this *Simulator) Go(f func()) {
+	this.lock.Lock()
+	this.push(&timer{when: this.now, start: f})
+	this.lock.Unlock()
+}
+
+//Run calls f and simulates until f returns and the goroutines of Go sleep again.
+//It panics if they all wait on something else than the clock, as nothing could wake them.
+func (this *Simulator) Run(f func()) {
+	finished := false
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.running++
+	go func() {
+		f()
+		this.lock.Lock()
+		finished = true
+		this.exit()
+		this.lock.Unlock()
+	}()
+	for {
+		for this.running > 0 {
+			this.cond.Wait()
+		}
+		if finished {
+			return
+		}
+		if this.timers.Len() == 0 {
+			panic("sim: every goroutine waits on something else than the clock")
+		}
+		next := heap.Pop(&this.timers).(*timer)
+		if next.when.After(this.now) {
+			this.now = next.when
+		}
+		this.running++
+		if next.wake != nil {
+			close(next.wake)
+			continue
+		}
+		go func() {
+			next.start()
+			this.lock.Lock()
+			this.exit()
+			this.lock.Unlock()
+		}()
+	}
+}
+
+//need hold lock
+func (this *Simulator) exit() {
+	this.running--
+	this.cond.Broadcast()
+}
+
+//need hold lock
+func (this *Simulator) push(t *timer) {
+	this.seq++
+	t.seq = this.seq
+	heap.Push(&this.timers, t)
+}
+
+//a sleeping goroutine, or a goroutine of Go to start
+type timer struct {
+	when  time.Time
+	seq   uint64
+	wake  chan struct{}
+	start func()
+}
+
+type timerHeap []*timer
+
+func (this timerHeap) Len() int {
+	return len(this)
+}
+
+func (this timerHeap) Less(i, j int) bool {
+	if !this[i].when.Equal(this[j].when) {
+		return this[i].when.Before(this[j].when)
+	}
+	return this[i].seq < this[j].seq
+}
+
+func (this timerHeap) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func (this *timerHeap) Push(x interface{}) {
+	*this = append(*this, x.(*timer))
+}
+
+func (this *timerHeap) Pop() interface{} {
+	old := *this
+	res := old[len(old)-1]
+	*this = old[:len(old)-1]
+	return res
+}
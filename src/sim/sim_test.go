@@ -0,0 +1,183 @@
+package sim_test
+
+import (
+	"chord"
+	"dht"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"kademlia"
+	"sim"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVirtualTime(t *testing.T) {
+	s := sim.New(1)
+	var trace []string
+	start := time.Now()
+	s.Run(func() {
+		for i := 1; i <= 3; i++ {
+			i := i
+			s.Go(func() {
+				s.Sleep(time.Duration(4-i) * time.Hour)
+				trace = append(trace, fmt.Sprint(i, s.Now().Sub(sim.Epoch)))
+			})
+		}
+		s.Sleep(24 * time.Hour)
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a virtual day took %v", elapsed)
+	}
+	if got := s.Now().Sub(sim.Epoch); got != 24*time.Hour {
+		t.Errorf("clock is at %v, want 24h", got)
+	}
+	want := "3 1h0m0s, 2 2h0m0s, 1 3h0m0s"
+	if got := strings.Join(trace, ", "); got != want {
+		t.Errorf("goroutines woke as %q, want %q", got, want)
+	}
+}
+
+//run_ring drives a chord ring in a simulation with drops and latency,
+//and traces the outcome and the virtual time of every operation
+func run_ring(seed int64) []string {
+	const nodes = 5
+	s := sim.New(seed)
+	s.Network().SetDrop(0.02)
+	s.Network().SetLatency(dht.UniformLatency(time.Millisecond, 20*time.Millisecond))
+	conf := chord.DefaultConfig()
+	conf.AdvertiseAddress = "sim"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var trace []string
+	s.Run(func() {
+		var ring []*chord.ChordNode
+		for i := 0; i < nodes; i++ {
+			node := new(chord.ChordNode)
+			node.InitWithConfig(22000+i, conf)
+			node.Run()
+			ring = append(ring, node)
+		}
+		ring[0].Create()
+		for _, node := range ring[1:] {
+			trace = append(trace, fmt.Sprint("join ", node.Join(dht.JoinAddress("sim", 22000)), " ", s.Now()))
+			s.Sleep(time.Second)
+		}
+		s.Sleep(10 * time.Second)
+		for i := 0; i < 40; i++ {
+			key := fmt.Sprint("key", s.Rand().Intn(20))
+			node := ring[s.Rand().Intn(nodes)]
+			if s.Rand().Intn(2) == 0 {
+				trace = append(trace, fmt.Sprint("put ", key, " ", node.Put(key, fmt.Sprint(i)), " ", s.Now()))
+			} else {
+				value, tmp_err := node.Get(key)
+				trace = append(trace, fmt.Sprint("get ", key, " ", value, " ", tmp_err, " ", s.Now()))
+			}
+			s.Sleep(time.Duration(s.Rand().Intn(1000)) * time.Millisecond)
+		}
+		for _, node := range ring {
+			node.ForceQuit()
+		}
+	})
+	return trace
+}
+
+func TestReplay(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	first := run_ring(1)
+	second := run_ring(1)
+	if len(first) != len(second) {
+		t.Fatalf("the replay traced %d operations, the first run %d", len(second), len(first))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("operation %d differs in the replay:\n%s\n%s", i, first[i], second[i])
+		}
+	}
+}
+
+//run_network drives a kademlia network in a simulation with drops, latency and a partition
+//which heals, and traces the outcome and the virtual time of every operation
+func run_network(seed int64) []string {
+	const nodes = 8
+	s := sim.New(seed)
+	s.Network().SetDrop(0.02)
+	s.Network().SetLatency(dht.UniformLatency(time.Millisecond, 20*time.Millisecond))
+	conf := kademlia.DefaultConfig()
+	conf.AdvertiseAddress = "sim"
+	conf.Transport = s.Network()
+	conf.Clock = s
+	var trace []string
+	s.Run(func() {
+		var network []*kademlia.KadNode
+		var addrs []string
+		for i := 0; i < nodes; i++ {
+			node := new(kademlia.KadNode)
+			node.InitWithConfig(22000+i, conf)
+			node.Run()
+			network = append(network, node)
+			addrs = append(addrs, dht.JoinAddress("sim", 22000+i))
+		}
+		network[0].Create()
+		for _, node := range network[1:] {
+			trace = append(trace, fmt.Sprint("join ", node.Join(addrs[0]), " ", s.Now()))
+			s.Sleep(time.Second)
+		}
+		s.Sleep(10 * time.Second)
+		operate := func(count int) {
+			for i := 0; i < count; i++ {
+				key := fmt.Sprint("key", s.Rand().Intn(20))
+				node := network[s.Rand().Intn(nodes)]
+				if s.Rand().Intn(2) == 0 {
+					trace = append(trace, fmt.Sprint("put ", key, " ", node.Put(key, fmt.Sprint(i)), " ", s.Now()))
+				} else {
+					value, tmp_err := node.Get(key)
+					trace = append(trace, fmt.Sprint("get ", key, " ", value, " ", tmp_err, " ", s.Now()))
+				}
+				s.Sleep(time.Duration(s.Rand().Intn(1000)) * time.Millisecond)
+			}
+		}
+		operate(20)
+		s.Network().Partition(addrs[:nodes/2], addrs[nodes/2:])
+		operate(20)
+		s.Network().Heal()
+		//the nodes forgot the contacts which did not answer, so the cut off half
+		//only finds the other one again by joining through a node of it
+		for _, node := range network[nodes/2:] {
+			trace = append(trace, fmt.Sprint("join ", node.Join(addrs[0]), " ", s.Now()))
+		}
+		s.Sleep(10 * time.Second)
+		operate(20)
+		//with the partition healed every node reaches every key again
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprint("key", i)
+			put_err := network[s.Rand().Intn(nodes)].Put(key, "last")
+			value, tmp_err := network[s.Rand().Intn(nodes)].Get(key)
+			trace = append(trace, fmt.Sprint("check ", key, " ", put_err, " ", value, " ", tmp_err, " ", s.Now()))
+		}
+		for _, node := range network {
+			node.ForceQuit()
+		}
+	})
+	return trace
+}
+
+func TestReplayKademlia(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	first := run_network(1)
+	for _, line := range first {
+		if strings.HasPrefix(line, "check ") && !strings.Contains(line, " <nil> last <nil> ") {
+			t.Errorf("after the partition heals: %s", line)
+		}
+	}
+	second := run_network(1)
+	if len(first) != len(second) {
+		t.Fatalf("the replay traced %d operations, the first run %d", len(second), len(first))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("operation %d differs in the replay:\n%s\n%s", i, first[i], second[i])
+		}
+	}
+}